@@ -8,6 +8,7 @@ import (
 	"strconv"
 	"sync"
 	"time"
+	_ "time/tzdata" // the API and the workers resolve job time zones alike, host zoneinfo or not
 
 	"github.com/jinzhu/gorm"
 	_ "github.com/jinzhu/gorm/dialects/postgres" //postgres
@@ -15,18 +16,27 @@ import (
 	"github.com/radhian/reconciliation-system/handler"
 	"github.com/radhian/reconciliation-system/infra/db/dao"
 	"github.com/radhian/reconciliation-system/infra/locker"
+	"github.com/radhian/reconciliation-system/infra/redis"
 	reconciliationUsecase "github.com/radhian/reconciliation-system/usecase/reconciliation"
 )
 
 type CronWorkerConfig struct {
-	Interval time.Duration
-	Workers  int
+	Interval   time.Duration
+	Workers    int
+	Owner      string        // names this process in worker IDs
+	Drain      bool          // run a job's batches back to back instead of one per interval
+	TimeBudget time.Duration // how long a draining worker keeps one job
 }
 
 func (cfg CronWorkerConfig) startReconcileExecutorWorker(h *handler.ReconciliationHandler, workerID int) {
+	name := fmt.Sprintf("%s/%d", cfg.Owner, workerID)
+	var budget time.Duration
+	if cfg.Drain {
+		budget = cfg.TimeBudget
+	}
 	for {
 		ctx := context.Background()
-		err := h.ReconciliationExecution(ctx)
+		err := h.ReconciliationExecution(ctx, name, budget)
 		if err != nil {
 			if err.Error() == consts.NoProcessHandled {
 				log.Printf("[Worker %d] %s", workerID, err.Error())
@@ -35,12 +45,98 @@ func (cfg CronWorkerConfig) startReconcileExecutorWorker(h *handler.Reconciliati
 			}
 		} else {
 			log.Printf("[Worker %d] success", workerID)
+			// A draining worker goes straight on to the next job while there is one.
+			if cfg.Drain {
+				continue
+			}
 		}
 
 		time.Sleep(cfg.Interval)
 	}
 }
 
+// ReaperConfig sets how often jobs are checked for a dead worker and how long a job may go
+// without a heartbeat before it is taken back. Both settings are optional.
+type ReaperConfig struct {
+	Interval   time.Duration
+	StaleAfter time.Duration
+}
+
+func NewReaperConfig() (ReaperConfig, error) {
+	cfg := ReaperConfig{
+		Interval:   consts.DefaultReaperIntervalSec * time.Second,
+		StaleAfter: consts.DefaultStaleJobAfterSec * time.Second,
+	}
+
+	if intervalStr := os.Getenv("REAPER_INTERVAL_IN_SEC"); intervalStr != "" {
+		interval, err := strconv.Atoi(intervalStr)
+		if err != nil || interval < 1 {
+			return cfg, fmt.Errorf("invalid REAPER_INTERVAL_IN_SEC %q: must be a whole number of seconds, at least 1", intervalStr)
+		}
+		cfg.Interval = time.Duration(interval) * time.Second
+	}
+	// A worker beats every HeartbeatIntervalInSec, so a shorter threshold would take
+	// jobs back from healthy workers.
+	if staleStr := os.Getenv("STALE_JOB_AFTER_IN_SEC"); staleStr != "" {
+		stale, err := strconv.Atoi(staleStr)
+		if err != nil || stale < 3*consts.HeartbeatIntervalInSec {
+			return cfg, fmt.Errorf("invalid STALE_JOB_AFTER_IN_SEC %q: must be a whole number of seconds, at least %d", staleStr, 3*consts.HeartbeatIntervalInSec)
+		}
+		cfg.StaleAfter = time.Duration(stale) * time.Second
+	}
+	return cfg, nil
+}
+
+func (cfg ReaperConfig) startStaleJobReaper(h *handler.ReconciliationHandler) {
+	for {
+		recovered, err := h.RecoverStaleJobs(context.Background(), cfg.StaleAfter)
+		if err != nil {
+			log.Printf("[Reaper] error: %s", err.Error())
+		} else if recovered > 0 {
+			log.Printf("[Reaper] took back %d stale jobs", recovered)
+		}
+
+		time.Sleep(cfg.Interval)
+	}
+}
+
+// SchedulerConfig limits how many jobs of one operator run at once across all workers and
+// picks how workers run a job. Every setting is optional: no limit, and one batch per
+// poll interval unless WORKER_MODE is drain.
+type SchedulerConfig struct {
+	MaxRunningPerOperator int
+	WorkerMode            string
+	TimeBudget            time.Duration
+}
+
+func NewSchedulerConfig() (SchedulerConfig, error) {
+	cfg := SchedulerConfig{
+		WorkerMode: os.Getenv("WORKER_MODE"),
+		TimeBudget: consts.DefaultJobTimeBudgetSec * time.Second,
+	}
+	if cfg.WorkerMode == "" {
+		cfg.WorkerMode = consts.WorkerModeBatch
+	}
+	if cfg.WorkerMode != consts.WorkerModeBatch && cfg.WorkerMode != consts.WorkerModeDrain {
+		return cfg, fmt.Errorf("invalid WORKER_MODE %q: must be %s or %s", cfg.WorkerMode, consts.WorkerModeBatch, consts.WorkerModeDrain)
+	}
+	if budgetStr := os.Getenv("JOB_TIME_BUDGET_IN_SEC"); budgetStr != "" {
+		budget, err := strconv.Atoi(budgetStr)
+		if err != nil || budget < 1 {
+			return cfg, fmt.Errorf("invalid JOB_TIME_BUDGET_IN_SEC %q: must be a whole number of seconds, at least 1", budgetStr)
+		}
+		cfg.TimeBudget = time.Duration(budget) * time.Second
+	}
+	if maxStr := os.Getenv("MAX_RUNNING_JOBS_PER_OPERATOR"); maxStr != "" {
+		limit, err := strconv.Atoi(maxStr)
+		if err != nil || limit < 0 {
+			return cfg, fmt.Errorf("invalid MAX_RUNNING_JOBS_PER_OPERATOR %q: must be a whole number, 0 for no limit", maxStr)
+		}
+		cfg.MaxRunningPerOperator = limit
+	}
+	return cfg, nil
+}
+
 type AppConfig struct {
 	BatchSize     int
 	WorkerNumber  int
@@ -76,10 +172,61 @@ func NewAppConfig() (*AppConfig, error) {
 	return cfg, nil
 }
 
+// LockerConfig picks where job claims are kept. Every setting is optional: jobs are
+// claimed with leases in the database unless LOCKER_BACKEND says otherwise.
+type LockerConfig struct {
+	Backend       string
+	LeaseInSec    int
+	RedisAddr     string
+	RedisPassword string
+	RedisDB       int
+}
+
+func NewLockerConfig() (LockerConfig, error) {
+	cfg := LockerConfig{
+		Backend:       os.Getenv("LOCKER_BACKEND"),
+		LeaseInSec:    consts.DefaultLockLeaseSec,
+		RedisAddr:     os.Getenv("REDIS_ADDR"),
+		RedisPassword: os.Getenv("REDIS_PASSWORD"),
+	}
+	if cfg.Backend == "" {
+		cfg.Backend = consts.LockerBackendPostgres
+	}
+
+	if leaseStr := os.Getenv("LOCK_LEASE_IN_SEC"); leaseStr != "" {
+		lease, err := strconv.Atoi(leaseStr)
+		if err != nil || lease < 3 {
+			return cfg, fmt.Errorf("invalid LOCK_LEASE_IN_SEC %q: must be a whole number of seconds, at least 3", leaseStr)
+		}
+		cfg.LeaseInSec = lease
+	}
+	if dbStr := os.Getenv("REDIS_DB"); dbStr != "" {
+		redisDB, err := strconv.Atoi(dbStr)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid REDIS_DB: %v", err)
+		}
+		cfg.RedisDB = redisDB
+	}
+
+	switch cfg.Backend {
+	case consts.LockerBackendPostgres, consts.LockerBackendMemory:
+	case consts.LockerBackendRedis:
+		if cfg.RedisAddr == "" {
+			return cfg, fmt.Errorf("REDIS_ADDR is required when LOCKER_BACKEND is %s", consts.LockerBackendRedis)
+		}
+	default:
+		return cfg, fmt.Errorf("invalid LOCKER_BACKEND %q: must be %s, %s or %s",
+			cfg.Backend, consts.LockerBackendPostgres, consts.LockerBackendRedis, consts.LockerBackendMemory)
+	}
+	return cfg, nil
+}
+
 type App struct {
-	DB     *gorm.DB
-	Locker *locker.Locker
-	Config *AppConfig
+	DB        *gorm.DB
+	Locker    locker.Locker
+	Config    *AppConfig
+	Reaper    ReaperConfig
+	Scheduler SchedulerConfig
 }
 
 func (a *App) startCronWorker(cfg CronWorkerConfig) {
@@ -91,9 +238,11 @@ func (a *App) startCronWorker(cfg CronWorkerConfig) {
 	}
 
 	reconciliationDao := dao.NewDaoMethod(a.DB)
-	reconciliationUc := reconciliationUsecase.NewReconciliationUsecase(reconciliationDao, a.Locker, batchSize)
+	reconciliationUc := reconciliationUsecase.NewReconciliationUsecase(reconciliationDao, a.Locker, batchSize, a.Scheduler.MaxRunningPerOperator, "", "")
 	h := handler.NewReconciliationHandler(reconciliationUc)
 
+	go a.Reaper.startStaleJobReaper(h)
+
 	for i := 0; i < cfg.Workers; i++ {
 		wg.Add(1)
 		go func(workerID int) {
@@ -117,7 +266,22 @@ func (a *App) Initialize(DbHost, DbPort, DbUser, DbName, DbPassword string) {
 		fmt.Printf("We are connected to the database %s", DbName)
 	}
 
-	a.Locker = locker.New()
+	lockerCfg, err := NewLockerConfig()
+	if err != nil {
+		log.Fatal("Invalid locker config: ", err)
+	}
+	a.Locker = a.newLocker(lockerCfg)
+	log.Printf("Claiming jobs with the %s locker", lockerCfg.Backend)
+
+	a.Reaper, err = NewReaperConfig()
+	if err != nil {
+		log.Fatal("Invalid reaper config: ", err)
+	}
+
+	a.Scheduler, err = NewSchedulerConfig()
+	if err != nil {
+		log.Fatal("Invalid scheduler config: ", err)
+	}
 
 	a.Config, err = NewAppConfig()
 	if err != nil {
@@ -125,6 +289,30 @@ func (a *App) Initialize(DbHost, DbPort, DbUser, DbName, DbPassword string) {
 	}
 }
 
+// newLocker builds the configured locker. Only the database and Redis lockers keep cron
+// servers on different hosts apart; the memory locker is for running a single one.
+func (a *App) newLocker(cfg LockerConfig) locker.Locker {
+	lease := time.Duration(cfg.LeaseInSec) * time.Second
+	switch cfg.Backend {
+	case consts.LockerBackendMemory:
+		return locker.NewMemoryLocker()
+	case consts.LockerBackendRedis:
+		client := redis.NewClient(cfg.RedisAddr, cfg.RedisPassword, cfg.RedisDB, consts.DefaultRedisTimeoutInSec*time.Second)
+		return locker.NewRedisLocker(client, lockOwner(), lease)
+	default:
+		return locker.NewLeaseLocker(dao.NewDaoMethod(a.DB), lockOwner(), lease)
+	}
+}
+
+// lockOwner names this process in the claims it takes.
+func lockOwner() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
+
 func (a *App) RunServer() {
 	workerNumber := consts.DefaultWorkerNumber
 	intervalInSec := consts.DefaultIntervalInSec
@@ -134,8 +322,11 @@ func (a *App) RunServer() {
 	}
 
 	a.startCronWorker(CronWorkerConfig{
-		Workers:  workerNumber,
-		Interval: time.Duration(intervalInSec) * time.Second,
+		Workers:    workerNumber,
+		Interval:   time.Duration(intervalInSec) * time.Second,
+		Owner:      lockOwner(),
+		Drain:      a.Scheduler.WorkerMode == consts.WorkerModeDrain,
+		TimeBudget: a.Scheduler.TimeBudget,
 	})
 }
 
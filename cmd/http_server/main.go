@@ -5,10 +5,12 @@ import (
 	"log"
 	"net/http"
 	"os"
+	_ "time/tzdata" // the API and the workers resolve job time zones alike, host zoneinfo or not
 
 	"github.com/gorilla/mux"
 	"github.com/jinzhu/gorm"
 	_ "github.com/jinzhu/gorm/dialects/postgres" //postgres
+	"github.com/radhian/reconciliation-system/consts"
 	"github.com/radhian/reconciliation-system/handler"
 	"github.com/radhian/reconciliation-system/infra/db/dao"
 	"github.com/radhian/reconciliation-system/infra/db/model"
@@ -37,7 +39,17 @@ func (a *App) Initialize(DbHost, DbPort, DbUser, DbName, DbPassword string) {
 	a.DB.Debug().AutoMigrate(
 		&model.ReconciliationProcessLog{},
 		&model.ReconciliationProcessLogAsset{},
+		&model.ReconciliationBankConsumption{},
+		&model.ReconciliationMatch{},
+		&model.ReconciliationCsvProfile{},
+		&model.ReconciliationBankRow{},
+		&model.ReconciliationRejectedRow{},
+		&model.ReconciliationUnmatchedTransaction{},
+		&model.ReconciliationJobLease{},
 	) //database migration
+	// AutoMigrate does not widen existing columns; match tables created with a shorter bank
+	// identifier would reject the long references staging accepts.
+	a.DB.Model(&model.ReconciliationMatch{}).ModifyColumn("bank_identifier", "varchar(255)")
 
 	a.Router = mux.NewRouter().StrictSlash(true)
 	a.initializeRoutes()
@@ -46,12 +58,24 @@ func (a *App) Initialize(DbHost, DbPort, DbUser, DbName, DbPassword string) {
 func RegisterReconciliationRoutes(router *mux.Router, h *handler.ReconciliationHandler) {
 	router.HandleFunc("/process_reconciliation", h.ProcessReconciliation).Methods("POST")
 	router.HandleFunc("/get_result", h.GetResult).Methods("GET")
+	router.HandleFunc("/get_matches", h.GetMatches).Methods("GET")
+	router.HandleFunc("/get_rejected_rows", h.GetRejectedRows).Methods("GET")
+	router.HandleFunc("/validate_files", h.ValidateFiles).Methods("POST")
+	router.HandleFunc("/csv_profiles", h.SaveCsvProfile).Methods("POST")
+	router.HandleFunc("/csv_profiles", h.GetCsvProfiles).Methods("GET")
 }
 
 func (a *App) initializeRoutes() {
 	a.Router.Use(middlewares.SetContentTypeMiddleware)
 	reconciliationDao := dao.NewDaoMethod(a.DB)
-	reconciliationUc := reconciliationUsecase.NewReconciliationUsecase(reconciliationDao, nil, 0)
+	// Validation only reads files inside the input directory. Jobs are only held to it when
+	// INPUT_DIR is set, so existing callers naming files elsewhere keep working by default.
+	jobInputDir := os.Getenv("INPUT_DIR")
+	inputDir := jobInputDir
+	if inputDir == "" {
+		inputDir = consts.DefaultInputDir
+	}
+	reconciliationUc := reconciliationUsecase.NewReconciliationUsecase(reconciliationDao, nil, 0, 0, inputDir, jobInputDir)
 	handler := handler.NewReconciliationHandler(reconciliationUc)
 	RegisterReconciliationRoutes(a.Router, handler)
 }
@@ -8,15 +8,132 @@ const (
 	StatusInit     = 1
 	StatusRunning  = 2
 	StatusFinished = 3
+	StatusFailed   = 4
 
 	// DataType constants
 	DataTypeSystemFile    = 1
 	DataTypeBankStatement = 2
+	DataTypeFxRate        = 3
 
 	// Default config
 	DefaultBatchSize     = 1000
 	DefaultWorkerNumber  = 1
 	DefaultIntervalInSec = 2
+	DefaultLockLeaseSec  = 60
+
+	// Directory requests may name input files in, relative to the working directory
+	DefaultInputDir = "data"
+
+	// Where cron servers keep their job claims
+	LockerBackendPostgres    = "postgres"
+	LockerBackendRedis       = "redis"
+	LockerBackendMemory      = "memory" // one cron server only
+	DefaultRedisTimeoutInSec = 5
+
+	// Match rules recorded on every reconciliation match
+	MatchRuleReferenceID      = "reference_id"
+	MatchRuleAmountType       = "amount_type"
+	MatchRuleAmountDateWindow = "amount_date_window"
+	MatchRuleAmountTolerance  = "amount_tolerance"
+	MatchRuleFxTolerance      = "fx_tolerance"
+	MatchRuleAggregate        = "aggregate"
+
+	// Largest amount difference accepted by the amount tolerance rule
+	DefaultAmountTolerance = "0.01"
+
+	// Percentage of the converted amount accepted by the fx tolerance rule
+	DefaultFxTolerancePercent = "0.5"
+
+	// Fractional digits allowed in amounts of currencies with no listed minor unit
+	DefaultCurrencyFractionDigits = 2
+
+	// Bank row fields the reference_id rule can read the reference from
+	ReferenceFieldUniqueIdentifier = "unique_identifier"
+	ReferenceFieldDescription      = "description"
+
+	// Logical CSV fields a column mapping profile can locate
+	CsvFieldTrxID            = "trx_id"
+	CsvFieldAmount           = "amount"
+	CsvFieldType             = "type"
+	CsvFieldTransactionTime  = "transaction_time"
+	CsvFieldCurrency         = "currency"
+	CsvFieldUniqueIdentifier = "unique_identifier"
+	CsvFieldDate             = "date"
+	CsvFieldDescription      = "description"
+	CsvFieldDirection        = "direction"    // D/C mark for an unsigned amount column
+	CsvFieldDebitAmount      = "debit_amount" // with CsvFieldCreditAmount, instead of one amount column
+	CsvFieldCreditAmount     = "credit_amount"
+	CsvFieldValueDate        = "value_date"
+
+	// Direction of a bank row, named like the system transaction types
+	DirectionCredit = "CREDIT"
+	DirectionDebit  = "DEBIT"
+
+	// Which date of a bank row the job windows and matches by
+	BankDateBooking = "booking"
+	BankDateValue   = "value" // falls back to the booking date for rows without one
+
+	// How bank amounts carry their direction
+	SignConventionSigned   = "signed"   // negative amounts are debits
+	SignConventionInverted = "inverted" // negative amounts are credits
+
+	// Input file formats
+	FileFormatCSV   = "csv"
+	FileFormatXLSX  = "xlsx"
+	FileFormatCamt  = "camt"  // ISO 20022 camt.053 / camt.054 XML
+	FileFormatMT940 = "mt940" // SWIFT MT940 statement text
+
+	// Aggregate matching bounds
+	MaxAggregateGroupSize = 10
+	AggregateSearchLimit  = 100000
+	AggregatePassLimit    = 20000000 // steps over a whole job's leftovers
+
+	// Maximum distance in days between a transaction and its bank statement date. Requests
+	// that set none pair rows at any distance, as matching did before the limit existed.
+	NoDateLagLimit        = -1
+	DefaultMaxDateLagDays = NoDateLagLimit
+
+	// Match listing pagination
+	DefaultMatchPageSize = 100
+	MaxMatchPageSize     = 1000
+
+	// Rejected rows read per query when exporting them
+	RejectedRowExportPageSize = 1000
+
+	// Rejected rows listed per file by a dry-run validation
+	DefaultValidationMaxErrors = 20
+	MaxValidationMaxErrors     = 1000
+
+	// Error codes recorded on a failed job
+	JobErrorSystemFileMissing    = "system_file_missing"
+	JobErrorSystemFileUnreadable = "system_file_unreadable"
+	JobErrorInvalidProcessInfo   = "invalid_process_info"
+	JobErrorInvalidFxRates       = "invalid_fx_rates"
+	JobErrorInvalidMatchRules    = "invalid_match_rules"
+	JobErrorInvalidProfile       = "invalid_profile"
+	JobErrorInvalidReferenceRule = "invalid_reference_extraction"
+	JobErrorInvalidTimezone      = "invalid_timezone"
+	JobErrorAmountOverflow       = "amount_overflow"
+	JobErrorStorage              = "storage_error" // retryable
+	JobErrorInternal             = "internal_error"
+	JobErrorWorkerLost           = "worker_lost"
+
+	// Attempts a job gets at a retryable failure before it is failed
+	MaxJobAttempts = 3
+
+	// Job priorities; higher runs first
+	MaxJobPriority = 9
+
+	// How a cron worker runs the job it claims
+	WorkerModeBatch         = "batch" // one batch per poll interval
+	WorkerModeDrain         = "drain" // batches back to back until the job ends or the budget runs out
+	DefaultJobTimeBudgetSec = 300
+
+	// Worker heartbeats and the recovery of jobs whose worker stopped sending them
+	HeartbeatIntervalInSec   = 15
+	DefaultStaleJobAfterSec  = 120
+	DefaultReaperIntervalSec = 30
+	MaxJobRecoveries         = 3
 
 	NoProcessHandled = "no process handled"
 )
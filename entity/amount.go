@@ -0,0 +1,207 @@
+package entity
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// ErrAmountOverflow is returned when the result of an amount calculation does not fit in
+// an Amount.
+var ErrAmountOverflow = errors.New("amount out of range")
+
+// AmountScale is the number of fractional digits an Amount holds exactly.
+const AmountScale = 4
+
+const amountUnit = 10000 // 10^AmountScale
+
+// Amount is an exact fixed-point money value, stored as a count of 1/10^AmountScale units.
+// It marshals to a plain JSON number such as 15000 or -20.5.
+type Amount int64
+
+// ParseAmount parses a plain decimal such as "-1234.56". It rejects values that need more
+// than maxFractionDigits fractional digits; trailing zeros do not count.
+func ParseAmount(s string, maxFractionDigits int) (Amount, error) {
+	value := strings.TrimSpace(s)
+	negative := false
+	if strings.HasPrefix(value, "-") || strings.HasPrefix(value, "+") {
+		negative = value[0] == '-'
+		value = value[1:]
+	}
+
+	intPart, fracPart := value, ""
+	if dot := strings.IndexByte(value, '.'); dot >= 0 {
+		intPart, fracPart = value[:dot], value[dot+1:]
+	}
+	if intPart == "" && fracPart == "" {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	if !isDigits(intPart) || !isDigits(fracPart) {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+
+	fracPart = strings.TrimRight(fracPart, "0")
+	if maxFractionDigits > AmountScale {
+		maxFractionDigits = AmountScale
+	}
+	if len(fracPart) > maxFractionDigits {
+		return 0, fmt.Errorf("amount %q has more than %d fractional digits", s, maxFractionDigits)
+	}
+
+	if intPart == "" {
+		intPart = "0"
+	}
+	units, err := strconv.ParseInt(intPart+fracPart+strings.Repeat("0", AmountScale-len(fracPart)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("amount %q is out of range", s)
+	}
+	if negative {
+		units = -units
+	}
+	return Amount(units), nil
+}
+
+// MustParseAmount is ParseAmount for package-level constants; it panics on bad input.
+func MustParseAmount(s string) Amount {
+	amount, err := ParseAmount(s, AmountScale)
+	if err != nil {
+		panic(err)
+	}
+	return amount
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// String returns the shortest exact decimal form, e.g. "15000", "-20.5".
+func (a Amount) String() string {
+	units := int64(a)
+	sign := ""
+	if units < 0 {
+		sign = "-"
+		units = -units
+	}
+	intPart := units / amountUnit
+	fracPart := units % amountUnit
+	if fracPart == 0 {
+		return fmt.Sprintf("%s%d", sign, intPart)
+	}
+	frac := strings.TrimRight(fmt.Sprintf("%0*d", AmountScale, fracPart), "0")
+	return fmt.Sprintf("%s%d.%s", sign, intPart, frac)
+}
+
+func (a Amount) Abs() Amount {
+	if a < 0 {
+		return -a
+	}
+	return a
+}
+
+// Add returns a + b, or ErrAmountOverflow when the sum does not fit in an Amount.
+func (a Amount) Add(b Amount) (Amount, error) {
+	sum := a + b
+	if (b > 0 && sum < a) || (b < 0 && sum > a) {
+		return 0, ErrAmountOverflow
+	}
+	return sum, nil
+}
+
+// Sub returns a - b, or ErrAmountOverflow when the difference does not fit in an Amount.
+func (a Amount) Sub(b Amount) (Amount, error) {
+	difference := a - b
+	if (b > 0 && difference > a) || (b < 0 && difference < a) {
+		return 0, ErrAmountOverflow
+	}
+	return difference, nil
+}
+
+// MulPercent returns a * percent / 100, rounded half away from zero to the Amount scale.
+func (a Amount) MulPercent(percent Amount) (Amount, error) {
+	product := new(big.Int).Mul(big.NewInt(int64(a)), big.NewInt(int64(percent)))
+	return roundQuotient(product, big.NewInt(100*amountUnit))
+}
+
+// MulRate converts the amount with an exchange rate, rounded half away from zero to the Amount scale.
+func (a Amount) MulRate(rate *big.Rat) (Amount, error) {
+	product := new(big.Rat).Mul(new(big.Rat).SetInt64(int64(a)), rate)
+	return roundQuotient(product.Num(), product.Denom())
+}
+
+// roundQuotient returns numerator / denominator in Amount units, rounded half away from
+// zero. The denominator must be positive.
+func roundQuotient(numerator, denominator *big.Int) (Amount, error) {
+	quotient, remainder := new(big.Int).QuoRem(numerator, denominator, new(big.Int))
+	if new(big.Int).Mul(new(big.Int).Abs(remainder), big.NewInt(2)).Cmp(denominator) >= 0 {
+		if numerator.Sign() < 0 {
+			quotient.Sub(quotient, big.NewInt(1))
+		} else {
+			quotient.Add(quotient, big.NewInt(1))
+		}
+	}
+	if !quotient.IsInt64() {
+		return 0, ErrAmountOverflow
+	}
+	return Amount(quotient.Int64()), nil
+}
+
+func MaxAmount(a, b Amount) Amount {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// UnmarshalJSON accepts a JSON number or a quoted decimal string.
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	text := strings.Trim(string(data), `"`)
+	if text == "null" {
+		return nil
+	}
+	parsed, err := ParseAmount(text, AmountScale)
+	if err != nil {
+		return err
+	}
+	*a = parsed
+	return nil
+}
+
+// Value stores the amount as an exact decimal string, suitable for a numeric column.
+func (a Amount) Value() (driver.Value, error) {
+	return a.String(), nil
+}
+
+func (a *Amount) Scan(src interface{}) error {
+	var text string
+	switch v := src.(type) {
+	case nil:
+		*a = 0
+		return nil
+	case []byte:
+		text = string(v)
+	case string:
+		text = v
+	case int64:
+		text = strconv.FormatInt(v, 10)
+	default:
+		return errors.New("unsupported amount column type")
+	}
+	parsed, err := ParseAmount(text, AmountScale)
+	if err != nil {
+		return err
+	}
+	*a = parsed
+	return nil
+}
@@ -0,0 +1,179 @@
+package entity
+
+import (
+	"errors"
+	"math"
+	"math/big"
+	"testing"
+)
+
+func TestParseAmount(t *testing.T) {
+	tests := []struct {
+		in        string
+		maxDigits int
+		want      Amount
+		wantErr   bool
+	}{
+		{in: "15000", maxDigits: 2, want: 150000000},
+		{in: " -1234.56 ", maxDigits: 2, want: -12345600},
+		{in: "+0.5", maxDigits: 2, want: 5000},
+		{in: ".25", maxDigits: 2, want: 2500},
+		{in: "7.", maxDigits: 2, want: 70000},
+		{in: "10.1200", maxDigits: 2, want: 101200},
+		{in: "0.0001", maxDigits: 4, want: 1},
+		{in: "0.00001", maxDigits: 8, wantErr: true},
+		{in: "10.123", maxDigits: 2, wantErr: true},
+		{in: "100", maxDigits: 0, want: 1000000},
+		{in: "100.5", maxDigits: 0, wantErr: true},
+		{in: "922337203685477.5807", maxDigits: 4, want: math.MaxInt64},
+		{in: "922337203685477.5808", maxDigits: 4, wantErr: true},
+		{in: "", maxDigits: 2, wantErr: true},
+		{in: "-", maxDigits: 2, wantErr: true},
+		{in: ".", maxDigits: 2, wantErr: true},
+		{in: "1,000", maxDigits: 2, wantErr: true},
+		{in: "1e3", maxDigits: 2, wantErr: true},
+		{in: "--1", maxDigits: 2, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseAmount(tt.in, tt.maxDigits)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseAmount(%q, %d) = %d, want an error", tt.in, tt.maxDigits, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseAmount(%q, %d): %v", tt.in, tt.maxDigits, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseAmount(%q, %d) = %d, want %d", tt.in, tt.maxDigits, got, tt.want)
+		}
+	}
+}
+
+func TestAmountString(t *testing.T) {
+	tests := []struct {
+		in   Amount
+		want string
+	}{
+		{in: 0, want: "0"},
+		{in: 150000000, want: "15000"},
+		{in: -205000, want: "-20.5"},
+		{in: 1, want: "0.0001"},
+		{in: -1, want: "-0.0001"},
+		{in: 101200, want: "10.12"},
+		{in: math.MaxInt64, want: "922337203685477.5807"},
+	}
+	for _, tt := range tests {
+		if got := tt.in.String(); got != tt.want {
+			t.Errorf("Amount(%d).String() = %q, want %q", int64(tt.in), got, tt.want)
+		}
+		back, err := ParseAmount(tt.want, AmountScale)
+		if err != nil || back != tt.in {
+			t.Errorf("ParseAmount(%q) = %d, %v; want %d", tt.want, back, err, tt.in)
+		}
+	}
+}
+
+func TestAmountMulPercent(t *testing.T) {
+	tests := []struct {
+		amount, percent string
+		want            string
+	}{
+		{amount: "200", percent: "10", want: "20"},
+		{amount: "1000", percent: "0.5", want: "5"},
+		// 0.0003 * 50% = 0.00015, which rounds half away from zero.
+		{amount: "0.0003", percent: "50", want: "0.0002"},
+		{amount: "-0.0003", percent: "50", want: "-0.0002"},
+		// 0.0001 * 40% = 0.00004, which rounds down.
+		{amount: "0.0001", percent: "40", want: "0"},
+		{amount: "0.0001", percent: "60", want: "0.0001"},
+		{amount: "-0.0001", percent: "60", want: "-0.0001"},
+		{amount: "33.3333", percent: "33.3333", want: "11.1111"},
+		{amount: "922337203685477.5807", percent: "100", want: "922337203685477.5807"},
+	}
+	for _, tt := range tests {
+		got, err := MustParseAmount(tt.amount).MulPercent(MustParseAmount(tt.percent))
+		if err != nil {
+			t.Errorf("%s.MulPercent(%s): %v", tt.amount, tt.percent, err)
+			continue
+		}
+		if got.String() != tt.want {
+			t.Errorf("%s.MulPercent(%s) = %s, want %s", tt.amount, tt.percent, got, tt.want)
+		}
+	}
+
+	if _, err := Amount(math.MaxInt64).MulPercent(MustParseAmount("200")); !errors.Is(err, ErrAmountOverflow) {
+		t.Errorf("MaxInt64.MulPercent(200) error = %v, want ErrAmountOverflow", err)
+	}
+}
+
+func TestAmountMulRate(t *testing.T) {
+	tests := []struct {
+		amount, rate string
+		want         string
+	}{
+		{amount: "100", rate: "15000", want: "1500000"},
+		{amount: "1500000", rate: "1/15000", want: "100"},
+		{amount: "10", rate: "1/3", want: "3.3333"},
+		{amount: "20", rate: "1/3", want: "6.6667"},
+		{amount: "-20", rate: "1/3", want: "-6.6667"},
+		// 0.0001 * 1/2 = 0.00005, which rounds half away from zero.
+		{amount: "0.0001", rate: "1/2", want: "0.0001"},
+		{amount: "-0.0001", rate: "1/2", want: "-0.0001"},
+		{amount: "12.34", rate: "0.9", want: "11.106"},
+	}
+	for _, tt := range tests {
+		rate, ok := new(big.Rat).SetString(tt.rate)
+		if !ok {
+			t.Fatalf("bad rate %q", tt.rate)
+		}
+		got, err := MustParseAmount(tt.amount).MulRate(rate)
+		if err != nil {
+			t.Errorf("%s.MulRate(%s): %v", tt.amount, tt.rate, err)
+			continue
+		}
+		if got.String() != tt.want {
+			t.Errorf("%s.MulRate(%s) = %s, want %s", tt.amount, tt.rate, got, tt.want)
+		}
+	}
+
+	if _, err := MustParseAmount("1000000000000").MulRate(big.NewRat(15000, 1)); !errors.Is(err, ErrAmountOverflow) {
+		t.Errorf("1000000000000.MulRate(15000) error = %v, want ErrAmountOverflow", err)
+	}
+	if _, err := Amount(math.MinInt64 + 1).MulRate(big.NewRat(2, 1)); !errors.Is(err, ErrAmountOverflow) {
+		t.Errorf("MinInt64.MulRate(2) error = %v, want ErrAmountOverflow", err)
+	}
+}
+
+func TestAmountAddSub(t *testing.T) {
+	max := Amount(math.MaxInt64)
+	min := Amount(math.MinInt64)
+
+	if got, err := Amount(5).Add(-7); err != nil || got != -2 {
+		t.Errorf("5.Add(-7) = %d, %v; want -2", got, err)
+	}
+	if got, err := max.Add(-1); err != nil || got != max-1 {
+		t.Errorf("MaxInt64.Add(-1) = %d, %v; want MaxInt64-1", got, err)
+	}
+	if _, err := max.Add(1); !errors.Is(err, ErrAmountOverflow) {
+		t.Errorf("MaxInt64.Add(1) error = %v, want ErrAmountOverflow", err)
+	}
+	if _, err := min.Add(-1); !errors.Is(err, ErrAmountOverflow) {
+		t.Errorf("MinInt64.Add(-1) error = %v, want ErrAmountOverflow", err)
+	}
+
+	if got, err := Amount(5).Sub(7); err != nil || got != -2 {
+		t.Errorf("5.Sub(7) = %d, %v; want -2", got, err)
+	}
+	if _, err := min.Sub(1); !errors.Is(err, ErrAmountOverflow) {
+		t.Errorf("MinInt64.Sub(1) error = %v, want ErrAmountOverflow", err)
+	}
+	if _, err := Amount(0).Sub(min); !errors.Is(err, ErrAmountOverflow) {
+		t.Errorf("0.Sub(MinInt64) error = %v, want ErrAmountOverflow", err)
+	}
+	if got, err := Amount(-1).Sub(min); err != nil || got != max {
+		t.Errorf("-1.Sub(MinInt64) = %d, %v; want MaxInt64", got, err)
+	}
+}
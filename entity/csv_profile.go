@@ -0,0 +1,38 @@
+package entity
+
+// CsvProfile describes the layout of a system or bank CSV file so a new bank format is a
+// configuration change. Columns maps a logical field (see the CsvField constants) to a
+// header name or a 0-based column index. Empty settings fall back to the built-in layout.
+type CsvProfile struct {
+	Name               string               `json:"name"`
+	DataType           int64                `json:"data_type"` // consts.DataTypeSystemFile or consts.DataTypeBankStatement
+	Columns            map[string]CsvColumn `json:"columns"`
+	SkipRows           int                  `json:"skip_rows,omitempty"` // lines before the header row
+	Delimiter          string               `json:"delimiter,omitempty"`
+	QuoteChar          string               `json:"quote_char,omitempty"`
+	DateLayout         string               `json:"date_layout,omitempty"` // Go time layout
+	DecimalSeparator   string               `json:"decimal_separator,omitempty"`
+	ThousandsSeparator string               `json:"thousands_separator,omitempty"`
+	SignConvention     string               `json:"sign_convention,omitempty"` // bank files: "signed" or "inverted"
+	Sheet              string               `json:"sheet,omitempty"`           // xlsx files: worksheet name, the first one when empty
+}
+
+// CsvColumn locates a column by header name or, when Index is set, by position.
+type CsvColumn struct {
+	Header string `json:"header,omitempty"`
+	Index  *int   `json:"index,omitempty"`
+}
+
+// ReconciliationFile is an input file of a reconciliation request with the name of the
+// CSV profile describing it; an empty profile means the built-in layout. Format is "csv",
+// "xlsx", "camt" or "mt940" and defaults to what the file extension implies.
+type ReconciliationFile struct {
+	Path    string `json:"path"`
+	Profile string `json:"profile,omitempty"`
+	Format  string `json:"format,omitempty"`
+}
+
+type SaveCsvProfileRequest struct {
+	Operator string     `json:"operator"`
+	Profile  CsvProfile `json:"profile"`
+}
@@ -0,0 +1,27 @@
+package entity
+
+import (
+	"strings"
+
+	"github.com/radhian/reconciliation-system/consts"
+)
+
+// currencyFractionDigits lists the ISO 4217 currencies whose minor unit is not 2 digits.
+var currencyFractionDigits = map[string]int{
+	"BHD": 3, "IQD": 3, "JOD": 3, "KWD": 3, "LYD": 3, "OMR": 3, "TND": 3,
+	"BIF": 0, "CLP": 0, "DJF": 0, "GNF": 0, "ISK": 0, "JPY": 0, "KMF": 0, "KRW": 0,
+	"PYG": 0, "RWF": 0, "UGX": 0, "VND": 0, "VUV": 0, "XAF": 0, "XOF": 0, "XPF": 0,
+}
+
+// NormalizeCurrency trims and upper-cases a currency code.
+func NormalizeCurrency(currency string) string {
+	return strings.ToUpper(strings.TrimSpace(currency))
+}
+
+// CurrencyFractionDigits returns how many fractional digits amounts in the currency may carry.
+func CurrencyFractionDigits(currency string) int {
+	if digits, ok := currencyFractionDigits[NormalizeCurrency(currency)]; ok {
+		return digits
+	}
+	return consts.DefaultCurrencyFractionDigits
+}
@@ -0,0 +1,49 @@
+package entity
+
+// ValidateFilesRequest names the files of a reconciliation to check without creating a job.
+// The fields mean the same as in ProcessReconciliationRequest.
+type ValidateFilesRequest struct {
+	TransactionCSVPath    string               `json:"transaction_csv_path"`
+	TransactionCSVProfile string               `json:"transaction_csv_profile,omitempty"`
+	ReferenceCSVPaths     []string             `json:"reference_csv_paths"`
+	ReferenceFiles        []ReconciliationFile `json:"reference_files,omitempty"`
+	StartDate             string               `json:"start_date"`
+	EndDate               string               `json:"end_date"`
+	DefaultCurrency       string               `json:"default_currency,omitempty"`
+	Timezone              string               `json:"timezone,omitempty"`
+	BankDate              string               `json:"bank_date,omitempty"`
+	// How many rejected rows to list per file
+	MaxErrors *int `json:"max_errors,omitempty"`
+}
+
+// FileValidation reports what the parsers would make of one file. FileError is set when the
+// file cannot be read at all; the counts then cover the rows read before it failed.
+type FileValidation struct {
+	Path         string     `json:"path"`
+	DataType     int64      `json:"data_type"`
+	Profile      string     `json:"profile,omitempty"`
+	Headers      []string   `json:"headers"`
+	TotalRows    int64      `json:"total_rows"`
+	ValidRows    int64      `json:"valid_rows"`
+	InWindowRows int64      `json:"in_window_rows"`
+	EarliestDate string     `json:"earliest_date,omitempty"` // YYYY-MM-DD over the valid rows
+	LatestDate   string     `json:"latest_date,omitempty"`
+	ErrorCount   int64      `json:"error_count"`
+	Errors       []RowError `json:"errors"`
+	FileError    string     `json:"file_error,omitempty"`
+
+	StatementBalances []StatementBalance `json:"statement_balances,omitempty"`
+}
+
+// RowError is one rejected row of a file.
+type RowError struct {
+	Line       int64  `json:"line"`
+	Reason     string `json:"reason"`
+	RawContent string `json:"raw_content"`
+}
+
+// FileValidationResult is valid when every file could be read and no row was rejected.
+type FileValidationResult struct {
+	Valid bool             `json:"valid"`
+	Files []FileValidation `json:"files"`
+}
@@ -4,15 +4,22 @@ import "time"
 
 type Transaction struct {
 	TrxID           string
-	Amount          float64
+	Amount          Amount
 	Type            string // DEBIT or CREDIT
 	TransactionTime time.Time
+	Currency        string `json:"Currency,omitempty"`
 }
 
 type BankStatement struct {
 	UniqueIdentifier string
-	Amount           float64
+	Amount           Amount
 	Date             time.Time
+	Currency         string     `json:"Currency,omitempty"`
+	Description      string     `json:"Description,omitempty"`
+	SourceFile       string     `json:"-"`
+	RowNumber        int64      `json:"RowNumber,omitempty"` // line number within SourceFile, record number for camt files
+	ValueDate        *time.Time `json:"ValueDate,omitempty"`
+	Direction        string     `json:"Direction,omitempty"` // consts.Direction*; empty means the sign of Amount decides
 }
 
 type ProcessReconciliationRequest struct {
@@ -21,9 +28,121 @@ type ProcessReconciliationRequest struct {
 	StartDate          string   `json:"start_date"`
 	EndDate            string   `json:"end_date"`
 	Operator           string   `json:"operator"`
+	MaxDateLagDays     *int64   `json:"max_date_lag_days,omitempty"`
+	MatchRules         []string `json:"match_rules,omitempty"`
+	// Optional tolerance applied by the amount_tolerance rule
+	AmountTolerance        *Amount `json:"amount_tolerance,omitempty"`
+	AmountTolerancePercent *Amount `json:"amount_tolerance_percent,omitempty"`
+	// Enables many-to-one / one-to-many matching for groups of up to this many items
+	MaxGroupSize *int `json:"max_group_size,omitempty"`
+	// Where the reference_id rule finds our transaction ID on a bank row
+	ReferenceExtraction *ReferenceExtraction `json:"reference_extraction,omitempty"`
+	// Currency for rows of files without a currency column
+	DefaultCurrency string `json:"default_currency,omitempty"`
+	// IANA time zone of the business day the dates are in, UTC when empty
+	Timezone string `json:"timezone,omitempty"`
+	// "booking" (default) or "value": the bank date rows are windowed and matched by
+	BankDate string `json:"bank_date,omitempty"`
+	// Optional from_currency,to_currency,rate table used by the fx_tolerance rule
+	FxRateCSVPath string `json:"fx_rate_csv_path,omitempty"`
+	// Jobs with a higher priority are picked first, 0 (default) to consts.MaxJobPriority
+	Priority *int `json:"priority,omitempty"`
+
+	// Optional CSV profile names; reference_files pairs each bank file with its profile
+	TransactionCSVProfile string               `json:"transaction_csv_profile,omitempty"`
+	ReferenceFiles        []ReconciliationFile `json:"reference_files,omitempty"`
+}
+
+// ReferenceExtraction locates the system transaction ID on a bank row. Field is
+// "unique_identifier" (default) or "description"; the optional Pattern is a regular
+// expression whose first capture group (or whole match) is the reference.
+type ReferenceExtraction struct {
+	Field   string `json:"field"`
+	Pattern string `json:"pattern,omitempty"`
 }
 
 type ProcessMetadata struct {
-	StartTime int64 `json:"start_time"`
-	EndTime   int64 `json:"end_time"`
+	StartTime      int64    `json:"start_time"`
+	EndTime        int64    `json:"end_time"`
+	MaxDateLagDays int64    `json:"max_date_lag_days"`
+	MatchRules     []string `json:"match_rules"`
+
+	AmountTolerance        Amount `json:"amount_tolerance,omitempty"`
+	AmountTolerancePercent Amount `json:"amount_tolerance_percent,omitempty"`
+	MaxGroupSize           int    `json:"max_group_size,omitempty"`
+
+	ReferenceExtraction *ReferenceExtraction `json:"reference_extraction,omitempty"`
+	DefaultCurrency     string               `json:"default_currency,omitempty"`
+	Timezone            string               `json:"timezone,omitempty"`
+	BankDate            string               `json:"bank_date,omitempty"`
+	Priority            int                  `json:"priority,omitempty"`
+
+	// Filled in when the job stages bank files that state their balances (MT940, camt).
+	StatementBalances []StatementBalance `json:"statement_balances,omitempty"`
+}
+
+// StatementBalance is the opening and closing balance of one statement in a bank file.
+// Dates are YYYY-MM-DD and debit balances are negative.
+type StatementBalance struct {
+	SourceFile      string  `json:"source_file"`
+	Reference       string  `json:"reference,omitempty"`
+	Account         string  `json:"account,omitempty"`
+	StatementNumber string  `json:"statement_number,omitempty"`
+	Currency        string  `json:"currency,omitempty"`
+	OpeningBalance  *Amount `json:"opening_balance,omitempty"`
+	OpeningDate     string  `json:"opening_date,omitempty"`
+	ClosingBalance  *Amount `json:"closing_balance,omitempty"`
+	ClosingDate     string  `json:"closing_date,omitempty"`
+}
+
+// ReconciliationResult is the job-wide summary stored in ReconciliationProcessLog.Result.
+type ReconciliationResult struct {
+	TotalProcessed     int64                      `json:"total_processed"`
+	Matched            int64                      `json:"matched"`
+	Unmatched          int64                      `json:"unmatched"`
+	MatchedByRule      map[string]int64           `json:"matched_by_rule"`
+	SystemUnmatched    []Transaction              `json:"system_unmatched"`
+	BankUnmatchedBySrc map[string][]BankStatement `json:"bank_unmatched_by_source"`
+	TotalDiscrepancy   Amount                     `json:"total_discrepancy"`
+	TotalVariance      Amount                     `json:"total_variance"` // fee/rounding differences on matched pairs
+	GroupedMatches     []GroupedMatch             `json:"grouped_matches,omitempty"`
+
+	ReferenceMismatches          []ReferenceMismatch `json:"reference_amount_mismatches,omitempty"`
+	ReferenceMismatchDiscrepancy Amount              `json:"reference_mismatch_discrepancy"`
+
+	// Rows without a currency are only counted in the job-wide totals above.
+	TotalsByCurrency map[string]CurrencyTotal `json:"totals_by_currency,omitempty"`
+
+	// Input rows the parsers could not use; they are listed by the rejected rows export.
+	RejectedRows         int64            `json:"rejected_rows"`
+	RejectedRowsBySource map[string]int64 `json:"rejected_rows_by_source,omitempty"`
+}
+
+// CurrencyTotal breaks the summary down by currency. Matched and Unmatched count system
+// transactions; Discrepancy covers unmatched rows of both sides and Variance is keyed by
+// the bank currency of the matched pair.
+type CurrencyTotal struct {
+	Matched     int64  `json:"matched"`
+	Unmatched   int64  `json:"unmatched"`
+	Discrepancy Amount `json:"discrepancy"`
+	Variance    Amount `json:"variance"`
+}
+
+// ReferenceMismatch is a pair matched by reference ID whose amounts do not agree.
+type ReferenceMismatch struct {
+	TrxID            string `json:"trx_id"`
+	BankIdentifier   string `json:"bank_identifier"`
+	SystemAmount     Amount `json:"system_amount"`
+	BankAmount       Amount `json:"bank_amount"`
+	AmountDifference Amount `json:"amount_difference"`
+}
+
+// GroupedMatch describes a lump-sum settlement: several system transactions cleared by
+// one bank line, or one system transaction paid out as several bank lines.
+type GroupedMatch struct {
+	GroupNumber     int64    `json:"group_number"`
+	Rule            string   `json:"rule"`
+	SystemTrxIDs    []string `json:"system_trx_ids"`
+	BankIdentifiers []string `json:"bank_identifiers"`
+	Amount          Amount   `json:"amount"`
 }
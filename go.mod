@@ -1,6 +1,6 @@
 module github.com/radhian/reconciliation-system
 
-go 1.14
+go 1.15
 
 require (
 	github.com/gorilla/mux v1.7.4
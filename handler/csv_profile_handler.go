@@ -0,0 +1,80 @@
+package handler
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/radhian/reconciliation-system/entity"
+	usecase "github.com/radhian/reconciliation-system/usecase/reconciliation"
+)
+
+func (h *ReconciliationHandler) SaveCsvProfile(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req entity.SaveCsvProfileRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(APIResponse{
+			Status:  "error",
+			Message: "Invalid request body",
+		})
+		return
+	}
+
+	if strings.TrimSpace(req.Operator) == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(APIResponse{
+			Status:  "error",
+			Message: "operator must be specified",
+		})
+		return
+	}
+	if err := usecase.ValidateCsvProfile(req.Profile); err != nil {
+		log.Println("Invalid csv profile:", err)
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(APIResponse{
+			Status:  "error",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	res, err := h.Usecase.SaveCsvProfile(req.Profile, req.Operator)
+	if err != nil {
+		log.Printf("failed to save csv profile: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(APIResponse{
+			Status:  "error",
+			Message: "Failed to save csv profile",
+		})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(APIResponse{
+		Status: "success",
+		Data:   res,
+	})
+}
+
+func (h *ReconciliationHandler) GetCsvProfiles(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	profiles, err := h.Usecase.GetCsvProfiles()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(APIResponse{
+			Status:  "error",
+			Message: "Failed to get csv profiles",
+		})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(APIResponse{
+		Status: "success",
+		Data:   profiles,
+	})
+}
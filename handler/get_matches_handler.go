@@ -0,0 +1,84 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/radhian/reconciliation-system/consts"
+)
+
+func (h *ReconciliationHandler) GetMatches(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	query := r.URL.Query()
+	logIDStr := query.Get("log_id")
+	if logIDStr == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(APIResponse{
+			Status:  "error",
+			Message: "log_id is required",
+		})
+		return
+	}
+
+	logID, err := strconv.ParseInt(logIDStr, 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(APIResponse{
+			Status:  "error",
+			Message: "log_id must be a valid integer",
+		})
+		return
+	}
+
+	page, err := parsePositiveIntParam(query.Get("page"), 1)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(APIResponse{
+			Status:  "error",
+			Message: "page must be a positive integer",
+		})
+		return
+	}
+
+	pageSize, err := parsePositiveIntParam(query.Get("page_size"), consts.DefaultMatchPageSize)
+	if err != nil || pageSize > consts.MaxMatchPageSize {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(APIResponse{
+			Status:  "error",
+			Message: "page_size must be a positive integer not greater than " + strconv.Itoa(consts.MaxMatchPageSize),
+		})
+		return
+	}
+
+	result, err := h.Usecase.GetReconciliationMatches(logID, query.Get("trx_id"), page, pageSize)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(APIResponse{
+			Status:  "error",
+			Message: "Failed to get matches",
+		})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(APIResponse{
+		Status: "success",
+		Data:   result,
+	})
+}
+
+func parsePositiveIntParam(value string, defaultValue int) (int, error) {
+	if value == "" {
+		return defaultValue, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, err
+	}
+	if n < 1 {
+		return 0, strconv.ErrRange
+	}
+	return n, nil
+}
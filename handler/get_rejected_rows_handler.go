@@ -0,0 +1,59 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// GetRejectedRows downloads the rows a job could not parse as a CSV file.
+func (h *ReconciliationHandler) GetRejectedRows(w http.ResponseWriter, r *http.Request) {
+	logIDStr := r.URL.Query().Get("log_id")
+	if logIDStr == "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(APIResponse{
+			Status:  "error",
+			Message: "log_id is required",
+		})
+		return
+	}
+
+	logID, err := strconv.ParseInt(logIDStr, 10, 64)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(APIResponse{
+			Status:  "error",
+			Message: "log_id must be a valid integer",
+		})
+		return
+	}
+
+	// Headers stay unsent until the first page is written, so a failed first read can
+	// still be answered with a JSON error. A later failure can only cut the file short.
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"rejected_rows_%d.csv\"", logID))
+	out := &trackingWriter{ResponseWriter: w}
+	if err := h.Usecase.ExportRejectedRows(logID, out); err != nil && !out.written {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Del("Content-Disposition")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(APIResponse{
+			Status:  "error",
+			Message: "Failed to export rejected rows",
+		})
+	}
+}
+
+// trackingWriter remembers whether any of the response body has been written.
+type trackingWriter struct {
+	http.ResponseWriter
+	written bool
+}
+
+func (t *trackingWriter) Write(p []byte) (int, error) {
+	t.written = true
+	return t.ResponseWriter.Write(p)
+}
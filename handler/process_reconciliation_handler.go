@@ -6,11 +6,12 @@ import (
 	"fmt"
 	"log"
 	"net/http"
-	"os"
 	"strings"
 	"time"
 
+	"github.com/radhian/reconciliation-system/consts"
 	"github.com/radhian/reconciliation-system/entity"
+	usecase "github.com/radhian/reconciliation-system/usecase/reconciliation"
 )
 
 func (h *ReconciliationHandler) ProcessReconciliation(w http.ResponseWriter, r *http.Request) {
@@ -26,7 +27,7 @@ func (h *ReconciliationHandler) ProcessReconciliation(w http.ResponseWriter, r *
 		return
 	}
 
-	startTime, endTime, err := parseAndConvertDates(req.StartDate, req.EndDate)
+	startTime, endTime, err := parseAndConvertDates(req.StartDate, req.EndDate, req.Timezone)
 	if err != nil {
 		log.Println("Invalid date input:", err)
 		w.WriteHeader(http.StatusBadRequest)
@@ -47,13 +48,65 @@ func (h *ReconciliationHandler) ProcessReconciliation(w http.ResponseWriter, r *
 		return
 	}
 
+	processInfo := entity.ProcessMetadata{
+		StartTime:      startTime,
+		EndTime:        endTime,
+		MaxDateLagDays: consts.DefaultMaxDateLagDays,
+		MatchRules:     req.MatchRules,
+		Timezone:       req.Timezone,
+		BankDate:       req.BankDate,
+	}
+	if len(processInfo.MatchRules) == 0 {
+		processInfo.MatchRules = []string{consts.MatchRuleAmountDateWindow}
+	}
+	if req.AmountTolerance != nil || req.AmountTolerancePercent != nil {
+		if req.AmountTolerance != nil {
+			processInfo.AmountTolerance = *req.AmountTolerance
+		}
+		if req.AmountTolerancePercent != nil {
+			processInfo.AmountTolerancePercent = *req.AmountTolerancePercent
+		}
+		if !containsString(processInfo.MatchRules, consts.MatchRuleAmountTolerance) {
+			processInfo.MatchRules = append(processInfo.MatchRules, consts.MatchRuleAmountTolerance)
+		}
+	}
+	if req.MaxGroupSize != nil {
+		processInfo.MaxGroupSize = *req.MaxGroupSize
+	}
+	if req.ReferenceExtraction != nil {
+		processInfo.ReferenceExtraction = req.ReferenceExtraction
+		if !containsString(processInfo.MatchRules, consts.MatchRuleReferenceID) {
+			processInfo.MatchRules = append([]string{consts.MatchRuleReferenceID}, processInfo.MatchRules...)
+		}
+	}
+	if req.MaxDateLagDays != nil {
+		processInfo.MaxDateLagDays = *req.MaxDateLagDays
+	}
+	if req.Priority != nil {
+		processInfo.Priority = *req.Priority
+	}
+	processInfo.DefaultCurrency = entity.NormalizeCurrency(req.DefaultCurrency)
+	if req.FxRateCSVPath != "" && !containsString(processInfo.MatchRules, consts.MatchRuleFxTolerance) {
+		processInfo.MatchRules = append(processInfo.MatchRules, consts.MatchRuleFxTolerance)
+	}
+
 	res, err := h.Usecase.ProcessReconciliationInit(
-		req.TransactionCSVPath,
-		req.ReferenceCSVPaths,
-		startTime,
-		endTime,
+		entity.ReconciliationFile{Path: req.TransactionCSVPath, Profile: req.TransactionCSVProfile},
+		referenceFiles(req.ReferenceCSVPaths, req.ReferenceFiles),
+		req.FxRateCSVPath,
+		processInfo,
 		req.Operator,
 	)
+	// Input files are checked by the usecase, against the input directory when one is set,
+	// so a request learns nothing about paths outside it.
+	if errors.Is(err, usecase.ErrCsvProfileNotFound) || errors.Is(err, usecase.ErrInputFileRejected) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(APIResponse{
+			Status:  "error",
+			Message: err.Error(),
+		})
+		return
+	}
 	if err != nil {
 		log.Printf("failed to load CSV: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -71,20 +124,27 @@ func (h *ReconciliationHandler) ProcessReconciliation(w http.ResponseWriter, r *
 	})
 }
 
-func parseAndConvertDates(startDateStr, endDateStr string) (int64, int64, error) {
+// parseAndConvertDates turns the request's start and end dates into the first and last
+// second of those days in timezone, UTC when it is empty.
+func parseAndConvertDates(startDateStr, endDateStr, timezone string) (int64, int64, error) {
 	const layout = "2006-01-02"
 
+	location, err := loadTimezone(timezone)
+	if err != nil {
+		return 0, 0, err
+	}
+
 	startDate, err := time.Parse(layout, startDateStr)
 	if err != nil {
 		return 0, 0, fmt.Errorf("invalid start date format: %v", err)
 	}
-	startTime := time.Date(startDate.Year(), startDate.Month(), startDate.Day(), 0, 0, 0, 0, time.UTC).Unix()
+	startTime := time.Date(startDate.Year(), startDate.Month(), startDate.Day(), 0, 0, 0, 0, location).Unix()
 
 	endDate, err := time.Parse(layout, endDateStr)
 	if err != nil {
 		return 0, 0, fmt.Errorf("invalid end date format: %v", err)
 	}
-	endTime := time.Date(endDate.Year(), endDate.Month(), endDate.Day(), 23, 59, 59, 999999999, time.UTC).Unix()
+	endTime := time.Date(endDate.Year(), endDate.Month(), endDate.Day(), 23, 59, 59, 999999999, location).Unix()
 
 	if endTime < startTime {
 		return 0, 0, errors.New("end date must not be before start date")
@@ -93,22 +153,47 @@ func parseAndConvertDates(startDateStr, endDateStr string) (int64, int64, error)
 	return startTime, endTime, nil
 }
 
+// loadTimezone resolves an IANA zone name. "Local" is refused since it would depend on the
+// machine the job runs on.
+func loadTimezone(name string) (*time.Location, error) {
+	if name == "" {
+		return time.UTC, nil
+	}
+	if strings.EqualFold(name, "Local") {
+		return nil, errors.New("timezone must be an IANA zone name such as Asia/Jakarta")
+	}
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timezone %q", name)
+	}
+	return location, nil
+}
+
+func validateBankDate(bankDate string) error {
+	switch bankDate {
+	case "", consts.BankDateBooking, consts.BankDateValue:
+		return nil
+	}
+	return fmt.Errorf("bank date must be %q or %q", consts.BankDateBooking, consts.BankDateValue)
+}
+
 func validateProcessReconciliationRequest(req entity.ProcessReconciliationRequest) error {
 	if req.TransactionCSVPath == "" {
 		return errors.New("transaction CSV path is required")
 	}
-	if _, err := os.Stat(req.TransactionCSVPath); os.IsNotExist(err) {
-		return errors.New("transaction CSV file does not exist")
+	if !usecase.IsCsvFile(req.TransactionCSVPath) {
+		return errors.New("transaction file must be a CSV file")
 	}
-	if len(req.ReferenceCSVPaths) == 0 {
+	refFiles := referenceFiles(req.ReferenceCSVPaths, req.ReferenceFiles)
+	if len(refFiles) == 0 {
 		return errors.New("at least one reference bank CSV path is required")
 	}
-	for _, path := range req.ReferenceCSVPaths {
-		if path == "" {
+	for _, ref := range refFiles {
+		if ref.Path == "" {
 			return errors.New("empty path found in reference CSV paths")
 		}
-		if _, err := os.Stat(path); os.IsNotExist(err) {
-			return fmt.Errorf("reference CSV file does not exist: %s", path)
+		if err := usecase.ValidateReferenceFile(ref); err != nil {
+			return err
 		}
 	}
 	if strings.TrimSpace(req.StartDate) == "" || strings.TrimSpace(req.EndDate) == "" {
@@ -117,5 +202,51 @@ func validateProcessReconciliationRequest(req entity.ProcessReconciliationReques
 	if strings.TrimSpace(req.Operator) == "" {
 		return errors.New("operator must be specified")
 	}
+	if req.MaxDateLagDays != nil && *req.MaxDateLagDays < 0 {
+		return errors.New("max date lag days must not be negative")
+	}
+	if err := usecase.ValidateMatchRules(req.MatchRules, req.ReferenceExtraction); err != nil {
+		return err
+	}
+	if req.AmountTolerance != nil && *req.AmountTolerance < 0 {
+		return errors.New("amount tolerance must not be negative")
+	}
+	if req.AmountTolerancePercent != nil && (*req.AmountTolerancePercent < 0 || *req.AmountTolerancePercent > entity.MustParseAmount("100")) {
+		return errors.New("amount tolerance percent must be between 0 and 100")
+	}
+	if req.MaxGroupSize != nil && (*req.MaxGroupSize < 2 || *req.MaxGroupSize > consts.MaxAggregateGroupSize) {
+		return fmt.Errorf("max group size must be between 2 and %d", consts.MaxAggregateGroupSize)
+	}
+	if req.DefaultCurrency != "" && len(entity.NormalizeCurrency(req.DefaultCurrency)) != 3 {
+		return errors.New("default currency must be a 3-letter currency code")
+	}
+	if err := validateBankDate(req.BankDate); err != nil {
+		return err
+	}
+	if req.Priority != nil && (*req.Priority < 0 || *req.Priority > consts.MaxJobPriority) {
+		return fmt.Errorf("priority must be between 0 and %d", consts.MaxJobPriority)
+	}
+	if req.FxRateCSVPath == "" && containsString(req.MatchRules, consts.MatchRuleFxTolerance) {
+		return errors.New("the fx_tolerance rule needs an fx rate CSV path")
+	}
 	return nil
 }
+
+// referenceFiles lists the plain reference_csv_paths, which use the built-in layout,
+// followed by the reference_files with their profiles.
+func referenceFiles(paths []string, profiled []entity.ReconciliationFile) []entity.ReconciliationFile {
+	files := make([]entity.ReconciliationFile, 0, len(paths)+len(profiled))
+	for _, path := range paths {
+		files = append(files, entity.ReconciliationFile{Path: path})
+	}
+	return append(files, profiled...)
+}
+
+func containsString(list []string, target string) bool {
+	for _, s := range list {
+		if s == target {
+			return true
+		}
+	}
+	return false
+}
@@ -3,12 +3,15 @@ package handler
 import (
 	"context"
 	"errors"
+	"time"
 
 	"github.com/radhian/reconciliation-system/consts"
 )
 
-func (h *ReconciliationHandler) ReconciliationExecution(ctx context.Context) error {
-	acquired, logID, err := h.Usecase.TryAcquireLock(ctx)
+// ReconciliationExecution claims the next job and runs its batches for up to budget, or a
+// single batch when budget is zero, before letting the job go again.
+func (h *ReconciliationHandler) ReconciliationExecution(ctx context.Context, workerID string, budget time.Duration) error {
+	acquired, logID, err := h.Usecase.TryAcquireLock(ctx, workerID)
 	if err != nil {
 		return err
 	}
@@ -19,10 +22,16 @@ func (h *ReconciliationHandler) ReconciliationExecution(ctx context.Context) err
 
 	defer h.Usecase.UnlockProcess(ctx, logID)
 
-	err = h.Usecase.ProcessReconciliationJob(ctx, logID)
+	err = h.Usecase.RunReconciliationJob(ctx, logID, budget)
 	if err != nil {
 		return err
 	}
 
 	return nil
 }
+
+// RecoverStaleJobs hands the jobs of workers that stopped sending heartbeats back to the
+// queue and returns how many there were.
+func (h *ReconciliationHandler) RecoverStaleJobs(ctx context.Context, staleAfter time.Duration) (int, error) {
+	return h.Usecase.RecoverStaleJobs(ctx, staleAfter)
+}
@@ -0,0 +1,110 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/radhian/reconciliation-system/consts"
+	"github.com/radhian/reconciliation-system/entity"
+	usecase "github.com/radhian/reconciliation-system/usecase/reconciliation"
+)
+
+// ValidateFiles dry-runs the parsers over the files of a reconciliation request. Problems
+// with the files themselves are reported per file rather than failing the request.
+func (h *ReconciliationHandler) ValidateFiles(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req entity.ValidateFilesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(APIResponse{
+			Status:  "error",
+			Message: "Invalid request body",
+		})
+		return
+	}
+
+	startTime, endTime, err := parseAndConvertDates(req.StartDate, req.EndDate, req.Timezone)
+	if err != nil {
+		log.Println("Invalid date input:", err)
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(APIResponse{
+			Status:  "error",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	if err := validateValidateFilesRequest(req); err != nil {
+		log.Println("Invalid input:", err)
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(APIResponse{
+			Status:  "error",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	maxErrors := consts.DefaultValidationMaxErrors
+	if req.MaxErrors != nil {
+		maxErrors = *req.MaxErrors
+	}
+	processInfo := entity.ProcessMetadata{
+		StartTime:       startTime,
+		EndTime:         endTime,
+		DefaultCurrency: entity.NormalizeCurrency(req.DefaultCurrency),
+		Timezone:        req.Timezone,
+		BankDate:        req.BankDate,
+	}
+
+	result := h.Usecase.ValidateFiles(
+		entity.ReconciliationFile{Path: req.TransactionCSVPath, Profile: req.TransactionCSVProfile},
+		referenceFiles(req.ReferenceCSVPaths, req.ReferenceFiles),
+		processInfo,
+		maxErrors,
+	)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(APIResponse{
+		Status: "success",
+		Data:   result,
+	})
+}
+
+func validateValidateFilesRequest(req entity.ValidateFilesRequest) error {
+	if req.TransactionCSVPath == "" {
+		return errors.New("transaction CSV path is required")
+	}
+	if !usecase.IsCsvFile(req.TransactionCSVPath) {
+		return errors.New("transaction file must be a CSV file")
+	}
+	refFiles := referenceFiles(req.ReferenceCSVPaths, req.ReferenceFiles)
+	if len(refFiles) == 0 {
+		return errors.New("at least one reference bank CSV path is required")
+	}
+	for _, ref := range refFiles {
+		if ref.Path == "" {
+			return errors.New("empty path found in reference CSV paths")
+		}
+		if err := usecase.ValidateReferenceFile(ref); err != nil {
+			return err
+		}
+	}
+	if strings.TrimSpace(req.StartDate) == "" || strings.TrimSpace(req.EndDate) == "" {
+		return errors.New("start and end dates must be provided")
+	}
+	if req.DefaultCurrency != "" && len(entity.NormalizeCurrency(req.DefaultCurrency)) != 3 {
+		return errors.New("default currency must be a 3-letter currency code")
+	}
+	if err := validateBankDate(req.BankDate); err != nil {
+		return err
+	}
+	if req.MaxErrors != nil && (*req.MaxErrors < 0 || *req.MaxErrors > consts.MaxValidationMaxErrors) {
+		return fmt.Errorf("max errors must be between 0 and %d", consts.MaxValidationMaxErrors)
+	}
+	return nil
+}
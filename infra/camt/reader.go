@@ -0,0 +1,414 @@
+// Package camt reads the entries of ISO 20022 camt.053 (statement) and camt.054
+// (debit/credit notification) XML files. Entries are decoded one at a time, so the size of
+// the file does not matter; values are returned as text for the caller to parse. The
+// statement headers and balances are collected as the file is read.
+package camt
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Record is one booked amount. An entry whose details list several transactions, as
+// in a batch booking, yields one record per transaction.
+type Record struct {
+	Entry              int // 1-based position of the entry in the file
+	EntryRef           string
+	AccountServicerRef string
+	EndToEndID         string // empty when the bank sent NOTPROVIDED
+	Amount             string
+	Currency           string
+	CreditDebit        string // CRDT or DBIT
+	Status             string // BOOK, PDNG or INFO; empty when not given
+	BookingDate        string // ISODate or ISODateTime
+	ValueDate          string
+	RemittanceInfo     string
+	Raw                string // the entry's XML as it appears in the file
+}
+
+// Booked reports whether the entry is booked. Entries without a status are taken to be
+// booked; pending and information-only entries are not.
+func (r Record) Booked() bool {
+	return r.Status == "" || r.Status == "BOOK"
+}
+
+// SignedAmount returns the amount with a leading minus sign for a debit.
+func (r Record) SignedAmount() (string, error) {
+	return signedAmount(r.Amount, r.CreditDebit)
+}
+
+// Balance is one balance of a statement.
+type Balance struct {
+	Type        string // e.g. OPBD, PRCD, CLBD, CLAV
+	Amount      string
+	Currency    string
+	CreditDebit string // CRDT or DBIT
+	Date        string // ISODate or ISODateTime
+	Raw         string // the balance's XML as it appears in the file
+}
+
+// SignedAmount returns the amount with a leading minus sign for a debit balance.
+func (b Balance) SignedAmount() (string, error) {
+	return signedAmount(b.Amount, b.CreditDebit)
+}
+
+// Statement is the header and balances of one statement (Stmt) or notification (Ntfctn)
+// within a file.
+type Statement struct {
+	Reference        string   // Id
+	Account          string   // IBAN, or the other identification of the account
+	Number           string   // ElctrncSeqNb, or LglSeqNb when there is none
+	Opening          *Balance // OPBD, or PRCD when there is no OPBD
+	Closing          *Balance // CLBD
+	ClosingAvailable *Balance // CLAV
+}
+
+func signedAmount(amount, creditDebit string) (string, error) {
+	switch creditDebit {
+	case "CRDT":
+		return amount, nil
+	case "DBIT":
+		return "-" + amount, nil
+	}
+	return "", fmt.Errorf("invalid credit/debit indicator '%s'", creditDebit)
+}
+
+type amount struct {
+	Value    string `xml:",chardata"`
+	Currency string `xml:"Ccy,attr"`
+}
+
+type dateChoice struct {
+	Date     string `xml:"Dt"`
+	DateTime string `xml:"DtTm"`
+}
+
+func (d dateChoice) value() string {
+	if d.Date != "" {
+		return strings.TrimSpace(d.Date)
+	}
+	return strings.TrimSpace(d.DateTime)
+}
+
+type balance struct {
+	Code        string     `xml:"Tp>CdOrPrtry>Cd"`
+	Proprietary string     `xml:"Tp>CdOrPrtry>Prtry"`
+	Amount      amount     `xml:"Amt"`
+	CdtDbtInd   string     `xml:"CdtDbtInd"`
+	Date        dateChoice `xml:"Dt"`
+}
+
+type account struct {
+	IBAN  string `xml:"Id>IBAN"`
+	Other string `xml:"Id>Othr>Id"`
+}
+
+type transactionDetails struct {
+	Refs struct {
+		EndToEndID  string `xml:"EndToEndId"`
+		AcctSvcrRef string `xml:"AcctSvcrRef"`
+	} `xml:"Refs"`
+	Amount     *amount `xml:"Amt"`
+	AmtDtlsAmt *amount `xml:"AmtDtls>TxAmt>Amt"`
+	CdtDbtInd  string  `xml:"CdtDbtInd"`
+	RmtInf     struct {
+		Unstructured []string `xml:"Ustrd"`
+		CreditorRefs []string `xml:"Strd>CdtrRefInf>Ref"`
+	} `xml:"RmtInf"`
+	AddtlTxInf string `xml:"AddtlTxInf"`
+}
+
+func (t transactionDetails) amount() *amount {
+	if t.Amount != nil {
+		return t.Amount
+	}
+	return t.AmtDtlsAmt
+}
+
+type entry struct {
+	NtryRef string `xml:"NtryRef"`
+	Amount  amount `xml:"Amt"`
+	// camt.053.001.02 to .08 give the status as text, later versions as a code element.
+	Status struct {
+		Text string `xml:",chardata"`
+		Code string `xml:"Cd"`
+	} `xml:"Sts"`
+	CdtDbtInd    string               `xml:"CdtDbtInd"`
+	BookingDate  dateChoice           `xml:"BookgDt"`
+	ValueDate    dateChoice           `xml:"ValDt"`
+	AcctSvcrRef  string               `xml:"AcctSvcrRef"`
+	Transactions []transactionDetails `xml:"NtryDtls>TxDtls"`
+	AddtlNtryInf string               `xml:"AddtlNtryInf"`
+}
+
+// Reader returns the records of a camt file in order.
+type Reader struct {
+	source     *recordingReader
+	decoder    *xml.Decoder
+	path       []string // the elements open around the decoder's position
+	entries    int
+	pending    []Record
+	statements []Statement
+}
+
+func NewReader(r io.Reader) *Reader {
+	source := &recordingReader{r: r}
+	return &Reader{source: source, decoder: xml.NewDecoder(source)}
+}
+
+// Statements returns the statements read so far; after Read has returned io.EOF it holds
+// every statement of the file.
+func (r *Reader) Statements() []Statement {
+	return r.statements
+}
+
+// Read returns the next record, or io.EOF after the last entry.
+func (r *Reader) Read() (Record, error) {
+	for len(r.pending) == 0 {
+		if err := r.readEntry(); err != nil {
+			return Record{}, err
+		}
+	}
+	record := r.pending[0]
+	r.pending = r.pending[1:]
+	return record, nil
+}
+
+func (r *Reader) readEntry() error {
+	for {
+		begin := r.decoder.InputOffset()
+		token, err := r.decoder.Token()
+		if err != nil {
+			return err
+		}
+		if end, ok := token.(xml.EndElement); ok {
+			if n := len(r.path); n > 0 && r.path[n-1] == end.Name.Local {
+				r.path = r.path[:n-1]
+			}
+			r.source.discardBefore(r.decoder.InputOffset())
+			continue
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			r.source.discardBefore(r.decoder.InputOffset())
+			continue
+		}
+		if start.Name.Local != "Ntry" {
+			if err := r.readHeader(start, begin); err != nil {
+				return err
+			}
+			r.source.discardBefore(r.decoder.InputOffset())
+			continue
+		}
+
+		var e entry
+		if err := r.decoder.DecodeElement(&e, &start); err != nil {
+			return err
+		}
+		end := r.decoder.InputOffset()
+		raw := r.source.text(begin, end)
+		r.source.discardBefore(end)
+
+		r.entries++
+		r.pending = e.records(r.entries, raw)
+		return nil
+	}
+}
+
+// readHeader takes an element outside the entries: a statement, or the reference,
+// account, sequence number or a balance of the statement it is in.
+func (r *Reader) readHeader(start xml.StartElement, begin int64) error {
+	name := start.Name.Local
+	inStatement := len(r.path) > 0 && (r.path[len(r.path)-1] == "Stmt" || r.path[len(r.path)-1] == "Ntfctn")
+	if !inStatement {
+		if name == "Stmt" || name == "Ntfctn" {
+			r.statements = append(r.statements, Statement{})
+		}
+		r.path = append(r.path, name)
+		return nil
+	}
+
+	statement := &r.statements[len(r.statements)-1]
+	switch name {
+	case "Id", "ElctrncSeqNb", "LglSeqNb":
+		var text string
+		if err := r.decoder.DecodeElement(&text, &start); err != nil {
+			return err
+		}
+		text = strings.TrimSpace(text)
+		switch {
+		case name == "Id":
+			statement.Reference = text
+		case name == "ElctrncSeqNb" || statement.Number == "":
+			statement.Number = text
+		}
+	case "Acct":
+		var a account
+		if err := r.decoder.DecodeElement(&a, &start); err != nil {
+			return err
+		}
+		statement.Account = strings.TrimSpace(a.IBAN)
+		if statement.Account == "" {
+			statement.Account = strings.TrimSpace(a.Other)
+		}
+	case "Bal":
+		var b balance
+		if err := r.decoder.DecodeElement(&b, &start); err != nil {
+			return err
+		}
+		statement.addBalance(b.balance(r.source.text(begin, r.decoder.InputOffset())))
+	default:
+		r.path = append(r.path, name)
+	}
+	return nil
+}
+
+func (b balance) balance(raw string) Balance {
+	code := strings.TrimSpace(b.Code)
+	if code == "" {
+		code = strings.TrimSpace(b.Proprietary)
+	}
+	return Balance{
+		Type:        code,
+		Amount:      strings.TrimSpace(b.Amount.Value),
+		Currency:    strings.TrimSpace(b.Amount.Currency),
+		CreditDebit: strings.TrimSpace(b.CdtDbtInd),
+		Date:        b.Date.value(),
+		Raw:         raw,
+	}
+}
+
+// addBalance keeps the balances a statement is reconciled against and ignores the rest,
+// such as interim and forward available balances.
+func (s *Statement) addBalance(b Balance) {
+	switch b.Type {
+	case "OPBD":
+		s.Opening = &b
+	case "PRCD":
+		if s.Opening == nil || s.Opening.Type != "OPBD" {
+			s.Opening = &b
+		}
+	case "CLBD":
+		s.Closing = &b
+	case "CLAV":
+		s.ClosingAvailable = &b
+	}
+}
+
+func (e entry) records(number int, raw string) []Record {
+	status := strings.TrimSpace(e.Status.Code)
+	if status == "" {
+		status = strings.TrimSpace(e.Status.Text)
+	}
+	base := Record{
+		Entry:              number,
+		EntryRef:           strings.TrimSpace(e.NtryRef),
+		AccountServicerRef: strings.TrimSpace(e.AcctSvcrRef),
+		Amount:             strings.TrimSpace(e.Amount.Value),
+		Currency:           strings.TrimSpace(e.Amount.Currency),
+		CreditDebit:        strings.TrimSpace(e.CdtDbtInd),
+		Status:             status,
+		BookingDate:        e.BookingDate.value(),
+		ValueDate:          e.ValueDate.value(),
+		RemittanceInfo:     strings.TrimSpace(e.AddtlNtryInf),
+		Raw:                raw,
+	}
+
+	// Split a batch booking only when every transaction carries its own amount;
+	// otherwise the entry amount is the only reliable one.
+	split := len(e.Transactions) > 1
+	for _, tx := range e.Transactions {
+		if tx.amount() == nil {
+			split = false
+		}
+	}
+	if !split {
+		if len(e.Transactions) > 0 {
+			base.applyDetails(e.Transactions[0])
+		}
+		return []Record{base}
+	}
+
+	records := make([]Record, 0, len(e.Transactions))
+	for _, tx := range e.Transactions {
+		record := base
+		record.applyDetails(tx)
+		amt := tx.amount()
+		record.Amount = strings.TrimSpace(amt.Value)
+		if amt.Currency != "" {
+			record.Currency = strings.TrimSpace(amt.Currency)
+		}
+		if tx.CdtDbtInd != "" {
+			record.CreditDebit = strings.TrimSpace(tx.CdtDbtInd)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+// applyDetails takes the references and remittance information of one transaction.
+func (r *Record) applyDetails(tx transactionDetails) {
+	if id := strings.TrimSpace(tx.Refs.EndToEndID); id != "" && id != "NOTPROVIDED" {
+		r.EndToEndID = id
+	}
+	if ref := strings.TrimSpace(tx.Refs.AcctSvcrRef); ref != "" {
+		r.AccountServicerRef = ref
+	}
+
+	var parts []string
+	for _, text := range tx.RmtInf.Unstructured {
+		if text = strings.TrimSpace(text); text != "" {
+			parts = append(parts, text)
+		}
+	}
+	if len(parts) == 0 {
+		for _, ref := range tx.RmtInf.CreditorRefs {
+			if ref = strings.TrimSpace(ref); ref != "" {
+				parts = append(parts, ref)
+			}
+		}
+	}
+	if len(parts) == 0 {
+		if info := strings.TrimSpace(tx.AddtlTxInf); info != "" {
+			parts = append(parts, info)
+		}
+	}
+	if len(parts) > 0 {
+		r.RemittanceInfo = strings.Join(parts, " ")
+	}
+}
+
+// recordingReader keeps the bytes the decoder has read but the caller has not yet
+// discarded, so the text of an element can be recovered from its offsets.
+type recordingReader struct {
+	r      io.Reader
+	buf    []byte
+	offset int64 // file offset of buf[0]
+}
+
+func (s *recordingReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	s.buf = append(s.buf, p[:n]...)
+	return n, err
+}
+
+func (s *recordingReader) text(begin, end int64) string {
+	if begin < s.offset || end-s.offset > int64(len(s.buf)) {
+		return ""
+	}
+	return strings.TrimSpace(string(s.buf[begin-s.offset : end-s.offset]))
+}
+
+func (s *recordingReader) discardBefore(offset int64) {
+	drop := offset - s.offset
+	if drop <= 0 {
+		return
+	}
+	if drop > int64(len(s.buf)) {
+		drop = int64(len(s.buf))
+	}
+	s.buf = append(s.buf[:0], s.buf[drop:]...)
+	s.offset += drop
+}
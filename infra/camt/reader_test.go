@@ -0,0 +1,169 @@
+package camt
+
+import (
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func readFixture(t *testing.T) ([]Record, []Statement) {
+	t.Helper()
+	file, err := os.Open("testdata/camt053.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	reader := NewReader(file)
+	var records []Record
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Read: %v", err)
+		}
+		records = append(records, record)
+	}
+	return records, reader.Statements()
+}
+
+func TestReaderRecords(t *testing.T) {
+	records, _ := readFixture(t)
+
+	want := []Record{
+		{Entry: 1, EntryRef: "N1", EndToEndID: "E2E-1", Amount: "1600500", Currency: "IDR", CreditDebit: "CRDT", Status: "BOOK",
+			BookingDate: "2024-06-01", ValueDate: "2024-06-02", RemittanceInfo: "invoice 1001"},
+		// NOTPROVIDED leaves the end-to-end ID empty; the status is given as text.
+		{Entry: 2, AccountServicerRef: "SVC-2", Amount: "5000", Currency: "JPY", CreditDebit: "DBIT", Status: "BOOK",
+			BookingDate: "2024-06-01T23:30:00+07:00", RemittanceInfo: "RF18 5390"},
+		{Entry: 3, Amount: "1", Currency: "USD", CreditDebit: "CRDT", Status: "PDNG", BookingDate: "2024-06-01"},
+		// A batch booking whose transactions all carry an amount is split; each takes its
+		// own currency and indicator when it has one.
+		{Entry: 4, EntryRef: "N4", EndToEndID: "E2E-4A", Amount: "100", Currency: "IDR", CreditDebit: "CRDT", Status: "BOOK",
+			BookingDate: "2024-06-01", RemittanceInfo: "batch 4"},
+		{Entry: 4, EntryRef: "N4", EndToEndID: "E2E-4B", Amount: "99.999", Currency: "BHD", CreditDebit: "DBIT", Status: "BOOK",
+			BookingDate: "2024-06-01", RemittanceInfo: "refund"},
+		// One transaction without an amount keeps the entry whole.
+		{Entry: 5, EntryRef: "N5", EndToEndID: "E2E-5A", Amount: "700", Currency: "IDR", CreditDebit: "CRDT",
+			BookingDate: "2024-06-01"},
+		{Entry: 6, EntryRef: "N6", Amount: "3", Currency: "USD", CreditDebit: "XXXX", Status: "INFO", BookingDate: "2024-06-02"},
+	}
+
+	if len(records) != len(want) {
+		t.Fatalf("read %d records, want %d", len(records), len(want))
+	}
+	for i, record := range records {
+		if !strings.HasPrefix(record.Raw, "<Ntry>") || !strings.HasSuffix(record.Raw, "</Ntry>") {
+			t.Errorf("record %d: Raw = %q, want the entry's XML", i, record.Raw)
+		}
+		record.Raw = ""
+		if !reflect.DeepEqual(record, want[i]) {
+			t.Errorf("record %d = %+v, want %+v", i, record, want[i])
+		}
+	}
+}
+
+func TestRecordBooked(t *testing.T) {
+	tests := []struct {
+		status string
+		want   bool
+	}{
+		{status: "BOOK", want: true},
+		{status: "", want: true},
+		{status: "PDNG", want: false},
+		{status: "INFO", want: false},
+		{status: "book", want: false},
+	}
+	for _, tt := range tests {
+		if got := (Record{Status: tt.status}).Booked(); got != tt.want {
+			t.Errorf("Record{Status: %q}.Booked() = %v, want %v", tt.status, got, tt.want)
+		}
+	}
+
+	records, _ := readFixture(t)
+	var booked []string
+	for _, record := range records {
+		if record.Booked() {
+			booked = append(booked, record.Amount)
+		}
+	}
+	if want := []string{"1600500", "5000", "100", "99.999", "700"}; !reflect.DeepEqual(booked, want) {
+		t.Errorf("booked amounts = %v, want %v", booked, want)
+	}
+}
+
+func TestRecordSignedAmount(t *testing.T) {
+	tests := []struct {
+		amount, creditDebit string
+		want                string
+		wantErr             bool
+	}{
+		{amount: "1600500", creditDebit: "CRDT", want: "1600500"},
+		{amount: "99.999", creditDebit: "DBIT", want: "-99.999"},
+		{amount: "3", creditDebit: "XXXX", wantErr: true},
+		{amount: "3", creditDebit: "", wantErr: true},
+		{amount: "3", creditDebit: "dbit", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := (Record{Amount: tt.amount, CreditDebit: tt.creditDebit}).SignedAmount()
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("SignedAmount(%s %s) = %q, want an error", tt.creditDebit, tt.amount, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("SignedAmount(%s %s) = %q, %v; want %q", tt.creditDebit, tt.amount, got, err, tt.want)
+		}
+	}
+}
+
+func TestReaderStatements(t *testing.T) {
+	_, statements := readFixture(t)
+
+	want := []Statement{
+		{
+			Reference: "STMT-1",
+			Account:   "ID12BANK0001",
+			Number:    "101",
+			// OPBD replaces the PRCD before it; the interim ITBD is ignored.
+			Opening:          &Balance{Type: "OPBD", Amount: "1000", Currency: "IDR", CreditDebit: "CRDT", Date: "2024-06-01"},
+			Closing:          &Balance{Type: "CLBD", Amount: "250.5", Currency: "IDR", CreditDebit: "DBIT", Date: "2024-06-01T23:59:59+07:00"},
+			ClosingAvailable: &Balance{Type: "CLAV", Amount: "200", Currency: "IDR", CreditDebit: "DBIT", Date: "2024-06-01"},
+		},
+		{
+			Reference: "STMT-2",
+			Account:   "0012345",
+			Number:    "8",
+			// A PRCD after the OPBD does not replace it.
+			Opening: &Balance{Type: "OPBD", Amount: "10", Currency: "USD", CreditDebit: "DBIT", Date: "2024-06-02"},
+		},
+	}
+
+	if len(statements) != len(want) {
+		t.Fatalf("read %d statements, want %d", len(statements), len(want))
+	}
+	for i, statement := range statements {
+		for _, balance := range []*Balance{statement.Opening, statement.Closing, statement.ClosingAvailable} {
+			if balance == nil {
+				continue
+			}
+			if !strings.HasPrefix(balance.Raw, "<Bal>") || !strings.HasSuffix(balance.Raw, "</Bal>") {
+				t.Errorf("statement %d: %s Raw = %q, want the balance's XML", i, balance.Type, balance.Raw)
+			}
+			balance.Raw = ""
+		}
+		if !reflect.DeepEqual(statement, want[i]) {
+			t.Errorf("statement %d = %+v, want %+v", i, statement, want[i])
+		}
+	}
+
+	signed, err := statements[0].Closing.SignedAmount()
+	if err != nil || signed != "-250.5" {
+		t.Errorf("closing SignedAmount() = %q, %v; want -250.5", signed, err)
+	}
+}
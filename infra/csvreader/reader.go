@@ -0,0 +1,160 @@
+// Package csvreader reads delimited text files whose delimiter and quote character are
+// configurable, which encoding/csv does not allow for the quote.
+package csvreader
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+var ErrUnterminatedQuote = errors.New("unterminated quoted field")
+
+type Reader struct {
+	r         *bufio.Reader
+	delimiter rune
+	quote     rune
+	line      int // line the last record started on, 1-based
+	nextLine  int
+	offset    int64 // bytes consumed through the end of the last record
+	raw       strings.Builder
+}
+
+// NewReader returns a Reader splitting fields on delimiter. Fields may be wrapped in quote,
+// inside which the delimiter and line breaks are literal and a doubled quote stands for
+// one quote character.
+func NewReader(r io.Reader, delimiter, quote rune) *Reader {
+	return NewReaderAt(r, delimiter, quote, 0, 1)
+}
+
+// NewReaderAt continues reading a file from a position previously reported by Offset and
+// Line: r must already be positioned at offset, and line is the line number found there.
+func NewReaderAt(r io.Reader, delimiter, quote rune, offset int64, line int) *Reader {
+	return &Reader{
+		r:         bufio.NewReader(r),
+		delimiter: delimiter,
+		quote:     quote,
+		nextLine:  line,
+		offset:    offset,
+	}
+}
+
+// Line returns the line number the last record read started on.
+func (r *Reader) Line() int {
+	return r.line
+}
+
+// NextLine returns the line number the next record will be looked for on.
+func (r *Reader) NextLine() int {
+	return r.nextLine
+}
+
+// Offset returns the byte offset just past the last record read, where a later
+// NewReaderAt can resume.
+func (r *Reader) Offset() int64 {
+	return r.offset
+}
+
+// Raw returns the text of the last record read as it appeared in the file, without the
+// final line ending.
+func (r *Reader) Raw() string {
+	return r.raw.String()
+}
+
+// Read returns the next record, or io.EOF once the input is exhausted.
+func (r *Reader) Read() ([]string, error) {
+	line, err := r.readLine(true)
+	if err != nil {
+		return nil, err
+	}
+	r.line = r.nextLine - 1
+	r.raw.Reset()
+	r.raw.WriteString(line)
+
+	var fields []string
+	var field strings.Builder
+	inQuotes := false
+	atFieldStart := true
+	for {
+		runes := []rune(line)
+		for i := 0; i < len(runes); i++ {
+			c := runes[i]
+			switch {
+			case inQuotes && c == r.quote:
+				if i+1 < len(runes) && runes[i+1] == r.quote {
+					field.WriteRune(c)
+					i++
+				} else {
+					inQuotes = false
+				}
+			case inQuotes:
+				field.WriteRune(c)
+			case c == r.delimiter:
+				fields = append(fields, field.String())
+				field.Reset()
+				atFieldStart = true
+				continue
+			case c == r.quote && atFieldStart:
+				inQuotes = true
+			default:
+				field.WriteRune(c)
+			}
+			atFieldStart = false
+		}
+
+		if !inQuotes {
+			break
+		}
+		// A quoted field spans the line break; keep it and read on.
+		next, err := r.readLine(false)
+		if err == io.EOF {
+			return nil, fmt.Errorf("line %d: %w", r.line, ErrUnterminatedQuote)
+		}
+		if err != nil {
+			return nil, err
+		}
+		field.WriteRune('\n')
+		r.raw.WriteRune('\n')
+		r.raw.WriteString(next)
+		line = next
+		atFieldStart = false
+	}
+	return append(fields, field.String()), nil
+}
+
+// ReadAll reads every remaining record.
+func (r *Reader) ReadAll() ([][]string, error) {
+	var records [][]string
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+}
+
+// readLine returns the next line without its line ending. Empty lines are skipped between
+// records but kept inside a quoted field.
+func (r *Reader) readLine(skipEmpty bool) (string, error) {
+	for {
+		line, err := r.r.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			return "", err
+		}
+		r.nextLine++
+		r.offset += int64(len(line))
+		line = strings.TrimRight(line, "\r\n")
+		if line != "" || !skipEmpty {
+			return line, nil
+		}
+		if err == io.EOF {
+			return "", io.EOF
+		}
+	}
+}
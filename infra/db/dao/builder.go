@@ -9,13 +9,47 @@ import (
 type DaoMethod interface {
 	GetReconciliationProcessLog() ([]model.ReconciliationProcessLog, error)
 	GetReconciliationProcessLogByStatusList(statusList []int) ([]model.ReconciliationProcessLog, error)
+	GetSchedulableReconciliationProcessLogs(statusList []int) ([]model.ReconciliationProcessLog, error)
 	CreateReconciliationProcessLog(payloadList *model.ReconciliationProcessLog) error
 	CreateReconciliationProcessLogAsset(payload *model.ReconciliationProcessLogAsset) error
 	GetReconciliationProcessLogByID(logID uint) (model.ReconciliationProcessLog, error)
 	GetReconciliationLogAssetsByLogID(logID uint) ([]model.ReconciliationProcessLogAsset, error)
+	UpdateReconciliationProcessLogAssetStageTime(assetID int64, stageTime int64) error
 	UpdateReconciliationProcessLog(logEntry model.ReconciliationProcessLog) error
+	UpdateReconciliationProcessLogAsWorker(logEntry model.ReconciliationProcessLog, workerID string, heartbeatTime int64) (bool, error)
+	UpdateReconciliationProcessLogClaimTime(logID int64, claimTime int64) error
+	GetLastClaimedReconciliationOperator() (string, error)
+	UpdateReconciliationProcessLogHeartbeat(logID int64, workerID string, heartbeatTime int64) (bool, error)
+	RenewReconciliationProcessLogHeartbeat(logID int64, workerID string, lastHeartbeatTime, heartbeatTime int64) (bool, error)
+	ClearReconciliationProcessLogHeartbeat(logID int64, workerID string) error
+	CountReconciliationProcessLogsWithWorker(statusList []int, createBy string) (int64, error)
+	GetStaleReconciliationProcessLogs(statusList []int, heartbeatBefore int64) ([]model.ReconciliationProcessLog, error)
+	ReleaseStaleReconciliationProcessLog(logEntry model.ReconciliationProcessLog, workerID string, heartbeatTime int64) (bool, error)
+	CreateReconciliationBankConsumption(payload *model.ReconciliationBankConsumption) error
+	CreateReconciliationMatch(payload *model.ReconciliationMatch) error
+	GetReconciliationMatchesByLogID(logID uint, trxID string, limit, offset int) ([]model.ReconciliationMatch, int64, error)
+	CreateReconciliationBankRows(rows []model.ReconciliationBankRow) error
+	GetUnconsumedReconciliationBankRows(logID uint, filter BankRowFilter) ([]model.ReconciliationBankRow, error)
+	GetUnconsumedReconciliationBankRowsByLogID(logID uint, afterID int64, limit int) ([]model.ReconciliationBankRow, error)
+	DeleteReconciliationBankRowsByLogID(logID uint) error
+	DeleteReconciliationBankRowsByAssetID(assetID uint) error
+	CreateReconciliationRejectedRows(rows []model.ReconciliationRejectedRow) error
+	GetReconciliationRejectedRowsByLogID(logID uint, afterID int64, limit int) ([]model.ReconciliationRejectedRow, error)
+	CreateReconciliationUnmatchedTransactions(rows []model.ReconciliationUnmatchedTransaction) error
+	GetReconciliationUnmatchedTransactionsByLogID(logID uint, afterID int64, limit int) ([]model.ReconciliationUnmatchedTransaction, error)
+	DeleteReconciliationUnmatchedTransactionsByLogID(logID uint) error
+	SaveReconciliationCsvProfile(payload *model.ReconciliationCsvProfile) error
+	GetReconciliationCsvProfileByName(name string) (model.ReconciliationCsvProfile, error)
+	GetReconciliationCsvProfiles() ([]model.ReconciliationCsvProfile, error)
+	ClaimReconciliationJobLease(logID int64, owner string, leaseInSec int64) (bool, error)
+	RenewReconciliationJobLease(logID int64, owner string, leaseInSec int64) (bool, error)
+	ReleaseReconciliationJobLease(logID int64, owner string) error
+	WithTransaction(fn func(txDao DaoMethod) error) error
 }
 
+// ErrRecordNotFound is wrapped by lookups of a single record that does not exist.
+var ErrRecordNotFound = gorm.ErrRecordNotFound
+
 type dao struct {
 	db *gorm.DB
 }
@@ -23,3 +57,10 @@ type dao struct {
 func NewDaoMethod(db *gorm.DB) DaoMethod {
 	return &dao{db: db}
 }
+
+// WithTransaction runs fn against a DaoMethod bound to a single database transaction.
+func (d *dao) WithTransaction(fn func(txDao DaoMethod) error) error {
+	return d.db.Transaction(func(tx *gorm.DB) error {
+		return fn(&dao{db: tx})
+	})
+}
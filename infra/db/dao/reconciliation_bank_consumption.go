@@ -0,0 +1,14 @@
+package dao
+
+import (
+	"fmt"
+
+	"github.com/radhian/reconciliation-system/infra/db/model"
+)
+
+func (d *dao) CreateReconciliationBankConsumption(payload *model.ReconciliationBankConsumption) error {
+	if err := d.db.Create(payload).Error; err != nil {
+		return fmt.Errorf("failed to save bank consumption: %v", err)
+	}
+	return nil
+}
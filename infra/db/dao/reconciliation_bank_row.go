@@ -0,0 +1,164 @@
+package dao
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+	"github.com/radhian/reconciliation-system/entity"
+	"github.com/radhian/reconciliation-system/infra/db/model"
+)
+
+// multiRowInsertChunk keeps a multi-row insert well under the Postgres parameter limit.
+const multiRowInsertChunk = 500
+
+// bankRowQueryChunk bounds the size of the IN lists and OR groups of one query.
+const bankRowQueryChunk = 200
+
+// BankRowFilter selects staged bank rows. A row matches when its match key, its group key,
+// its reference or its amount range is listed.
+type BankRowFilter struct {
+	MatchKeys  []string
+	GroupKeys  []string
+	References []string
+	Ranges     []BankRowRange
+}
+
+// BankRowRange selects rows of one group key whose absolute amount lies within [Min, Max].
+type BankRowRange struct {
+	GroupKey string
+	Min      entity.Amount
+	Max      entity.Amount
+}
+
+// CreateReconciliationBankRows stages rows with multi-row inserts; gorm v1 would issue one
+// statement per row.
+func (d *dao) CreateReconciliationBankRows(rows []model.ReconciliationBankRow) error {
+	table := d.db.NewScope(&model.ReconciliationBankRow{}).TableName()
+	for start := 0; start < len(rows); start += multiRowInsertChunk {
+		end := start + multiRowInsertChunk
+		if end > len(rows) {
+			end = len(rows)
+		}
+
+		placeholders := make([]string, 0, end-start)
+		args := make([]interface{}, 0, (end-start)*16)
+		for _, r := range rows[start:end] {
+			placeholders = append(placeholders, "(?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)")
+			args = append(args, r.ReconciliationProcessLogID, r.AssetID, r.SourceFile, r.RowNumber,
+				r.UniqueIdentifier, r.Description, r.Amount, r.Currency, r.BankDate, r.ValueDate, r.Direction,
+				r.MatchKey, r.GroupKey, r.AbsAmount, r.Reference, r.CreateTime)
+		}
+
+		query := fmt.Sprintf("INSERT INTO %s (reconciliation_process_log_id, asset_id, source_file, row_number, "+
+			"unique_identifier, description, amount, currency, bank_date, value_date, direction, match_key, group_key, abs_amount, reference, create_time) VALUES %s",
+			table, strings.Join(placeholders, ","))
+		if err := d.db.Exec(query, args...).Error; err != nil {
+			return fmt.Errorf("failed to stage bank rows: %v", err)
+		}
+	}
+	return nil
+}
+
+// GetUnconsumedReconciliationBankRows returns the staged rows of a log selected by the
+// filter that no match has consumed yet, in file order.
+func (d *dao) GetUnconsumedReconciliationBankRows(logID uint, filter BankRowFilter) ([]model.ReconciliationBankRow, error) {
+	base := d.unconsumedBankRows(logID)
+
+	found := make(map[int64]model.ReconciliationBankRow)
+	collect := func(where string, args ...interface{}) error {
+		var rows []model.ReconciliationBankRow
+		if err := base.Where(where, args...).Find(&rows).Error; err != nil {
+			return fmt.Errorf("failed to fetch staged bank rows: %w", err)
+		}
+		for _, r := range rows {
+			found[r.ID] = r
+		}
+		return nil
+	}
+
+	for start := 0; start < len(filter.MatchKeys); start += bankRowQueryChunk {
+		if err := collect("match_key IN (?)", filter.MatchKeys[start:minInt(start+bankRowQueryChunk, len(filter.MatchKeys))]); err != nil {
+			return nil, err
+		}
+	}
+	for start := 0; start < len(filter.GroupKeys); start += bankRowQueryChunk {
+		if err := collect("group_key IN (?)", filter.GroupKeys[start:minInt(start+bankRowQueryChunk, len(filter.GroupKeys))]); err != nil {
+			return nil, err
+		}
+	}
+	for start := 0; start < len(filter.References); start += bankRowQueryChunk {
+		if err := collect("reference IN (?)", filter.References[start:minInt(start+bankRowQueryChunk, len(filter.References))]); err != nil {
+			return nil, err
+		}
+	}
+	for start := 0; start < len(filter.Ranges); start += bankRowQueryChunk {
+		var conditions []string
+		var args []interface{}
+		for _, r := range filter.Ranges[start:minInt(start+bankRowQueryChunk, len(filter.Ranges))] {
+			conditions = append(conditions, "(group_key = ? AND abs_amount BETWEEN ? AND ?)")
+			args = append(args, r.GroupKey, r.Min, r.Max)
+		}
+		if err := collect(strings.Join(conditions, " OR "), args...); err != nil {
+			return nil, err
+		}
+	}
+
+	rows := make([]model.ReconciliationBankRow, 0, len(found))
+	for _, r := range found {
+		rows = append(rows, r)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].AssetID != rows[j].AssetID {
+			return rows[i].AssetID < rows[j].AssetID
+		}
+		return rows[i].RowNumber < rows[j].RowNumber
+	})
+	return rows, nil
+}
+
+// GetUnconsumedReconciliationBankRowsByLogID returns up to limit staged rows of a log with
+// an ID above afterID that no match has consumed yet, in the order they were staged.
+func (d *dao) GetUnconsumedReconciliationBankRowsByLogID(logID uint, afterID int64, limit int) ([]model.ReconciliationBankRow, error) {
+	table := d.db.NewScope(&model.ReconciliationBankRow{}).TableName()
+	var rows []model.ReconciliationBankRow
+	err := d.unconsumedBankRows(logID).Where(table+".id > ?", afterID).
+		Order(table + ".id ASC").Limit(limit).Find(&rows).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch staged bank rows: %w", err)
+	}
+	return rows, nil
+}
+
+// unconsumedBankRows scopes a query to the staged rows of a log without a consumption row.
+func (d *dao) unconsumedBankRows(logID uint) *gorm.DB {
+	table := d.db.NewScope(&model.ReconciliationBankRow{}).TableName()
+	consumptions := d.db.NewScope(&model.ReconciliationBankConsumption{}).TableName()
+	return d.db.Where(table+".reconciliation_process_log_id = ?", logID).
+		Where(fmt.Sprintf("NOT EXISTS (SELECT 1 FROM %[1]s c WHERE c.reconciliation_process_log_id = %[2]s.reconciliation_process_log_id "+
+			"AND c.source_file = %[2]s.source_file AND c.row_number = %[2]s.row_number)", consumptions, table))
+}
+
+func (d *dao) DeleteReconciliationBankRowsByLogID(logID uint) error {
+	if err := d.db.Where("reconciliation_process_log_id = ?", logID).Delete(&model.ReconciliationBankRow{}).Error; err != nil {
+		return fmt.Errorf("failed to delete staged bank rows: %v", err)
+	}
+	return nil
+}
+
+// DeleteReconciliationBankRowsByAssetID drops what was staged from one file, so a file
+// that fails part way through can be left out of the job.
+func (d *dao) DeleteReconciliationBankRowsByAssetID(assetID uint) error {
+	if err := d.db.Where("asset_id = ?", assetID).Delete(&model.ReconciliationBankRow{}).Error; err != nil {
+		return fmt.Errorf("failed to delete staged bank rows: %v", err)
+	}
+	return nil
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
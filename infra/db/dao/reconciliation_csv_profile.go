@@ -0,0 +1,42 @@
+package dao
+
+import (
+	"fmt"
+
+	"github.com/jinzhu/gorm"
+	"github.com/radhian/reconciliation-system/infra/db/model"
+)
+
+// SaveReconciliationCsvProfile creates the profile, or replaces the one with the same name.
+func (d *dao) SaveReconciliationCsvProfile(payload *model.ReconciliationCsvProfile) error {
+	var existing model.ReconciliationCsvProfile
+	err := d.db.Where("name = ?", payload.Name).First(&existing).Error
+	if err == nil {
+		payload.ID = existing.ID
+		payload.CreateTime = existing.CreateTime
+		payload.CreateBy = existing.CreateBy
+	} else if !gorm.IsRecordNotFoundError(err) {
+		return fmt.Errorf("failed to fetch csv profile: %w", err)
+	}
+
+	if err := d.db.Save(payload).Error; err != nil {
+		return fmt.Errorf("failed to save csv profile: %v", err)
+	}
+	return nil
+}
+
+func (d *dao) GetReconciliationCsvProfileByName(name string) (model.ReconciliationCsvProfile, error) {
+	var profile model.ReconciliationCsvProfile
+	if err := d.db.Where("name = ?", name).First(&profile).Error; err != nil {
+		return profile, fmt.Errorf("failed to fetch csv profile: %w", err)
+	}
+	return profile, nil
+}
+
+func (d *dao) GetReconciliationCsvProfiles() ([]model.ReconciliationCsvProfile, error) {
+	var profiles []model.ReconciliationCsvProfile
+	if err := d.db.Order("name ASC").Find(&profiles).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch csv profiles: %w", err)
+	}
+	return profiles, nil
+}
@@ -0,0 +1,47 @@
+package dao
+
+import (
+	"fmt"
+
+	"github.com/radhian/reconciliation-system/infra/db/model"
+)
+
+// ClaimReconciliationJobLease takes the lease of a job for owner when the job has none or
+// its lease has expired, in a single statement so two workers can never both succeed.
+// Lease times come from the database clock, keeping hosts with drifting clocks in line.
+func (d *dao) ClaimReconciliationJobLease(logID int64, owner string, leaseInSec int64) (bool, error) {
+	table := d.db.NewScope(&model.ReconciliationJobLease{}).TableName()
+	query := fmt.Sprintf(`INSERT INTO %[1]s (reconciliation_process_log_id, owner, expire_time, heartbeat_time)
+VALUES (?, ?, EXTRACT(EPOCH FROM NOW())::bigint + ?, EXTRACT(EPOCH FROM NOW())::bigint)
+ON CONFLICT (reconciliation_process_log_id) DO UPDATE
+SET owner = EXCLUDED.owner, expire_time = EXCLUDED.expire_time, heartbeat_time = EXCLUDED.heartbeat_time
+WHERE %[1]s.expire_time < EXCLUDED.heartbeat_time`, table)
+
+	result := d.db.Exec(query, logID, owner, leaseInSec)
+	if result.Error != nil {
+		return false, fmt.Errorf("failed to claim job lease: %v", result.Error)
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// RenewReconciliationJobLease extends a lease owner still holds and reports false when
+// the lease has been lost to another worker.
+func (d *dao) RenewReconciliationJobLease(logID int64, owner string, leaseInSec int64) (bool, error) {
+	table := d.db.NewScope(&model.ReconciliationJobLease{}).TableName()
+	query := fmt.Sprintf(`UPDATE %s
+SET expire_time = EXTRACT(EPOCH FROM NOW())::bigint + ?, heartbeat_time = EXTRACT(EPOCH FROM NOW())::bigint
+WHERE reconciliation_process_log_id = ? AND owner = ?`, table)
+
+	result := d.db.Exec(query, leaseInSec, logID, owner)
+	if result.Error != nil {
+		return false, fmt.Errorf("failed to renew job lease: %v", result.Error)
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (d *dao) ReleaseReconciliationJobLease(logID int64, owner string) error {
+	if err := d.db.Where("reconciliation_process_log_id = ? AND owner = ?", logID, owner).Delete(&model.ReconciliationJobLease{}).Error; err != nil {
+		return fmt.Errorf("failed to release job lease: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package dao
+
+import (
+	"fmt"
+
+	"github.com/radhian/reconciliation-system/infra/db/model"
+)
+
+func (d *dao) CreateReconciliationMatch(payload *model.ReconciliationMatch) error {
+	if err := d.db.Create(payload).Error; err != nil {
+		return fmt.Errorf("failed to save reconciliation match: %v", err)
+	}
+	return nil
+}
+
+// GetReconciliationMatchesByLogID returns one page of matches for a log, optionally
+// narrowed to a single system transaction ID, together with the total match count.
+func (d *dao) GetReconciliationMatchesByLogID(logID uint, trxID string, limit, offset int) ([]model.ReconciliationMatch, int64, error) {
+	query := d.db.Model(&model.ReconciliationMatch{}).Where("reconciliation_process_log_id = ?", logID)
+	if trxID != "" {
+		query = query.Where("system_trx_id = ?", trxID)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to count reconciliation matches: %w", err)
+	}
+
+	var matches []model.ReconciliationMatch
+	if err := query.Order("id ASC").Limit(limit).Offset(offset).Find(&matches).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to fetch reconciliation matches: %w", err)
+	}
+	return matches, total, nil
+}
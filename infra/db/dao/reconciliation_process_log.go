@@ -18,7 +18,6 @@ func (d *dao) GetReconciliationProcessLog() ([]model.ReconciliationProcessLog, e
 func (d *dao) GetReconciliationProcessLogByStatusList(statusList []int) ([]model.ReconciliationProcessLog, error) {
 	var processLogList []model.ReconciliationProcessLog
 	if err := d.db.
-		Select("id").
 		Where("status IN (?)", statusList).
 		Order("create_time ASC").
 		Find(&processLogList).Error; err != nil {
@@ -27,6 +26,21 @@ func (d *dao) GetReconciliationProcessLogByStatusList(statusList []int) ([]model
 	return processLogList, nil
 }
 
+// GetSchedulableReconciliationProcessLogs lists the logs in statusList with only the
+// fields the job scheduler orders them by: ID, Priority, CreateBy, CreateTime and WorkerID.
+// They come highest priority first, then oldest first.
+func (d *dao) GetSchedulableReconciliationProcessLogs(statusList []int) ([]model.ReconciliationProcessLog, error) {
+	var processLogList []model.ReconciliationProcessLog
+	if err := d.db.
+		Select("id, priority, create_by, create_time, worker_id").
+		Where("status IN (?)", statusList).
+		Order("priority DESC, create_time ASC").
+		Find(&processLogList).Error; err != nil {
+		return nil, fmt.Errorf("failed to list schedulable logs: %w", err)
+	}
+	return processLogList, nil
+}
+
 func (d *dao) CreateReconciliationProcessLog(payload *model.ReconciliationProcessLog) error {
 	if err := d.db.Create(payload).Error; err != nil {
 		return fmt.Errorf("failed to save file asset: %v", err)
@@ -42,9 +56,152 @@ func (d *dao) GetReconciliationProcessLogByID(logID uint) (model.ReconciliationP
 	return logEntry, nil
 }
 
+// UpdateReconciliationProcessLog saves the log except for its heartbeat, which the worker
+// writes on its own while the job runs.
 func (d *dao) UpdateReconciliationProcessLog(logEntry model.ReconciliationProcessLog) error {
-	if err := d.db.Save(&logEntry).Error; err != nil {
+	if err := d.db.Omit("worker_id", "heartbeat_time", "claim_time").Save(&logEntry).Error; err != nil {
 		return fmt.Errorf("failed to update log: %w", err)
 	}
 	return nil
 }
+
+// UpdateReconciliationProcessLogAsWorker saves the progress, status and error of logEntry,
+// but only while the log still carries workerID and the heartbeatTime that worker last
+// wrote. It reports false when the job has been taken back from the worker since.
+func (d *dao) UpdateReconciliationProcessLogAsWorker(logEntry model.ReconciliationProcessLog, workerID string, heartbeatTime int64) (bool, error) {
+	result := d.db.Model(&model.ReconciliationProcessLog{}).
+		Where("id = ? AND worker_id = ? AND heartbeat_time = ?", logEntry.ID, workerID, heartbeatTime).
+		Updates(map[string]interface{}{
+			"total_main_row":      logEntry.TotalMainRow,
+			"current_main_row":    logEntry.CurrentMainRow,
+			"current_main_offset": logEntry.CurrentMainOffset,
+			"current_main_line":   logEntry.CurrentMainLine,
+			"stage_time":          logEntry.StageTime,
+			"process_info":        logEntry.ProcessInfo,
+			"status":              logEntry.Status,
+			"result":              logEntry.Result,
+			"error_code":          logEntry.ErrorCode,
+			"error_message":       logEntry.ErrorMessage,
+			"failed_attempts":     logEntry.FailedAttempts,
+			"update_time":         logEntry.UpdateTime,
+			"update_by":           logEntry.UpdateBy,
+		})
+	if result.Error != nil {
+		return false, fmt.Errorf("failed to update log: %w", result.Error)
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// UpdateReconciliationProcessLogClaimTime records when a worker took the job. The operator
+// of the job taken last decides whose turn is next on every cron server.
+func (d *dao) UpdateReconciliationProcessLogClaimTime(logID int64, claimTime int64) error {
+	if err := d.db.Model(&model.ReconciliationProcessLog{}).
+		Where("id = ?", logID).
+		Update("claim_time", claimTime).Error; err != nil {
+		return fmt.Errorf("failed to update claim time: %w", err)
+	}
+	return nil
+}
+
+// GetLastClaimedReconciliationOperator returns the operator of the job a worker took last,
+// or an empty string when no job has been taken yet.
+func (d *dao) GetLastClaimedReconciliationOperator() (string, error) {
+	var logs []model.ReconciliationProcessLog
+	if err := d.db.
+		Select("create_by").
+		Where("claim_time > 0").
+		Order("claim_time DESC, id DESC").
+		Limit(1).
+		Find(&logs).Error; err != nil {
+		return "", fmt.Errorf("failed to find last claimed log: %w", err)
+	}
+	if len(logs) == 0 {
+		return "", nil
+	}
+	return logs[0].CreateBy, nil
+}
+
+// UpdateReconciliationProcessLogHeartbeat records a heartbeat from workerID on a log that
+// has no worker or already has this one. It reports false when another worker is on the
+// log, which stays that way until the worker clears it or the job is taken back from it.
+func (d *dao) UpdateReconciliationProcessLogHeartbeat(logID int64, workerID string, heartbeatTime int64) (bool, error) {
+	result := d.db.Model(&model.ReconciliationProcessLog{}).
+		Where("id = ? AND worker_id IN (?, '')", logID, workerID).
+		Updates(map[string]interface{}{"worker_id": workerID, "heartbeat_time": heartbeatTime})
+	if result.Error != nil {
+		return false, fmt.Errorf("failed to update heartbeat: %w", result.Error)
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// RenewReconciliationProcessLogHeartbeat moves the heartbeat of workerID on from
+// lastHeartbeatTime to heartbeatTime. It reports false when the log no longer carries that
+// heartbeat, so a job taken back from the worker is not claimed again by its next beat.
+func (d *dao) RenewReconciliationProcessLogHeartbeat(logID int64, workerID string, lastHeartbeatTime, heartbeatTime int64) (bool, error) {
+	result := d.db.Model(&model.ReconciliationProcessLog{}).
+		Where("id = ? AND worker_id = ? AND heartbeat_time = ?", logID, workerID, lastHeartbeatTime).
+		Update("heartbeat_time", heartbeatTime)
+	if result.Error != nil {
+		return false, fmt.Errorf("failed to renew heartbeat: %w", result.Error)
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// CountReconciliationProcessLogsWithWorker counts the logs in statusList created by
+// createBy that a worker is on.
+func (d *dao) CountReconciliationProcessLogsWithWorker(statusList []int, createBy string) (int64, error) {
+	var count int64
+	if err := d.db.Model(&model.ReconciliationProcessLog{}).
+		Where("status IN (?) AND create_by = ? AND worker_id <> ''", statusList, createBy).
+		Count(&count).Error; err != nil {
+		return 0, fmt.Errorf("failed to count running logs: %w", err)
+	}
+	return count, nil
+}
+
+// ClearReconciliationProcessLogHeartbeat removes the worker from the log, unless the job
+// has been taken back from it in the meantime.
+func (d *dao) ClearReconciliationProcessLogHeartbeat(logID int64, workerID string) error {
+	if err := d.db.Model(&model.ReconciliationProcessLog{}).
+		Where("id = ? AND worker_id = ?", logID, workerID).
+		Updates(map[string]interface{}{"worker_id": "", "heartbeat_time": 0}).Error; err != nil {
+		return fmt.Errorf("failed to clear heartbeat: %w", err)
+	}
+	return nil
+}
+
+// GetStaleReconciliationProcessLogs returns the logs in statusList whose worker last sent a
+// heartbeat before heartbeatBefore.
+func (d *dao) GetStaleReconciliationProcessLogs(statusList []int, heartbeatBefore int64) ([]model.ReconciliationProcessLog, error) {
+	var processLogList []model.ReconciliationProcessLog
+	if err := d.db.
+		Where("status IN (?) AND worker_id <> '' AND heartbeat_time < ?", statusList, heartbeatBefore).
+		Order("heartbeat_time ASC").
+		Find(&processLogList).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch stale logs: %w", err)
+	}
+	return processLogList, nil
+}
+
+// ReleaseStaleReconciliationProcessLog takes the job back from a dead worker: it clears the
+// heartbeat and saves the recovery count, status and error of logEntry, but only while the
+// log still carries the stale workerID and heartbeatTime it was found with. It reports
+// false when the worker has beaten since or another reaper got there first.
+func (d *dao) ReleaseStaleReconciliationProcessLog(logEntry model.ReconciliationProcessLog, workerID string, heartbeatTime int64) (bool, error) {
+	result := d.db.Model(&model.ReconciliationProcessLog{}).
+		Where("id = ? AND worker_id = ? AND heartbeat_time = ?", logEntry.ID, workerID, heartbeatTime).
+		Updates(map[string]interface{}{
+			"worker_id":      "",
+			"heartbeat_time": 0,
+			"recovery_count": logEntry.RecoveryCount,
+			"status":         logEntry.Status,
+			"error_code":     logEntry.ErrorCode,
+			"error_message":  logEntry.ErrorMessage,
+			"update_time":    logEntry.UpdateTime,
+			"update_by":      logEntry.UpdateBy,
+		})
+	if result.Error != nil {
+		return false, fmt.Errorf("failed to release stale log: %w", result.Error)
+	}
+	return result.RowsAffected == 1, nil
+}
@@ -15,8 +15,19 @@ func (d *dao) CreateReconciliationProcessLogAsset(payload *model.ReconciliationP
 
 func (d *dao) GetReconciliationLogAssetsByLogID(logID uint) ([]model.ReconciliationProcessLogAsset, error) {
 	var assets []model.ReconciliationProcessLogAsset
-	if err := d.db.Where("reconciliation_process_log_id = ?", logID).Find(&assets).Error; err != nil {
+	if err := d.db.Where("reconciliation_process_log_id = ?", logID).Order("id ASC").Find(&assets).Error; err != nil {
 		return nil, fmt.Errorf("failed to fetch log assets: %w", err)
 	}
 	return assets, nil
 }
+
+// UpdateReconciliationProcessLogAssetStageTime marks a file as staged, so a job that stops
+// part way through staging does not stage it again.
+func (d *dao) UpdateReconciliationProcessLogAssetStageTime(assetID int64, stageTime int64) error {
+	if err := d.db.Model(&model.ReconciliationProcessLogAsset{}).
+		Where("id = ?", assetID).
+		Update("stage_time", stageTime).Error; err != nil {
+		return fmt.Errorf("failed to update asset stage time: %w", err)
+	}
+	return nil
+}
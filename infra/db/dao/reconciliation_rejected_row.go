@@ -0,0 +1,43 @@
+package dao
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/radhian/reconciliation-system/infra/db/model"
+)
+
+// CreateReconciliationRejectedRows saves rejected rows with multi-row inserts.
+func (d *dao) CreateReconciliationRejectedRows(rows []model.ReconciliationRejectedRow) error {
+	table := d.db.NewScope(&model.ReconciliationRejectedRow{}).TableName()
+	for start := 0; start < len(rows); start += multiRowInsertChunk {
+		end := minInt(start+multiRowInsertChunk, len(rows))
+
+		placeholders := make([]string, 0, end-start)
+		args := make([]interface{}, 0, (end-start)*7)
+		for _, r := range rows[start:end] {
+			placeholders = append(placeholders, "(?,?,?,?,?,?,?)")
+			args = append(args, r.ReconciliationProcessLogID, r.DataType, r.SourceFile, r.LineNumber,
+				r.RawContent, r.Reason, r.CreateTime)
+		}
+
+		query := fmt.Sprintf("INSERT INTO %s (reconciliation_process_log_id, data_type, source_file, line_number, "+
+			"raw_content, reason, create_time) VALUES %s", table, strings.Join(placeholders, ","))
+		if err := d.db.Exec(query, args...).Error; err != nil {
+			return fmt.Errorf("failed to save rejected rows: %v", err)
+		}
+	}
+	return nil
+}
+
+// GetReconciliationRejectedRowsByLogID returns up to limit rejected rows of a log with an
+// ID above afterID, so callers can walk every row without holding them all.
+func (d *dao) GetReconciliationRejectedRowsByLogID(logID uint, afterID int64, limit int) ([]model.ReconciliationRejectedRow, error) {
+	var rows []model.ReconciliationRejectedRow
+	err := d.db.Where("reconciliation_process_log_id = ? AND id > ?", logID, afterID).
+		Order("id ASC").Limit(limit).Find(&rows).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch rejected rows: %w", err)
+	}
+	return rows, nil
+}
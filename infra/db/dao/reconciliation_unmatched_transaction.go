@@ -0,0 +1,50 @@
+package dao
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/radhian/reconciliation-system/infra/db/model"
+)
+
+// CreateReconciliationUnmatchedTransactions saves unmatched transactions with multi-row inserts.
+func (d *dao) CreateReconciliationUnmatchedTransactions(rows []model.ReconciliationUnmatchedTransaction) error {
+	table := d.db.NewScope(&model.ReconciliationUnmatchedTransaction{}).TableName()
+	for start := 0; start < len(rows); start += multiRowInsertChunk {
+		end := minInt(start+multiRowInsertChunk, len(rows))
+
+		placeholders := make([]string, 0, end-start)
+		args := make([]interface{}, 0, (end-start)*7)
+		for _, r := range rows[start:end] {
+			placeholders = append(placeholders, "(?,?,?,?,?,?,?)")
+			args = append(args, r.ReconciliationProcessLogID, r.TrxID, r.Amount, r.Type,
+				r.TransactionTime, r.Currency, r.CreateTime)
+		}
+
+		query := fmt.Sprintf("INSERT INTO %s (reconciliation_process_log_id, trx_id, amount, type, "+
+			"transaction_time, currency, create_time) VALUES %s", table, strings.Join(placeholders, ","))
+		if err := d.db.Exec(query, args...).Error; err != nil {
+			return fmt.Errorf("failed to save unmatched transactions: %v", err)
+		}
+	}
+	return nil
+}
+
+// GetReconciliationUnmatchedTransactionsByLogID returns up to limit unmatched transactions
+// of a log with an ID above afterID, in the order they were saved.
+func (d *dao) GetReconciliationUnmatchedTransactionsByLogID(logID uint, afterID int64, limit int) ([]model.ReconciliationUnmatchedTransaction, error) {
+	var rows []model.ReconciliationUnmatchedTransaction
+	err := d.db.Where("reconciliation_process_log_id = ? AND id > ?", logID, afterID).
+		Order("id ASC").Limit(limit).Find(&rows).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch unmatched transactions: %w", err)
+	}
+	return rows, nil
+}
+
+func (d *dao) DeleteReconciliationUnmatchedTransactionsByLogID(logID uint) error {
+	if err := d.db.Where("reconciliation_process_log_id = ?", logID).Delete(&model.ReconciliationUnmatchedTransaction{}).Error; err != nil {
+		return fmt.Errorf("failed to delete unmatched transactions: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,9 @@
+package model
+
+type ReconciliationBankConsumption struct {
+	ID                         int64  `gorm:"primaryKey;autoIncrement" json:"id"`
+	ReconciliationProcessLogID int64  `gorm:"not null;unique_index:idx_bank_consumption_row" json:"reconciliation_process_log_id"`
+	SourceFile                 string `gorm:"size:100;not null;unique_index:idx_bank_consumption_row" json:"source_file"`
+	RowNumber                  int64  `gorm:"not null;unique_index:idx_bank_consumption_row" json:"row_number"`
+	CreateTime                 int64  `gorm:"not null" json:"create_time"`
+}
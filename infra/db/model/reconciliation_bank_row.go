@@ -0,0 +1,29 @@
+package model
+
+import (
+	"time"
+
+	"github.com/radhian/reconciliation-system/entity"
+)
+
+// ReconciliationBankRow is an in-window bank statement row staged once per job, so each
+// batch can fetch only the rows its match rule may pair with instead of re-reading files.
+type ReconciliationBankRow struct {
+	ID                         int64         `gorm:"primaryKey;autoIncrement" json:"id"`
+	ReconciliationProcessLogID int64         `gorm:"not null;index:idx_bank_row_match_key,idx_bank_row_group_key,idx_bank_row_reference" json:"reconciliation_process_log_id"`
+	AssetID                    int64         `gorm:"not null" json:"asset_id"`
+	SourceFile                 string        `gorm:"size:100;not null" json:"source_file"`
+	RowNumber                  int64         `gorm:"not null" json:"row_number"`
+	UniqueIdentifier           string        `gorm:"size:255;not null" json:"unique_identifier"`
+	Description                string        `gorm:"type:text;not null" json:"description"`
+	Amount                     entity.Amount `gorm:"type:numeric(20,4);not null" json:"amount"`
+	Currency                   string        `gorm:"size:3;not null" json:"currency"`
+	BankDate                   time.Time     `gorm:"type:date;not null" json:"bank_date"`
+	ValueDate                  *time.Time    `gorm:"type:date" json:"value_date"`
+	Direction                  string        `gorm:"size:10;not null;default:''" json:"direction"`                    // consts.Direction*, empty means by sign
+	MatchKey                   string        `gorm:"size:100;not null;index:idx_bank_row_match_key" json:"match_key"` // type|currency|amount
+	GroupKey                   string        `gorm:"size:50;not null;index:idx_bank_row_group_key" json:"group_key"`  // type|currency
+	AbsAmount                  entity.Amount `gorm:"type:numeric(20,4);not null;index:idx_bank_row_group_key" json:"abs_amount"`
+	Reference                  string        `gorm:"size:255;not null;index:idx_bank_row_reference" json:"reference"` // extracted for the reference_id rule
+	CreateTime                 int64         `gorm:"not null" json:"create_time"`
+}
@@ -0,0 +1,12 @@
+package model
+
+type ReconciliationCsvProfile struct {
+	ID         int64  `gorm:"primaryKey;autoIncrement" json:"id"`
+	Name       string `gorm:"size:100;not null;unique_index" json:"name"`
+	DataType   int64  `gorm:"not null" json:"data_type"`
+	Config     string `gorm:"type:text;not null" json:"config"` // JSON-encoded entity.CsvProfile
+	CreateTime int64  `gorm:"not null" json:"create_time"`
+	CreateBy   string `gorm:"size:100;not null" json:"create_by"`
+	UpdateTime int64  `gorm:"not null" json:"update_time"`
+	UpdateBy   string `gorm:"size:100;not null" json:"update_by"`
+}
@@ -0,0 +1,11 @@
+package model
+
+// ReconciliationJobLease is a worker's claim on a job. A job without a row, or whose row
+// has expired, is free to be claimed.
+type ReconciliationJobLease struct {
+	ID                         int64  `gorm:"primaryKey;autoIncrement" json:"id"`
+	ReconciliationProcessLogID int64  `gorm:"not null;unique_index" json:"reconciliation_process_log_id"`
+	Owner                      string `gorm:"size:100;not null" json:"owner"`
+	ExpireTime                 int64  `gorm:"not null" json:"expire_time"`    // the claim lapses at this time unless renewed
+	HeartbeatTime              int64  `gorm:"not null" json:"heartbeat_time"` // when the claim was taken or last renewed
+}
@@ -0,0 +1,17 @@
+package model
+
+import "github.com/radhian/reconciliation-system/entity"
+
+type ReconciliationMatch struct {
+	ID                         int64         `gorm:"primaryKey;autoIncrement" json:"id"`
+	ReconciliationProcessLogID int64         `gorm:"not null;index" json:"reconciliation_process_log_id"`
+	SystemTrxID                string        `gorm:"size:100;not null;index" json:"system_trx_id"`
+	BankIdentifier             string        `gorm:"size:255;not null" json:"bank_identifier"` // as long as ReconciliationBankRow.UniqueIdentifier
+	SourceFile                 string        `gorm:"size:100;not null" json:"source_file"`
+	BankRowNumber              int64         `gorm:"not null" json:"bank_row_number"`
+	MatchRule                  string        `gorm:"size:50;not null" json:"match_rule"`
+	AmountDifference           entity.Amount `gorm:"type:numeric(20,4);not null" json:"amount_difference"` // bank amount minus system amount
+	DateDifference             int64         `gorm:"not null" json:"date_difference"`                      // bank date minus system date, in days
+	GroupNumber                int64         `gorm:"not null;default:0" json:"group_number"`               // 0 for 1:1 matches
+	CreateTime                 int64         `gorm:"not null" json:"create_time"`
+}
@@ -5,9 +5,20 @@ type ReconciliationProcessLog struct {
 	ReconciliationType int64  `gorm:"not null" json:"reconciliation_type"`
 	TotalMainRow       int64  `gorm:"not null" json:"total_main_row"`
 	CurrentMainRow     int64  `gorm:"not null" json:"current_main_row"`
+	CurrentMainOffset  int64  `gorm:"not null;default:0" json:"current_main_offset"` // byte offset of the next system row
+	CurrentMainLine    int64  `gorm:"not null;default:0" json:"current_main_line"`   // line number at CurrentMainOffset
+	StageTime          int64  `gorm:"not null;default:0" json:"stage_time"`          // when bank rows were staged, 0 before
 	ProcessInfo        string `gorm:"type:text;not null" json:"process_info"`
 	Status             int    `gorm:"not null" json:"status"`
+	Priority           int    `gorm:"not null;default:0" json:"priority"` // higher runs first
 	Result             string `gorm:"type:text;not null" json:"result"`
+	ErrorCode          string `gorm:"size:50;not null;default:''" json:"error_code"`      // why the job failed, empty otherwise
+	ErrorMessage       string `gorm:"type:text;not null;default:''" json:"error_message"` // the error behind ErrorCode
+	FailedAttempts     int    `gorm:"not null;default:0" json:"failed_attempts"`          // retryable failures since the last good batch
+	WorkerID           string `gorm:"size:100;not null;default:''" json:"worker_id"`      // worker running the job, empty when none is
+	HeartbeatTime      int64  `gorm:"not null;default:0" json:"heartbeat_time"`           // last sign of life from WorkerID
+	RecoveryCount      int    `gorm:"not null;default:0" json:"recovery_count"`           // times the job was taken back from a dead worker
+	ClaimTime          int64  `gorm:"not null;default:0;index" json:"claim_time"`         // when a worker last took the job, 0 before
 	CreateTime         int64  `gorm:"not null" json:"create_time"`
 	CreateBy           string `gorm:"size:100;not null" json:"create_by"`
 	UpdateTime         int64  `gorm:"not null" json:"update_time"`
@@ -6,6 +6,9 @@ type ReconciliationProcessLogAsset struct {
 	DataType                   int64  `gorm:"not null" json:"data_type"`
 	FileName                   string `gorm:"size:100;not null" json:"file_name"`
 	FileUrl                    string `gorm:"size:100;not null" json:"file_url"`
+	ParseProfile               string `gorm:"type:text;not null;default:''" json:"parse_profile"` // JSON-encoded entity.CsvProfile, empty for the built-in layout
+	FileFormat                 string `gorm:"size:10;not null;default:''" json:"file_format"`     // consts.FileFormat*, empty means CSV
+	StageTime                  int64  `gorm:"not null;default:0" json:"stage_time"`               // when the file was staged, 0 before
 	CreateTime                 int64  `gorm:"not null" json:"create_time"`
 	CreateBy                   string `gorm:"size:100;not null" json:"create_by"`
 }
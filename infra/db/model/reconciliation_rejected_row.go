@@ -0,0 +1,14 @@
+package model
+
+// ReconciliationRejectedRow is an input row the parsers could not use, kept so operators
+// can correct the file and resubmit. LineNumber is 0 when the whole file was rejected.
+type ReconciliationRejectedRow struct {
+	ID                         int64  `gorm:"primaryKey;autoIncrement" json:"id"`
+	ReconciliationProcessLogID int64  `gorm:"not null;index" json:"reconciliation_process_log_id"`
+	DataType                   int64  `gorm:"not null" json:"data_type"`
+	SourceFile                 string `gorm:"size:100;not null" json:"source_file"`
+	LineNumber                 int64  `gorm:"not null" json:"line_number"`
+	RawContent                 string `gorm:"type:text;not null" json:"raw_content"`
+	Reason                     string `gorm:"type:text;not null" json:"reason"`
+	CreateTime                 int64  `gorm:"not null" json:"create_time"`
+}
@@ -0,0 +1,17 @@
+package model
+
+import "github.com/radhian/reconciliation-system/entity"
+
+// ReconciliationUnmatchedTransaction is a system transaction the first match rule left
+// unmatched in its batch. The rows of a job are read once by its last batch, which runs the
+// rest of the rule chain over them, and are deleted when the job finishes.
+type ReconciliationUnmatchedTransaction struct {
+	ID                         int64         `gorm:"primaryKey;autoIncrement" json:"id"`
+	ReconciliationProcessLogID int64         `gorm:"not null;index" json:"reconciliation_process_log_id"`
+	TrxID                      string        `gorm:"size:100;not null" json:"trx_id"`
+	Amount                     entity.Amount `gorm:"type:numeric(20,4);not null" json:"amount"`
+	Type                       string        `gorm:"size:10;not null" json:"type"`
+	TransactionTime            int64         `gorm:"not null" json:"transaction_time"`
+	Currency                   string        `gorm:"size:3;not null;default:''" json:"currency"`
+	CreateTime                 int64         `gorm:"not null" json:"create_time"`
+}
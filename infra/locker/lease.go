@@ -0,0 +1,55 @@
+package locker
+
+import (
+	"context"
+	"time"
+
+	"github.com/radhian/reconciliation-system/infra/db/dao"
+)
+
+// LeaseLocker claims jobs with leases kept in the database, so workers on any number of
+// hosts can share the queue. A lease is renewed in the background while it is held; the
+// lease of a worker that died runs out and the job can then be claimed by another.
+type LeaseLocker struct {
+	dao      dao.DaoMethod
+	owner    string
+	lease    time.Duration
+	renewals *renewals
+}
+
+// NewLeaseLocker returns a locker that claims jobs as owner, which must differ between
+// processes. Leases last for lease and are renewed every third of it.
+func NewLeaseLocker(dao dao.DaoMethod, owner string, lease time.Duration) *LeaseLocker {
+	return &LeaseLocker{
+		dao:      dao,
+		owner:    owner,
+		lease:    lease,
+		renewals: newRenewals(lease),
+	}
+}
+
+// TryLock takes the job's lease when the job has none or its lease has run out.
+func (l *LeaseLocker) TryLock(ctx context.Context, logID int64) (bool, error) {
+	claimed, err := l.dao.ClaimReconciliationJobLease(logID, l.owner, l.leaseInSec())
+	if err != nil || !claimed {
+		return false, err
+	}
+
+	l.renewals.start(logID, func() (bool, error) {
+		return l.dao.RenewReconciliationJobLease(logID, l.owner, l.leaseInSec())
+	})
+	return true, nil
+}
+
+func (l *LeaseLocker) Unlock(ctx context.Context, logID int64) error {
+	l.renewals.stop(logID)
+	return l.dao.ReleaseReconciliationJobLease(logID, l.owner)
+}
+
+func (l *LeaseLocker) Lost(logID int64) <-chan struct{} {
+	return l.renewals.lost(logID)
+}
+
+func (l *LeaseLocker) leaseInSec() int64 {
+	return int64(l.lease / time.Second)
+}
@@ -1,35 +1,56 @@
-// locker/locker.go
+// Package locker claims reconciliation jobs so that each job is worked on by one worker
+// at a time.
 package locker
 
-import "sync"
+import (
+	"context"
+	"sync"
+)
 
-type Locker struct {
+// Locker claims a job for the calling worker until the worker unlocks it.
+type Locker interface {
+	// TryLock claims the job and reports false when another worker already holds it.
+	TryLock(ctx context.Context, logID int64) (bool, error)
+	// Unlock gives up a claim taken by TryLock.
+	Unlock(ctx context.Context, logID int64) error
+	// Lost returns a channel that is closed when a claim taken by TryLock is found to have
+	// run out before Unlock. It is nil for a job the locker holds no claim on or when its
+	// claims cannot run out.
+	Lost(logID int64) <-chan struct{}
+}
+
+// MemoryLocker keeps claims in a process-local map, so it only keeps apart the workers of
+// a single process.
+type MemoryLocker struct {
 	mu           sync.Mutex
 	inProcessMap map[int64]bool
 }
 
-func New() *Locker {
-	return &Locker{
+func NewMemoryLocker() *MemoryLocker {
+	return &MemoryLocker{
 		inProcessMap: make(map[int64]bool),
 	}
 }
 
-// MarkAsProcessing adds a log ID to the in-memory map.
-func (l *Locker) MarkAsProcessing(logID int64) {
+// TryLock adds a log ID to the in-memory map unless it is already there.
+func (l *MemoryLocker) TryLock(ctx context.Context, logID int64) (bool, error) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
+	if l.inProcessMap[logID] {
+		return false, nil
+	}
 	l.inProcessMap[logID] = true
+	return true, nil
 }
 
-// IsProcessing checks if a log ID is already being processed.
-func (l *Locker) IsProcessing(logID int64) bool {
+func (l *MemoryLocker) Unlock(ctx context.Context, logID int64) error {
 	l.mu.Lock()
 	defer l.mu.Unlock()
-	return l.inProcessMap[logID]
+	delete(l.inProcessMap, logID)
+	return nil
 }
 
-func (l *Locker) Unlock(logID int64) {
-	l.mu.Lock()
-	defer l.mu.Unlock()
-	delete(l.inProcessMap, logID)
+// Lost returns nil: a claim in the map lasts until Unlock.
+func (l *MemoryLocker) Lost(logID int64) <-chan struct{} {
+	return nil
 }
@@ -0,0 +1,116 @@
+package locker
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/radhian/reconciliation-system/infra/redis"
+)
+
+// Both scripts only touch the key while it still holds the claim's token, so a worker
+// whose lease ran out cannot release or extend the claim another worker has taken since.
+const (
+	redisReleaseScript = `if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("DEL", KEYS[1]) end return 0`
+	redisRenewScript   = `if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("PEXPIRE", KEYS[1], ARGV[2]) end return 0`
+)
+
+// RedisLocker claims jobs with expiring keys in Redis, one key per job holding the owner
+// and a token unique to the claim. The lock state can be read from any Redis client: GET
+// shows who holds a job and PTTL how long the claim has left.
+type RedisLocker struct {
+	client   *redis.Client
+	owner    string
+	lease    time.Duration
+	renewals *renewals
+
+	mu     sync.Mutex
+	tokens map[int64]string
+}
+
+// NewRedisLocker returns a locker that claims jobs as owner with keys that expire after
+// lease unless renewed; held claims are renewed every third of it.
+func NewRedisLocker(client *redis.Client, owner string, lease time.Duration) *RedisLocker {
+	return &RedisLocker{
+		client:   client,
+		owner:    owner,
+		lease:    lease,
+		renewals: newRenewals(lease),
+		tokens:   make(map[int64]string),
+	}
+}
+
+// RedisLockKey is the key that holds the claim on a job.
+func RedisLockKey(logID int64) string {
+	return fmt.Sprintf("reconciliation:lock:%d", logID)
+}
+
+// TryLock sets the job's key with SET NX PX, which fails while another claim holds it.
+func (l *RedisLocker) TryLock(ctx context.Context, logID int64) (bool, error) {
+	token, err := l.newToken()
+	if err != nil {
+		return false, err
+	}
+	_, err = l.client.Do("SET", RedisLockKey(logID), token, "NX", "PX", l.leaseInMs())
+	if err == redis.ErrNil {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to claim job %d: %w", logID, err)
+	}
+
+	l.mu.Lock()
+	l.tokens[logID] = token
+	l.mu.Unlock()
+	l.renewals.start(logID, func() (bool, error) {
+		return l.renew(logID, token)
+	})
+	return true, nil
+}
+
+// renew extends the job's key by a lease if it still holds the claim with token.
+func (l *RedisLocker) renew(logID int64, token string) (bool, error) {
+	reply, err := l.client.Do("EVAL", redisRenewScript, "1", RedisLockKey(logID), token, l.leaseInMs())
+	if err != nil {
+		return false, err
+	}
+	return reply == int64(1), nil
+}
+
+// Unlock deletes the job's key if it still holds this claim.
+func (l *RedisLocker) Unlock(ctx context.Context, logID int64) error {
+	l.renewals.stop(logID)
+
+	l.mu.Lock()
+	token, ok := l.tokens[logID]
+	delete(l.tokens, logID)
+	l.mu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	if _, err := l.client.Do("EVAL", redisReleaseScript, "1", RedisLockKey(logID), token); err != nil {
+		return fmt.Errorf("failed to release job %d: %w", logID, err)
+	}
+	return nil
+}
+
+func (l *RedisLocker) Lost(logID int64) <-chan struct{} {
+	return l.renewals.lost(logID)
+}
+
+func (l *RedisLocker) newToken() (string, error) {
+	random := make([]byte, 8)
+	if _, err := rand.Read(random); err != nil {
+		return "", fmt.Errorf("failed to generate lock token: %w", err)
+	}
+	return l.owner + ":" + hex.EncodeToString(random), nil
+}
+
+func (l *RedisLocker) leaseInMs() string {
+	return strconv.FormatInt(int64(l.lease/time.Millisecond), 10)
+}
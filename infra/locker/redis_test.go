@@ -0,0 +1,130 @@
+package locker
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/radhian/reconciliation-system/infra/redis"
+	"github.com/radhian/reconciliation-system/infra/redis/redistest"
+)
+
+const testLease = time.Minute
+
+func newRedisLockers(t *testing.T) (*redistest.Server, *RedisLocker, *RedisLocker) {
+	t.Helper()
+	server, err := redistest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Close)
+
+	newLocker := func(owner string) *RedisLocker {
+		client := redis.NewClient(server.Addr(), "", 0, time.Second)
+		t.Cleanup(func() { client.Close() })
+		return NewRedisLocker(client, owner, testLease)
+	}
+	return server, newLocker("worker-a"), newLocker("worker-b")
+}
+
+func mustLock(t *testing.T, l *RedisLocker, logID int64, want bool) {
+	t.Helper()
+	locked, err := l.TryLock(context.Background(), logID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if locked != want {
+		t.Fatalf("%s TryLock(%d) = %v, want %v", l.owner, logID, locked, want)
+	}
+}
+
+func TestRedisLockerAcquireConflict(t *testing.T) {
+	server, a, b := newRedisLockers(t)
+	ctx := context.Background()
+
+	mustLock(t, a, 1, true)
+	mustLock(t, b, 1, false)
+	mustLock(t, b, 2, true)
+
+	value, ok := server.Get(RedisLockKey(1))
+	if !ok || !strings.HasPrefix(value, "worker-a:") {
+		t.Fatalf("key holds %q, want a claim by worker-a", value)
+	}
+	if ttl := server.TTL(RedisLockKey(1)); ttl <= 0 || ttl > testLease {
+		t.Fatalf("TTL = %v, want at most %v", ttl, testLease)
+	}
+
+	if err := a.Unlock(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	mustLock(t, b, 1, true)
+}
+
+func TestRedisLockerReleaseByStaleTokenIsRefused(t *testing.T) {
+	server, a, b := newRedisLockers(t)
+	ctx := context.Background()
+
+	mustLock(t, a, 1, true)
+	server.FastForward(testLease)
+	mustLock(t, b, 1, true)
+	claim, _ := server.Get(RedisLockKey(1))
+
+	// worker-a still holds its own token, which no longer matches the key.
+	if err := a.Unlock(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if value, ok := server.Get(RedisLockKey(1)); !ok || value != claim {
+		t.Fatalf("key holds %q, %v after a stale release; want worker-b's claim %q", value, ok, claim)
+	}
+	mustLock(t, a, 1, false)
+}
+
+func TestRedisLockerRenewByStaleTokenIsRefused(t *testing.T) {
+	server, a, b := newRedisLockers(t)
+
+	mustLock(t, a, 1, true)
+	a.mu.Lock()
+	staleToken := a.tokens[1]
+	a.mu.Unlock()
+	server.FastForward(testLease)
+	mustLock(t, b, 1, true)
+	server.FastForward(testLease / 2)
+
+	held, err := a.renew(1, staleToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if held {
+		t.Fatal("renew with a stale token reported the claim held")
+	}
+	if ttl := server.TTL(RedisLockKey(1)); ttl > testLease/2 {
+		t.Fatalf("TTL = %v after a stale renew, want worker-b's remaining %v", ttl, testLease/2)
+	}
+}
+
+func TestRedisLockerRenewExtendsClaim(t *testing.T) {
+	server, a, b := newRedisLockers(t)
+
+	mustLock(t, a, 1, true)
+	a.mu.Lock()
+	token := a.tokens[1]
+	a.mu.Unlock()
+	server.FastForward(testLease * 2 / 3)
+
+	held, err := a.renew(1, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !held {
+		t.Fatal("renew with the current token reported the claim lost")
+	}
+	if ttl := server.TTL(RedisLockKey(1)); ttl <= testLease/2 {
+		t.Fatalf("TTL = %v after renew, want close to %v", ttl, testLease)
+	}
+
+	server.FastForward(testLease / 2)
+	mustLock(t, b, 1, false)
+	server.FastForward(testLease)
+	mustLock(t, b, 1, true)
+}
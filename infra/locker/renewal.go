@@ -0,0 +1,81 @@
+package locker
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// renewals keeps the claims a lease-based locker holds alive, renewing each one in the
+// background until it is stopped or found to be lost.
+type renewals struct {
+	lease  time.Duration
+	every  time.Duration
+	mu     sync.Mutex
+	claims map[int64]renewedClaim
+}
+
+type renewedClaim struct {
+	stop chan struct{}
+	lost chan struct{} // closed once the claim is found lost
+}
+
+// newRenewals renews claims lasting lease every third of it.
+func newRenewals(lease time.Duration) *renewals {
+	return &renewals{lease: lease, every: lease / 3, claims: make(map[int64]renewedClaim)}
+}
+
+// start calls renew every interval until stop is called for logID or the claim is lost:
+// renew reports it lost, or has failed for a whole lease so the claim has run out anyway.
+func (r *renewals) start(logID int64, renew func() (bool, error)) {
+	claim := renewedClaim{stop: make(chan struct{}), lost: make(chan struct{})}
+	r.mu.Lock()
+	r.claims[logID] = claim
+	r.mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(r.every)
+		defer ticker.Stop()
+		renewed := time.Now()
+		for {
+			select {
+			case <-claim.stop:
+				return
+			case <-ticker.C:
+				held, err := renew()
+				if err != nil {
+					log.Printf("[LEASE] renew failed log_id:%d: %v", logID, err)
+					if time.Since(renewed) < r.lease {
+						continue
+					}
+					held = false
+				}
+				if !held {
+					log.Printf("[LEASE] lost log_id:%d", logID)
+					close(claim.lost)
+					return
+				}
+				renewed = time.Now()
+			}
+		}
+	}()
+}
+
+func (r *renewals) stop(logID int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if claim, ok := r.claims[logID]; ok {
+		close(claim.stop)
+		delete(r.claims, logID)
+	}
+}
+
+// lost returns the channel closed when the claim on logID is lost, nil without a claim.
+func (r *renewals) lost(logID int64) <-chan struct{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if claim, ok := r.claims[logID]; ok {
+		return claim.lost
+	}
+	return nil
+}
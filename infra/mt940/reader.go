@@ -0,0 +1,385 @@
+// Package mt940 reads SWIFT MT940 customer statement files. Statement lines (:61:) are
+// returned one at a time together with their information to account owner (:86:); the
+// statement headers and balances are collected as the file is read.
+package mt940
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Record is one statement line.
+type Record struct {
+	Line            int // line number of the :61: field
+	Statement       int // 1-based position of the statement in the file
+	ValueDate       time.Time
+	EntryDate       time.Time // zero when the line has no entry date
+	Mark            string    // C, D, RC or RD
+	Amount          string    // decimal with a "." separator
+	Currency        string    // of the statement's opening balance
+	TransactionType string    // e.g. NTRF, NMSC, S103
+	CustomerRef     string
+	BankRef         string
+	Supplementary   string
+	Narrative       string // the :86: field, its lines joined without a separator
+	Raw             string // the :61: and :86: fields as they appear in the file
+}
+
+// Balance is an opening or closing balance field (:60a:, :62a:, :64:).
+type Balance struct {
+	Line     int
+	Mark     string // C or D
+	Date     time.Time
+	Currency string
+	Amount   string // decimal with a "." separator
+}
+
+// Statement is the header and balances of one statement within a file.
+type Statement struct {
+	Reference        string // :20:
+	Account          string // :25:
+	Number           string // :28C:
+	Opening          *Balance
+	Closing          *Balance
+	ClosingAvailable *Balance
+}
+
+// LineError reports a field that could not be read. Reading may continue after it.
+type LineError struct {
+	Line int
+	Raw  string
+	Err  error
+}
+
+func (e *LineError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *LineError) Unwrap() error {
+	return e.Err
+}
+
+var tagPattern = regexp.MustCompile(`^:([0-9]{2}[A-Z]?):(.*)$`)
+
+type field struct {
+	tag   string
+	value string
+	raw   string
+	line  int
+}
+
+// Reader returns the statement lines of an MT940 file in order.
+type Reader struct {
+	scanner    *bufio.Scanner
+	line       int
+	unread     *field
+	lookahead  *string
+	statements []Statement
+	pending    *Record
+}
+
+func NewReader(r io.Reader) *Reader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	return &Reader{scanner: scanner}
+}
+
+// Statements returns the statements read so far; after Read has returned io.EOF it holds
+// every statement of the file.
+func (r *Reader) Statements() []Statement {
+	return r.statements
+}
+
+// Read returns the next statement line, a *LineError for a field that could not be read,
+// or io.EOF after the last line.
+func (r *Reader) Read() (Record, error) {
+	for {
+		f, err := r.nextField()
+		if err == io.EOF && r.pending != nil {
+			return r.take(), nil
+		}
+		if err != nil {
+			return Record{}, err
+		}
+
+		if f.tag == "86" && r.pending != nil {
+			r.pending.Narrative = strings.Replace(f.value, "\n", "", -1)
+			r.pending.Raw += "\n" + f.raw
+			return r.take(), nil
+		}
+		if r.pending != nil {
+			r.unread = &f
+			return r.take(), nil
+		}
+		if err := r.apply(f); err != nil {
+			return Record{}, &LineError{Line: f.line, Raw: f.raw, Err: err}
+		}
+	}
+}
+
+func (r *Reader) take() Record {
+	record := *r.pending
+	r.pending = nil
+	return record
+}
+
+// apply takes a field other than the narrative of a pending statement line.
+func (r *Reader) apply(f field) error {
+	switch f.tag {
+	case "20":
+		r.statements = append(r.statements, Statement{Reference: strings.TrimSpace(f.value)})
+	case "25":
+		r.statement().Account = strings.TrimSpace(f.value)
+	case "28", "28C":
+		r.statement().Number = strings.TrimSpace(f.value)
+	case "60F", "60M", "62F", "62M", "64":
+		balance, err := parseBalance(f.value)
+		if err != nil {
+			return err
+		}
+		balance.Line = f.line
+		statement := r.statement()
+		switch f.tag[:2] {
+		case "60":
+			statement.Opening = &balance
+		case "62":
+			statement.Closing = &balance
+		default:
+			statement.ClosingAvailable = &balance
+		}
+	case "61":
+		record, err := parseStatementLine(f.value)
+		if err != nil {
+			return err
+		}
+		statement := r.statement()
+		record.Line = f.line
+		record.Statement = len(r.statements)
+		record.Raw = f.raw
+		if statement.Opening != nil {
+			record.Currency = statement.Opening.Currency
+		}
+		r.pending = &record
+	}
+	return nil
+}
+
+// statement returns the statement being read, starting one when a file omits :20:.
+func (r *Reader) statement() *Statement {
+	if len(r.statements) == 0 {
+		r.statements = append(r.statements, Statement{})
+	}
+	return &r.statements[len(r.statements)-1]
+}
+
+// nextField returns the next tagged field with its continuation lines.
+func (r *Reader) nextField() (field, error) {
+	if r.unread != nil {
+		f := *r.unread
+		r.unread = nil
+		return f, nil
+	}
+
+	var current *field
+	for {
+		text, line, err := r.readLine()
+		if err != nil {
+			if current != nil && err == io.EOF {
+				return *current, nil
+			}
+			return field{}, err
+		}
+
+		// A blank line or the end of a message block closes the field.
+		if text == "" || text == "-" || text == "-}" {
+			if current != nil {
+				return *current, nil
+			}
+			continue
+		}
+		if m := tagPattern.FindStringSubmatch(text); m != nil {
+			if current != nil {
+				r.lookahead = &text
+				r.line--
+				return *current, nil
+			}
+			current = &field{tag: m[1], value: m[2], raw: text, line: line}
+			continue
+		}
+		if current == nil {
+			return field{}, &LineError{Line: line, Raw: text, Err: errors.New("text outside a field")}
+		}
+		current.value += "\n" + text
+		current.raw += "\n" + text
+	}
+}
+
+// readLine returns the next line without its line ending, leaving out the SWIFT block
+// headers that wrap the text block of a message.
+func (r *Reader) readLine() (string, int, error) {
+	for {
+		var text string
+		if r.lookahead != nil {
+			text = *r.lookahead
+			r.lookahead = nil
+		} else {
+			if !r.scanner.Scan() {
+				if err := r.scanner.Err(); err != nil {
+					return "", 0, err
+				}
+				return "", 0, io.EOF
+			}
+			text = strings.TrimRight(r.scanner.Text(), "\r")
+		}
+		r.line++
+
+		if strings.HasPrefix(text, "{") {
+			start := strings.Index(text, "{4:")
+			if start < 0 {
+				continue
+			}
+			if text = text[start+3:]; text == "" {
+				continue
+			}
+		}
+		return text, r.line, nil
+	}
+}
+
+// parseStatementLine reads the subfields of a :61: field:
+// 6!n[4!n]2a[1!a]15d1!a3!c16x[//16x] followed by an optional line of 34x.
+func parseStatementLine(value string) (Record, error) {
+	var record Record
+	s := value
+	if i := strings.Index(s, "\n"); i >= 0 {
+		record.Supplementary = strings.TrimSpace(s[i+1:])
+		s = s[:i]
+	}
+
+	if len(s) < 6 {
+		return record, errors.New("statement line too short")
+	}
+	valueDate, err := time.Parse("060102", s[:6])
+	if err != nil {
+		return record, fmt.Errorf("invalid value date '%s'", s[:6])
+	}
+	record.ValueDate = valueDate
+	s = s[6:]
+
+	if len(s) >= 4 && isDigits(s[:4]) {
+		entryDate, err := entryDateNear(s[:4], valueDate)
+		if err != nil {
+			return record, err
+		}
+		record.EntryDate = entryDate
+		s = s[4:]
+	}
+
+	switch {
+	case strings.HasPrefix(s, "RC"), strings.HasPrefix(s, "RD"):
+		record.Mark = s[:2]
+	case strings.HasPrefix(s, "C"), strings.HasPrefix(s, "D"):
+		record.Mark = s[:1]
+	default:
+		return record, errors.New("missing debit/credit mark")
+	}
+	s = s[len(record.Mark):]
+
+	// The funds code is the third letter of the currency code and may be omitted.
+	if len(s) > 0 && isLetter(s[0]) {
+		s = s[1:]
+	}
+
+	end := 0
+	for end < len(s) && (isDigit(s[end]) || s[end] == ',') {
+		end++
+	}
+	amount, err := normalizeAmount(s[:end])
+	if err != nil {
+		return record, err
+	}
+	record.Amount = amount
+	s = s[end:]
+
+	if len(s) < 4 {
+		return record, errors.New("missing transaction type")
+	}
+	record.TransactionType = s[:4]
+	s = s[4:]
+
+	if i := strings.Index(s, "//"); i >= 0 {
+		record.BankRef = strings.TrimSpace(s[i+2:])
+		s = s[:i]
+	}
+	record.CustomerRef = strings.TrimSpace(s)
+	return record, nil
+}
+
+// parseBalance reads a balance field: 1!a6!n3!a15d.
+func parseBalance(value string) (Balance, error) {
+	var balance Balance
+	value = strings.TrimSpace(value)
+	if len(value) < 11 {
+		return balance, errors.New("balance too short")
+	}
+	balance.Mark = value[:1]
+	if balance.Mark != "C" && balance.Mark != "D" {
+		return balance, fmt.Errorf("invalid balance mark '%s'", balance.Mark)
+	}
+	date, err := time.Parse("060102", value[1:7])
+	if err != nil {
+		return balance, fmt.Errorf("invalid balance date '%s'", value[1:7])
+	}
+	balance.Date = date
+	balance.Currency = value[7:10]
+	if balance.Amount, err = normalizeAmount(value[10:]); err != nil {
+		return balance, err
+	}
+	return balance, nil
+}
+
+// normalizeAmount turns an MT amount such as "1234,5" or "100," into "1234.5" or "100".
+func normalizeAmount(raw string) (string, error) {
+	if raw == "" || strings.Count(raw, ",") != 1 || !isDigits(strings.Replace(raw, ",", "", 1)) {
+		return "", fmt.Errorf("invalid amount '%s'", raw)
+	}
+	return strings.TrimSuffix(strings.Replace(raw, ",", ".", 1), "."), nil
+}
+
+// entryDateNear places an MMDD entry date in the year that puts it closest to the value date.
+func entryDateNear(mmdd string, valueDate time.Time) (time.Time, error) {
+	date, err := time.Parse("0102", mmdd)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid entry date '%s'", mmdd)
+	}
+	year := valueDate.Year()
+	switch diff := int(date.Month()) - int(valueDate.Month()); {
+	case diff > 6:
+		year--
+	case diff < -6:
+		year++
+	}
+	return time.Date(year, date.Month(), date.Day(), 0, 0, 0, 0, time.UTC), nil
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if !isDigit(s[i]) {
+			return false
+		}
+	}
+	return s != ""
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isLetter(c byte) bool {
+	return c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z'
+}
@@ -0,0 +1,173 @@
+package mt940
+
+import (
+	"errors"
+	"io"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+// readFixture reads every statement line of the fixture, collecting the line errors
+// reading continues past.
+func readFixture(t *testing.T) ([]Record, []*LineError, []Statement) {
+	t.Helper()
+	file, err := os.Open("testdata/statement.sta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	reader := NewReader(file)
+	var records []Record
+	var lineErrors []*LineError
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		var lineErr *LineError
+		if errors.As(err, &lineErr) {
+			lineErrors = append(lineErrors, lineErr)
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Read: %v", err)
+		}
+		records = append(records, record)
+	}
+	return records, lineErrors, reader.Statements()
+}
+
+func TestReaderRecords(t *testing.T) {
+	records, _, _ := readFixture(t)
+
+	want := []Record{
+		// The entry date 0102 after a 31 December value date falls in the next year; the
+		// :86: lines are joined without a separator.
+		{Line: 6, Statement: 1, ValueDate: date(2023, 12, 31), EntryDate: date(2024, 1, 2), Mark: "C", Amount: "1600500",
+			Currency: "IDR", TransactionType: "NTRF", CustomerRef: "INV-1001", BankRef: "BANKREF1",
+			Narrative: "Payment for invoice 1001 from PT ABC",
+			Raw:       ":61:2312310102C1600500,NTRFINV-1001//BANKREF1\n:86:Payment for invoice 10\n01 from PT ABC"},
+		{Line: 9, Statement: 1, ValueDate: date(2024, 1, 2), Mark: "D", Amount: "5000.50",
+			Currency: "IDR", TransactionType: "NMSC", CustomerRef: "NONREF", BankRef: "BANKREF2", Supplementary: "SUPPLEMENTARY",
+			Raw: ":61:240102D5000,50NMSCNONREF//BANKREF2\nSUPPLEMENTARY"},
+		// The entry date 1231 before a 2 January value date falls in the previous year.
+		{Line: 11, Statement: 1, ValueDate: date(2024, 1, 2), EntryDate: date(2023, 12, 31), Mark: "RD", Amount: "12",
+			Currency: "IDR", TransactionType: "NCHK", CustomerRef: "REF3", Narrative: "reversal",
+			Raw: ":61:2401021231RD12,NCHKREF3\n:86:reversal"},
+		{Line: 13, Statement: 1, ValueDate: date(2024, 1, 3), Mark: "RC", Amount: "7",
+			Currency: "IDR", TransactionType: "S103", CustomerRef: "REF4",
+			Raw: ":61:240103RC7,S103REF4"},
+		// The funds code X after the mark is skipped.
+		{Line: 22, Statement: 2, ValueDate: date(2024, 1, 4), EntryDate: date(2024, 1, 4), Mark: "C", Amount: "3",
+			Currency: "USD", TransactionType: "NTRF",
+			Raw: ":61:2401040104CX3,NTRF"},
+	}
+
+	if len(records) != len(want) {
+		t.Fatalf("read %d records, want %d", len(records), len(want))
+	}
+	for i, record := range records {
+		if !reflect.DeepEqual(record, want[i]) {
+			t.Errorf("record %d = %+v, want %+v", i, record, want[i])
+		}
+	}
+}
+
+func TestReaderLineErrors(t *testing.T) {
+	_, lineErrors, _ := readFixture(t)
+
+	want := []LineError{
+		{Line: 23, Raw: ":61:240105C1,2,3NTRFBAD", Err: errors.New("invalid amount '1,2,3'")},
+		{Line: 24, Raw: ":62M:X240105USD0,", Err: errors.New("invalid balance mark 'X'")},
+	}
+	if len(lineErrors) != len(want) {
+		t.Fatalf("got %d line errors, want %d: %v", len(lineErrors), len(want), lineErrors)
+	}
+	for i, lineErr := range lineErrors {
+		if lineErr.Line != want[i].Line || lineErr.Raw != want[i].Raw || lineErr.Err.Error() != want[i].Err.Error() {
+			t.Errorf("line error %d = %d %q %v, want %d %q %v",
+				i, lineErr.Line, lineErr.Raw, lineErr.Err, want[i].Line, want[i].Raw, want[i].Err)
+		}
+	}
+}
+
+func TestReaderStatements(t *testing.T) {
+	_, _, statements := readFixture(t)
+
+	want := []Statement{
+		{
+			Reference:        "STMT-1",
+			Account:          "ID12BANK0001",
+			Number:           "101/1",
+			Opening:          &Balance{Line: 5, Mark: "C", Date: date(2023, 12, 29), Currency: "IDR", Amount: "1000"},
+			Closing:          &Balance{Line: 14, Mark: "D", Date: date(2024, 1, 3), Currency: "IDR", Amount: "250.5"},
+			ClosingAvailable: &Balance{Line: 15, Mark: "C", Date: date(2024, 1, 3), Currency: "IDR", Amount: "200"},
+		},
+		{
+			Reference: "STMT-2",
+			Account:   "0012345",
+			Number:    "8",
+			Opening:   &Balance{Line: 21, Mark: "D", Date: date(2024, 1, 4), Currency: "USD", Amount: "10"},
+		},
+	}
+
+	if len(statements) != len(want) {
+		t.Fatalf("read %d statements, want %d", len(statements), len(want))
+	}
+	for i, statement := range statements {
+		if !reflect.DeepEqual(statement, want[i]) {
+			t.Errorf("statement %d = %+v, want %+v", i, statement, want[i])
+		}
+	}
+}
+
+func TestParseStatementLine(t *testing.T) {
+	tests := []struct {
+		value     string
+		mark      string
+		amount    string
+		entryDate time.Time
+		wantErr   bool
+	}{
+		{value: "240315C100,NTRFREF", mark: "C", amount: "100"},
+		{value: "240315D0,5NTRFREF", mark: "D", amount: "0.5"},
+		{value: "240315RC1,25NTRFREF", mark: "RC", amount: "1.25"},
+		{value: "240315RD1,NTRFREF", mark: "RD", amount: "1"},
+		{value: "2403150316DR9,NTRFREF", mark: "D", amount: "9", entryDate: date(2024, 3, 16)},
+		// A value date in July puts an entry date in January into the next year only when
+		// it is more than six months ahead.
+		{value: "2407010105C1,NTRF", mark: "C", amount: "1", entryDate: date(2024, 1, 5)},
+		{value: "2412010105C1,NTRF", mark: "C", amount: "1", entryDate: date(2025, 1, 5)},
+		{value: "2401051201C1,NTRF", mark: "C", amount: "1", entryDate: date(2023, 12, 1)},
+		{value: "240315X1,NTRFREF", wantErr: true},
+		{value: "240315C1NTRFREF", wantErr: true},
+		{value: "240315C1,", wantErr: true},
+		{value: "241315C1,NTRF", wantErr: true},
+		{value: "2403151345C1,NTRF", wantErr: true},
+		{value: "2403", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseStatementLine(tt.value)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseStatementLine(%q) = %+v, want an error", tt.value, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseStatementLine(%q): %v", tt.value, err)
+			continue
+		}
+		if got.Mark != tt.mark || got.Amount != tt.amount || !got.EntryDate.Equal(tt.entryDate) {
+			t.Errorf("parseStatementLine(%q) = %s %s %v, want %s %s %v",
+				tt.value, got.Mark, got.Amount, got.EntryDate, tt.mark, tt.amount, tt.entryDate)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+// Package redis is a minimal client for the Redis serialization protocol (RESP). It runs
+// one command at a time over a single connection, which is all the job locker needs.
+package redis
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrNil is returned for a nil reply, such as SET NX on a key that already exists.
+var ErrNil = errors.New("redis: nil reply")
+
+// Error is an error reply from the server.
+type Error string
+
+func (e Error) Error() string {
+	return "redis: " + string(e)
+}
+
+type Client struct {
+	addr     string
+	password string
+	db       int
+	timeout  time.Duration
+
+	mu     sync.Mutex
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// NewClient returns a client for the server at addr. The connection is made by the first
+// command; password and db are sent with AUTH and SELECT when they are set.
+func NewClient(addr, password string, db int, timeout time.Duration) *Client {
+	return &Client{addr: addr, password: password, db: db, timeout: timeout}
+}
+
+// Do sends one command and returns its reply: a string for a simple or bulk string, an
+// int64 for an integer and a []interface{} for an array. A nil reply is ErrNil and an
+// error reply an Error, also when it is an element of an array. A connection that fails
+// or returns a reply that cannot be read is closed and dialled again by the next command.
+func (c *Client) Do(args ...string) (interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn == nil {
+		if err := c.connect(); err != nil {
+			return nil, err
+		}
+	}
+	reply, err := c.roundTrip(args)
+	if err != nil {
+		var replyErr Error
+		if err != ErrNil && !errors.As(err, &replyErr) {
+			c.closeConn()
+		}
+		return nil, err
+	}
+	return reply, nil
+}
+
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closeConn()
+}
+
+func (c *Client) connect() error {
+	conn, err := net.DialTimeout("tcp", c.addr, c.timeout)
+	if err != nil {
+		return fmt.Errorf("failed to connect to redis %s: %w", c.addr, err)
+	}
+	c.conn = conn
+	c.reader = bufio.NewReader(conn)
+
+	if c.password != "" {
+		if _, err := c.roundTrip([]string{"AUTH", c.password}); err != nil {
+			c.closeConn()
+			return fmt.Errorf("failed to authenticate to redis: %w", err)
+		}
+	}
+	if c.db != 0 {
+		if _, err := c.roundTrip([]string{"SELECT", strconv.Itoa(c.db)}); err != nil {
+			c.closeConn()
+			return fmt.Errorf("failed to select redis db %d: %w", c.db, err)
+		}
+	}
+	return nil
+}
+
+func (c *Client) closeConn() error {
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	c.reader = nil
+	return err
+}
+
+func (c *Client) roundTrip(args []string) (interface{}, error) {
+	if c.timeout > 0 {
+		if err := c.conn.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+			return nil, err
+		}
+	}
+
+	buf := []byte("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		buf = append(buf, "$"+strconv.Itoa(len(arg))+"\r\n"...)
+		buf = append(buf, arg...)
+		buf = append(buf, "\r\n"...)
+	}
+	if _, err := c.conn.Write(buf); err != nil {
+		return nil, err
+	}
+	return readReply(c.reader)
+}
+
+func readReply(r *bufio.Reader) (interface{}, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, Error(line[1:])
+	case ':':
+		n, err := strconv.ParseInt(line[1:], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("redis: invalid integer %q", line)
+		}
+		return n, nil
+	case '$':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("redis: invalid bulk length %q", line)
+		}
+		if size < 0 {
+			return nil, ErrNil
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		return string(data[:size]), nil
+	case '*':
+		count, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("redis: invalid array length %q", line)
+		}
+		if count < 0 {
+			return nil, ErrNil
+		}
+		// An error reply among the elements is returned only once the whole array is read,
+		// so the next command does not read the rest as its reply. Any other failure leaves
+		// the connection out of step and is returned at once for Do to close it.
+		items := make([]interface{}, count)
+		var elementErr error
+		for i := range items {
+			item, err := readReply(r)
+			var replyErr Error
+			switch {
+			case err == nil || err == ErrNil:
+				items[i] = item
+			case errors.As(err, &replyErr):
+				if elementErr == nil {
+					elementErr = err
+				}
+			default:
+				return nil, err
+			}
+		}
+		if elementErr != nil {
+			return nil, elementErr
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return "", fmt.Errorf("redis: malformed reply line %q", line)
+	}
+	return line[:len(line)-2], nil
+}
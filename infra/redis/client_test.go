@@ -0,0 +1,143 @@
+package redis_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/radhian/reconciliation-system/infra/redis"
+	"github.com/radhian/reconciliation-system/infra/redis/redistest"
+)
+
+func newServer(t *testing.T) *redistest.Server {
+	t.Helper()
+	server, err := redistest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newClient(t *testing.T, server *redistest.Server, password string, db int) *redis.Client {
+	t.Helper()
+	client := redis.NewClient(server.Addr(), password, db, time.Second)
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestDoReplyTypes(t *testing.T) {
+	server := newServer(t)
+	client := newClient(t, server, "", 0)
+
+	server.SetRawReply("STATUS", "+OK\r\n")
+	server.SetRawReply("INTEGER", ":-42\r\n")
+	server.SetRawReply("BULK", "$5\r\nhello\r\n")
+	server.SetRawReply("EMPTYBULK", "$0\r\n\r\n")
+	server.SetRawReply("NILBULK", "$-1\r\n")
+	server.SetRawReply("NILARRAY", "*-1\r\n")
+	server.SetRawReply("ERROR", "-ERR boom\r\n")
+	server.SetRawReply("ARRAY", "*4\r\n+a\r\n:1\r\n$-1\r\n*1\r\n$1\r\nb\r\n")
+
+	cases := []struct {
+		command string
+		want    interface{}
+		wantErr error
+	}{
+		{command: "STATUS", want: "OK"},
+		{command: "INTEGER", want: int64(-42)},
+		{command: "BULK", want: "hello"},
+		{command: "EMPTYBULK", want: ""},
+		{command: "NILBULK", wantErr: redis.ErrNil},
+		{command: "NILARRAY", wantErr: redis.ErrNil},
+		{command: "ERROR", wantErr: redis.Error("ERR boom")},
+		{command: "ARRAY", want: []interface{}{"a", int64(1), nil, []interface{}{"b"}}},
+	}
+	for _, c := range cases {
+		got, err := client.Do(c.command)
+		if err != c.wantErr {
+			t.Errorf("%s: err = %v, want %v", c.command, err, c.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: reply = %#v, want %#v", c.command, got, c.want)
+		}
+	}
+	if n := server.Accepted(); n != 1 {
+		t.Errorf("accepted %d connections, want 1", n)
+	}
+}
+
+func TestDoErrorInArrayKeepsConnection(t *testing.T) {
+	server := newServer(t)
+	client := newClient(t, server, "", 0)
+	server.SetRawReply("EXEC", "*3\r\n:1\r\n-ERR first\r\n-ERR second\r\n")
+
+	_, err := client.Do("EXEC")
+	if err != redis.Error("ERR first") {
+		t.Fatalf("err = %v, want the first error reply", err)
+	}
+	reply, err := client.Do("PING")
+	if err != nil || reply != "PONG" {
+		t.Fatalf("PING = %v, %v; want PONG", reply, err)
+	}
+	if n := server.Accepted(); n != 1 {
+		t.Errorf("accepted %d connections, want 1", n)
+	}
+}
+
+func TestDoUnreadableReplyClosesConnection(t *testing.T) {
+	server := newServer(t)
+	client := newClient(t, server, "", 0)
+	server.SetRawReply("BROKEN", ":abc\r\n+LEFTOVER\r\n")
+
+	if _, err := client.Do("BROKEN"); err == nil {
+		t.Fatal("want an error for an invalid integer")
+	}
+	reply, err := client.Do("PING")
+	if err != nil || reply != "PONG" {
+		t.Fatalf("PING = %v, %v; want PONG on a new connection", reply, err)
+	}
+	if n := server.Accepted(); n != 2 {
+		t.Errorf("accepted %d connections, want 2", n)
+	}
+}
+
+func TestDoReconnectsAfterDroppedConnection(t *testing.T) {
+	server := newServer(t)
+	client := newClient(t, server, "", 0)
+
+	if _, err := client.Do("SET", "k", "v"); err != nil {
+		t.Fatal(err)
+	}
+	server.CloseClients()
+
+	// The first command finds the connection gone; the next one dials again.
+	if _, err := client.Do("GET", "k"); err == nil {
+		t.Fatal("want an error on the dropped connection")
+	}
+	reply, err := client.Do("GET", "k")
+	if err != nil || reply != "v" {
+		t.Fatalf("GET = %v, %v; want v", reply, err)
+	}
+	if n := server.Accepted(); n != 2 {
+		t.Errorf("accepted %d connections, want 2", n)
+	}
+}
+
+func TestDoAuthenticatesAndSelects(t *testing.T) {
+	server := newServer(t)
+	server.RequirePassword("secret")
+
+	client := newClient(t, server, "secret", 2)
+	if reply, err := client.Do("PING"); err != nil || reply != "PONG" {
+		t.Fatalf("PING = %v, %v; want PONG", reply, err)
+	}
+
+	wrong := newClient(t, server, "wrong", 0)
+	var replyErr redis.Error
+	if _, err := wrong.Do("PING"); !errors.As(err, &replyErr) {
+		t.Fatalf("err = %v, want the AUTH error reply", err)
+	}
+}
@@ -0,0 +1,460 @@
+// Package redistest runs a stand-in Redis server inside a test process. It speaks RESP over
+// a real TCP listener and keeps keys in memory with millisecond expiry on a clock tests can
+// move forward. It knows PING, AUTH, SELECT, GET, SET with NX and PX, DEL, PTTL, PEXPIRE
+// and EVAL. EVAL only runs scripts that compare the result of one call with a value and
+// then make a second call, the form the job locker uses:
+//
+//	if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("DEL", KEYS[1]) end return 0
+package redistest
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Server is a stand-in Redis server listening on a local port.
+type Server struct {
+	listener net.Listener
+	wg       sync.WaitGroup
+
+	mu       sync.Mutex
+	keys     map[string]entry
+	offset   time.Duration // added to the wall clock by FastForward
+	password string
+	raw      map[string]string
+	conns    map[net.Conn]bool
+	accepted int
+}
+
+type entry struct {
+	value    string
+	expireAt time.Time // zero when the key does not expire
+}
+
+// status is a simple string reply, told apart from a bulk string.
+type status string
+
+// replyError is an error reply.
+type replyError string
+
+// NewServer starts a server on a free local port. Close stops it.
+func NewServer() (*Server, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen: %w", err)
+	}
+	s := &Server{
+		listener: listener,
+		keys:     make(map[string]entry),
+		raw:      make(map[string]string),
+		conns:    make(map[net.Conn]bool),
+	}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// Addr is the host:port the server listens on.
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Close stops the server and closes every client connection.
+func (s *Server) Close() {
+	s.listener.Close()
+	s.CloseClients()
+	s.wg.Wait()
+}
+
+// CloseClients drops every client connection, as a restarting server or a broken network
+// would. Clients have to dial again.
+func (s *Server) CloseClients() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
+
+// Accepted is the number of connections the server has accepted so far.
+func (s *Server) Accepted() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.accepted
+}
+
+// RequirePassword makes every command but AUTH fail until the connection has sent password.
+func (s *Server) RequirePassword(password string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.password = password
+}
+
+// SetRawReply makes the server answer command with reply as given, which must be RESP.
+// It lets tests send replies a real server would only send in odd cases.
+func (s *Server) SetRawReply(command, reply string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.raw[strings.ToUpper(command)] = reply
+}
+
+// FastForward moves the server clock forward, expiring the keys whose time has come.
+func (s *Server) FastForward(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.offset += d
+}
+
+// Get returns the value of a key and whether it exists.
+func (s *Server) Get(key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.lookup(key)
+	return e.value, ok
+}
+
+// TTL returns how long a key has left, or 0 when it does not exist or does not expire.
+func (s *Server) TTL(key string) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.lookup(key)
+	if !ok || e.expireAt.IsZero() {
+		return 0
+	}
+	return e.expireAt.Sub(s.now())
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns[conn] = true
+		s.accepted++
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go s.handle(conn)
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	reader := bufio.NewReader(conn)
+	authenticated := false
+	for {
+		args, err := readCommand(reader)
+		if err != nil {
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		s.mu.Lock()
+		var out string
+		if raw, ok := s.raw[strings.ToUpper(args[0])]; ok {
+			out = raw
+		} else {
+			out = encode(s.run(args, &authenticated))
+		}
+		s.mu.Unlock()
+
+		if _, err := io.WriteString(conn, out); err != nil {
+			return
+		}
+	}
+}
+
+func (s *Server) now() time.Time {
+	return time.Now().Add(s.offset)
+}
+
+// lookup returns a live key, dropping it once it has expired. s.mu must be held.
+func (s *Server) lookup(key string) (entry, bool) {
+	e, ok := s.keys[key]
+	if ok && !e.expireAt.IsZero() && !s.now().Before(e.expireAt) {
+		delete(s.keys, key)
+		return entry{}, false
+	}
+	return e, ok
+}
+
+// run executes one command. s.mu must be held.
+func (s *Server) run(args []string, authenticated *bool) interface{} {
+	command := strings.ToUpper(args[0])
+	if command == "AUTH" {
+		if len(args) != 2 || args[1] != s.password {
+			return replyError("WRONGPASS invalid password")
+		}
+		*authenticated = true
+		return status("OK")
+	}
+	if s.password != "" && !*authenticated {
+		return replyError("NOAUTH Authentication required.")
+	}
+	return s.call(command, args[1:])
+}
+
+func (s *Server) call(command string, args []string) interface{} {
+	switch command {
+	case "PING":
+		return status("PONG")
+	case "SELECT":
+		if len(args) != 1 {
+			return wrongArgs(command)
+		}
+		if _, err := strconv.Atoi(args[0]); err != nil {
+			return replyError("ERR invalid DB index")
+		}
+		return status("OK")
+	case "GET":
+		if len(args) != 1 {
+			return wrongArgs(command)
+		}
+		if e, ok := s.lookup(args[0]); ok {
+			return e.value
+		}
+		return nil
+	case "SET":
+		return s.set(args)
+	case "DEL":
+		if len(args) == 0 {
+			return wrongArgs(command)
+		}
+		var deleted int64
+		for _, key := range args {
+			if _, ok := s.lookup(key); ok {
+				delete(s.keys, key)
+				deleted++
+			}
+		}
+		return deleted
+	case "PTTL":
+		if len(args) != 1 {
+			return wrongArgs(command)
+		}
+		e, ok := s.lookup(args[0])
+		if !ok {
+			return int64(-2)
+		}
+		if e.expireAt.IsZero() {
+			return int64(-1)
+		}
+		return int64(e.expireAt.Sub(s.now()) / time.Millisecond)
+	case "PEXPIRE":
+		if len(args) != 2 {
+			return wrongArgs(command)
+		}
+		ms, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return replyError("ERR value is not an integer or out of range")
+		}
+		e, ok := s.lookup(args[0])
+		if !ok {
+			return int64(0)
+		}
+		e.expireAt = s.now().Add(time.Duration(ms) * time.Millisecond)
+		s.keys[args[0]] = e
+		return int64(1)
+	case "EVAL":
+		return s.eval(args)
+	}
+	return replyError(fmt.Sprintf("ERR unknown command '%s'", command))
+}
+
+func (s *Server) set(args []string) interface{} {
+	if len(args) < 2 {
+		return wrongArgs("SET")
+	}
+	key, value := args[0], args[1]
+	onlyNew := false
+	var expireAt time.Time
+	for i := 2; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "NX":
+			onlyNew = true
+		case "PX":
+			if i+1 == len(args) {
+				return replyError("ERR syntax error")
+			}
+			ms, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil || ms <= 0 {
+				return replyError("ERR invalid expire time in 'set' command")
+			}
+			expireAt = s.now().Add(time.Duration(ms) * time.Millisecond)
+			i++
+		default:
+			return replyError("ERR syntax error")
+		}
+	}
+	if _, exists := s.lookup(key); exists && onlyNew {
+		return nil
+	}
+	s.keys[key] = entry{value: value, expireAt: expireAt}
+	return status("OK")
+}
+
+var (
+	scriptPattern = regexp.MustCompile(`^if redis\.call\((.+?)\) == (\S+) then return redis\.call\((.+?)\) end return (-?\d+)$`)
+	keysOrArgv    = regexp.MustCompile(`^(KEYS|ARGV)\[(\d+)\]$`)
+)
+
+// eval runs a compare-then-call script with its KEYS and ARGV.
+func (s *Server) eval(args []string) interface{} {
+	if len(args) < 2 {
+		return wrongArgs("EVAL")
+	}
+	numKeys, err := strconv.Atoi(args[1])
+	if err != nil || numKeys < 0 || numKeys > len(args)-2 {
+		return replyError("ERR Number of keys can't be greater than number of args")
+	}
+	keys, argv := args[2:2+numKeys], args[2+numKeys:]
+
+	parts := scriptPattern.FindStringSubmatch(strings.TrimSpace(args[0]))
+	if parts == nil {
+		return replyError("ERR script not supported by the stand-in server")
+	}
+	condition, err := resolveCall(parts[1], keys, argv)
+	if err != nil {
+		return replyError("ERR " + err.Error())
+	}
+	expected, err := resolveValue(parts[2], keys, argv)
+	if err != nil {
+		return replyError("ERR " + err.Error())
+	}
+	// A nil reply is false in Lua and never equals a string.
+	if got, ok := s.call(strings.ToUpper(condition[0]), condition[1:]).(string); !ok || got != expected {
+		otherwise, _ := strconv.ParseInt(parts[4], 10, 64)
+		return otherwise
+	}
+
+	then, err := resolveCall(parts[3], keys, argv)
+	if err != nil {
+		return replyError("ERR " + err.Error())
+	}
+	return s.call(strings.ToUpper(then[0]), then[1:])
+}
+
+// resolveCall turns the arguments of a redis.call into a command line.
+func resolveCall(list string, keys, argv []string) ([]string, error) {
+	var call []string
+	for _, token := range strings.Split(list, ",") {
+		value, err := resolveValue(strings.TrimSpace(token), keys, argv)
+		if err != nil {
+			return nil, err
+		}
+		call = append(call, value)
+	}
+	return call, nil
+}
+
+// resolveValue reads a string literal, a number, or a KEYS or ARGV element of a script.
+func resolveValue(token string, keys, argv []string) (string, error) {
+	if len(token) >= 2 && token[0] == '"' && token[len(token)-1] == '"' {
+		return token[1 : len(token)-1], nil
+	}
+	if parts := keysOrArgv.FindStringSubmatch(token); parts != nil {
+		list := keys
+		if parts[1] == "ARGV" {
+			list = argv
+		}
+		i, _ := strconv.Atoi(parts[2])
+		if i < 1 || i > len(list) {
+			return "", fmt.Errorf("%s out of range", token)
+		}
+		return list[i-1], nil
+	}
+	if _, err := strconv.ParseInt(token, 10, 64); err == nil {
+		return token, nil
+	}
+	return "", fmt.Errorf("unsupported script value %s", token)
+}
+
+func wrongArgs(command string) replyError {
+	return replyError(fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(command)))
+}
+
+// readCommand reads one command sent as an array of bulk strings.
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return nil, errors.New("command is not an array")
+	}
+	count, err := strconv.Atoi(line[1:])
+	if err != nil || count < 0 {
+		return nil, fmt.Errorf("invalid array length %q", line)
+	}
+
+	args := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(line, "$") {
+			return nil, errors.New("command argument is not a bulk string")
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 {
+			return nil, fmt.Errorf("invalid bulk length %q", line)
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		args = append(args, string(data[:size]))
+	}
+	return args, nil
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(line, "\r\n"), nil
+}
+
+func encode(reply interface{}) string {
+	switch v := reply.(type) {
+	case nil:
+		return "$-1\r\n"
+	case status:
+		return "+" + string(v) + "\r\n"
+	case replyError:
+		return "-" + string(v) + "\r\n"
+	case int64:
+		return ":" + strconv.FormatInt(v, 10) + "\r\n"
+	case string:
+		return "$" + strconv.Itoa(len(v)) + "\r\n" + v + "\r\n"
+	case []interface{}:
+		out := "*" + strconv.Itoa(len(v)) + "\r\n"
+		for _, item := range v {
+			out += encode(item)
+		}
+		return out
+	}
+	return "-ERR unsupported reply\r\n"
+}
@@ -0,0 +1,448 @@
+// Package xlsx reads the cell values of Excel (.xlsx) worksheets row by row. Only what a
+// statement import needs is supported: shared and inline strings, numbers, booleans and
+// cells styled as dates.
+package xlsx
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrSheetNotFound = errors.New("sheet not found")
+
+// Workbook is an open .xlsx file. Shared strings and styles are loaded up front; sheet
+// rows are decoded as they are read.
+type Workbook struct {
+	zip           *zip.ReadCloser
+	sheets        []sheetRef
+	sharedStrings []string
+	dateStyles    map[int]bool // cell style indexes whose number format is a date or time
+	date1904      bool
+}
+
+type sheetRef struct {
+	name string
+	path string // zip entry of the worksheet
+}
+
+// Open reads the workbook structure of an .xlsx file.
+func Open(name string) (*Workbook, error) {
+	z, err := zip.OpenReader(name)
+	if err != nil {
+		return nil, err
+	}
+	w := &Workbook{zip: z}
+	if err := w.load(); err != nil {
+		z.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// Close releases the file.
+func (w *Workbook) Close() error {
+	return w.zip.Close()
+}
+
+// SheetNames lists the worksheets in workbook order.
+func (w *Workbook) SheetNames() []string {
+	names := make([]string, 0, len(w.sheets))
+	for _, s := range w.sheets {
+		names = append(names, s.name)
+	}
+	return names
+}
+
+// OpenSheet starts reading the named worksheet, or the first one when name is empty.
+// Cells styled as dates are formatted with dateLayout.
+func (w *Workbook) OpenSheet(name string, dateLayout string) (*SheetReader, error) {
+	for _, s := range w.sheets {
+		if name != "" && s.name != name {
+			continue
+		}
+		rc, err := w.openEntry(s.path)
+		if err != nil {
+			return nil, err
+		}
+		return &SheetReader{
+			workbook:   w,
+			rc:         rc,
+			decoder:    xml.NewDecoder(rc),
+			dateLayout: dateLayout,
+		}, nil
+	}
+	if name == "" {
+		return nil, fmt.Errorf("%w: workbook has no worksheets", ErrSheetNotFound)
+	}
+	return nil, fmt.Errorf("%w: %s", ErrSheetNotFound, name)
+}
+
+func (w *Workbook) load() error {
+	var workbook struct {
+		Properties struct {
+			Date1904 bool `xml:"date1904,attr"`
+		} `xml:"workbookPr"`
+		Sheets []struct {
+			Name string `xml:"name,attr"`
+			ID   string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+		} `xml:"sheets>sheet"`
+	}
+	if err := w.decodeEntry("xl/workbook.xml", &workbook); err != nil {
+		return err
+	}
+	w.date1904 = workbook.Properties.Date1904
+
+	var rels struct {
+		Relationships []struct {
+			ID     string `xml:"Id,attr"`
+			Target string `xml:"Target,attr"`
+		} `xml:"Relationship"`
+	}
+	if err := w.decodeEntry("xl/_rels/workbook.xml.rels", &rels); err != nil {
+		return err
+	}
+	targets := make(map[string]string, len(rels.Relationships))
+	for _, r := range rels.Relationships {
+		target := r.Target
+		if strings.HasPrefix(target, "/") {
+			target = strings.TrimPrefix(target, "/")
+		} else {
+			target = path.Join("xl", target)
+		}
+		targets[r.ID] = target
+	}
+	for _, s := range workbook.Sheets {
+		if target, ok := targets[s.ID]; ok {
+			w.sheets = append(w.sheets, sheetRef{name: s.Name, path: target})
+		}
+	}
+
+	if err := w.loadSharedStrings(); err != nil {
+		return err
+	}
+	return w.loadStyles()
+}
+
+func (w *Workbook) loadSharedStrings() error {
+	var sst struct {
+		Items []stringItem `xml:"si"`
+	}
+	err := w.decodeEntry("xl/sharedStrings.xml", &sst)
+	if errors.Is(err, errEntryNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	w.sharedStrings = make([]string, 0, len(sst.Items))
+	for _, item := range sst.Items {
+		w.sharedStrings = append(w.sharedStrings, item.text())
+	}
+	return nil
+}
+
+func (w *Workbook) loadStyles() error {
+	var styles struct {
+		NumFmts []struct {
+			ID   int    `xml:"numFmtId,attr"`
+			Code string `xml:"formatCode,attr"`
+		} `xml:"numFmts>numFmt"`
+		CellXfs []struct {
+			NumFmtID int `xml:"numFmtId,attr"`
+		} `xml:"cellXfs>xf"`
+	}
+	w.dateStyles = make(map[int]bool)
+	err := w.decodeEntry("xl/styles.xml", &styles)
+	if errors.Is(err, errEntryNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	customDate := make(map[int]bool, len(styles.NumFmts))
+	for _, f := range styles.NumFmts {
+		customDate[f.ID] = isDateFormatCode(f.Code)
+	}
+	for i, xf := range styles.CellXfs {
+		if isBuiltInDateFormat(xf.NumFmtID) || customDate[xf.NumFmtID] {
+			w.dateStyles[i] = true
+		}
+	}
+	return nil
+}
+
+var errEntryNotFound = errors.New("entry not found")
+
+func (w *Workbook) openEntry(name string) (io.ReadCloser, error) {
+	for _, f := range w.zip.File {
+		if f.Name == name {
+			return f.Open()
+		}
+	}
+	return nil, fmt.Errorf("%s: %w", name, errEntryNotFound)
+}
+
+func (w *Workbook) decodeEntry(name string, v interface{}) error {
+	rc, err := w.openEntry(name)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	if err := xml.NewDecoder(rc).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode %s: %w", name, err)
+	}
+	return nil
+}
+
+// dateValue converts an Excel serial date into a time.
+func (w *Workbook) dateValue(serial float64) time.Time {
+	epoch := time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+	if w.date1904 {
+		epoch = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	days := math.Floor(serial)
+	seconds := math.Round((serial - days) * 86400)
+	return epoch.AddDate(0, 0, int(days)).Add(time.Duration(seconds) * time.Second)
+}
+
+// stringItem is rich or plain text, as used by shared and inline strings.
+type stringItem struct {
+	Text string `xml:"t"`
+	Runs []struct {
+		Text string `xml:"t"`
+	} `xml:"r"`
+}
+
+func (s stringItem) text() string {
+	if len(s.Runs) == 0 {
+		return s.Text
+	}
+	var b strings.Builder
+	b.WriteString(s.Text)
+	for _, r := range s.Runs {
+		b.WriteString(r.Text)
+	}
+	return b.String()
+}
+
+// SheetReader returns the rows of one worksheet in order. Rows without any value are
+// skipped, like empty lines of a CSV file.
+type SheetReader struct {
+	workbook   *Workbook
+	rc         io.ReadCloser
+	decoder    *xml.Decoder
+	dateLayout string
+	line       int
+	record     []string
+}
+
+type cell struct {
+	Ref       string     `xml:"r,attr"`
+	Type      string     `xml:"t,attr"`
+	Style     int        `xml:"s,attr"`
+	Value     string     `xml:"v"`
+	InlineStr stringItem `xml:"is"`
+}
+
+// Line returns the worksheet row number of the last row read, 1-based.
+func (r *SheetReader) Line() int {
+	return r.line
+}
+
+// Raw returns the last row read as one CSV-encoded line.
+func (r *SheetReader) Raw() string {
+	var b strings.Builder
+	writer := csv.NewWriter(&b)
+	writer.Write(r.record)
+	writer.Flush()
+	return strings.TrimRight(b.String(), "\r\n")
+}
+
+// Read returns the cell values of the next row, or io.EOF after the last one. Missing
+// cells inside the row are returned as empty strings.
+func (r *SheetReader) Read() ([]string, error) {
+	for {
+		token, err := r.decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "row" {
+			continue
+		}
+
+		number := r.line + 1
+		for _, attr := range start.Attr {
+			if attr.Name.Local == "r" {
+				if n, err := strconv.Atoi(attr.Value); err == nil {
+					number = n
+				}
+			}
+		}
+		record, err := r.readRow()
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", number, err)
+		}
+		r.line = number
+		if isEmptyRow(record) {
+			continue
+		}
+		r.record = record
+		return record, nil
+	}
+}
+
+// Close releases the worksheet stream; the workbook stays open.
+func (r *SheetReader) Close() error {
+	return r.rc.Close()
+}
+
+func (r *SheetReader) readRow() ([]string, error) {
+	var record []string
+	for {
+		token, err := r.decoder.Token()
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			if t.Name.Local != "c" {
+				if err := r.decoder.Skip(); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			var c cell
+			if err := r.decoder.DecodeElement(&c, &t); err != nil {
+				return nil, err
+			}
+			column := len(record)
+			if c.Ref != "" {
+				if column, err = columnIndex(c.Ref); err != nil {
+					return nil, err
+				}
+			}
+			value, err := r.cellValue(c)
+			if err != nil {
+				return nil, fmt.Errorf("cell %s: %w", c.Ref, err)
+			}
+			for len(record) <= column {
+				record = append(record, "")
+			}
+			record[column] = value
+		case xml.EndElement:
+			if t.Name.Local == "row" {
+				return record, nil
+			}
+		}
+	}
+}
+
+func (r *SheetReader) cellValue(c cell) (string, error) {
+	switch c.Type {
+	case "s":
+		index, err := strconv.Atoi(strings.TrimSpace(c.Value))
+		if err != nil || index < 0 || index >= len(r.workbook.sharedStrings) {
+			return "", fmt.Errorf("invalid shared string index %q", c.Value)
+		}
+		return r.workbook.sharedStrings[index], nil
+	case "inlineStr":
+		return c.InlineStr.text(), nil
+	case "b":
+		if c.Value == "1" {
+			return "TRUE", nil
+		}
+		return "FALSE", nil
+	case "str", "e":
+		return c.Value, nil
+	}
+
+	if c.Value == "" {
+		return "", nil
+	}
+	// Numbers are stored as doubles. Like Excel, keep 15 significant digits, which drops
+	// binary noise such as 1234.5599999999999.
+	number, err := strconv.ParseFloat(c.Value, 64)
+	if err != nil {
+		return c.Value, nil
+	}
+	if r.workbook.dateStyles[c.Style] {
+		layout := r.dateLayout
+		if layout == "" {
+			layout = "2006-01-02"
+		}
+		return r.workbook.dateValue(number).Format(layout), nil
+	}
+	rounded, _ := strconv.ParseFloat(strconv.FormatFloat(number, 'g', 15, 64), 64)
+	return strconv.FormatFloat(rounded, 'f', -1, 64), nil
+}
+
+// columnIndex turns the letters of a cell reference such as "AB12" into a 0-based column.
+func columnIndex(ref string) (int, error) {
+	column := 0
+	letters := 0
+	for _, ch := range ref {
+		if ch < 'A' || ch > 'Z' {
+			break
+		}
+		column = column*26 + int(ch-'A'+1)
+		letters++
+	}
+	if letters == 0 {
+		return 0, fmt.Errorf("invalid cell reference %q", ref)
+	}
+	return column - 1, nil
+}
+
+func isEmptyRow(record []string) bool {
+	for _, value := range record {
+		if strings.TrimSpace(value) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// isBuiltInDateFormat reports whether one of the number formats every workbook has
+// without declaring it shows a date or time.
+func isBuiltInDateFormat(id int) bool {
+	return (id >= 14 && id <= 22) || (id >= 27 && id <= 36) || (id >= 45 && id <= 47) || (id >= 50 && id <= 58)
+}
+
+// isDateFormatCode reports whether a custom number format shows a date or time, ignoring
+// quoted text, escaped characters and bracketed sections such as colours.
+func isDateFormatCode(code string) bool {
+	inQuotes, inBrackets, escaped := false, false, false
+	for _, ch := range code {
+		switch {
+		case escaped:
+			escaped = false
+		case inQuotes:
+			inQuotes = ch != '"'
+		case inBrackets:
+			inBrackets = ch != ']'
+		case ch == '\\':
+			escaped = true
+		case ch == '"':
+			inQuotes = true
+		case ch == '[':
+			inBrackets = true
+		case strings.ContainsRune("yYmMdDhHsS", ch):
+			return true
+		}
+	}
+	return false
+}
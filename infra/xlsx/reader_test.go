@@ -0,0 +1,329 @@
+package xlsx
+
+import (
+	"archive/zip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const (
+	testRels = `<?xml version="1.0" encoding="UTF-8"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="worksheet" Target="worksheets/sheet1.xml"/>
+<Relationship Id="rId2" Type="worksheet" Target="/xl/worksheets/sheet2.xml"/>
+</Relationships>`
+
+	testSharedStrings = `<?xml version="1.0" encoding="UTF-8"?>
+<sst xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+<si><t>unique_identifier</t></si>
+<si><t>amount</t></si>
+<si><r><t>Transfer </t></r><r><t>BRX001</t></r></si>
+</sst>`
+
+	// Style 0 is general, 1 the built-in date format 14, 2 a custom date-time format and 3
+	// a custom format whose only letters are quoted.
+	testStyles = `<?xml version="1.0" encoding="UTF-8"?>
+<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+<numFmts>
+<numFmt numFmtId="164" formatCode="dd/mm/yyyy\ hh:mm"/>
+<numFmt numFmtId="165" formatCode="&quot;Day &quot;0"/>
+</numFmts>
+<cellXfs>
+<xf numFmtId="0"/>
+<xf numFmtId="14"/>
+<xf numFmtId="164"/>
+<xf numFmtId="165"/>
+</cellXfs>
+</styleSheet>`
+)
+
+func testWorkbookXML(date1904 bool) string {
+	properties := ""
+	if date1904 {
+		properties = `<workbookPr date1904="1"/>`
+	}
+	return `<?xml version="1.0" encoding="UTF-8"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+		properties + `<sheets>
+<sheet name="Statement" sheetId="1" r:id="rId1"/>
+<sheet name="Other" sheetId="2" r:id="rId2"/>
+</sheets></workbook>`
+}
+
+func testSheetXML(rows string) string {
+	return `<?xml version="1.0" encoding="UTF-8"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>` +
+		rows + `</sheetData></worksheet>`
+}
+
+// writeWorkbook builds a minimal .xlsx file from its zip entries and returns its path.
+func writeWorkbook(t *testing.T, entries map[string]string) string {
+	t.Helper()
+	name := filepath.Join(t.TempDir(), "statement.xlsx")
+	file, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	z := zip.NewWriter(file)
+	for entry, content := range entries {
+		w, err := z.Create(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(w, content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := z.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+// testWorkbook returns the entries of a workbook whose first sheet holds rows.
+func testWorkbook(rows string) map[string]string {
+	return map[string]string{
+		"xl/workbook.xml":            testWorkbookXML(false),
+		"xl/_rels/workbook.xml.rels": testRels,
+		"xl/sharedStrings.xml":       testSharedStrings,
+		"xl/styles.xml":              testStyles,
+		"xl/worksheets/sheet1.xml":   testSheetXML(rows),
+		"xl/worksheets/sheet2.xml":   testSheetXML(`<row r="1"><c r="A1" t="inlineStr"><is><t>other</t></is></c></row>`),
+	}
+}
+
+type readRow struct {
+	line   int
+	record []string
+}
+
+func readAll(t *testing.T, r *SheetReader) []readRow {
+	t.Helper()
+	var rows []readRow
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			return rows
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows = append(rows, readRow{line: r.Line(), record: record})
+	}
+}
+
+func TestSheetReaderRead(t *testing.T) {
+	tests := []struct {
+		name       string
+		rows       string
+		date1904   bool
+		dateLayout string
+		want       []readRow
+	}{
+		{
+			name: "shared strings",
+			rows: `<row r="1"><c r="A1" t="s"><v>0</v></c><c r="B1" t="s"><v>1</v></c></row>
+<row r="2"><c r="A2" t="s"><v> 2 </v></c></row>`,
+			want: []readRow{
+				{line: 1, record: []string{"unique_identifier", "amount"}},
+				{line: 2, record: []string{"Transfer BRX001"}},
+			},
+		},
+		{
+			name: "inline strings",
+			rows: `<row r="1"><c r="A1" t="inlineStr"><is><t>BRX002</t></is></c>` +
+				`<c r="B1" t="inlineStr"><is><r><t>Fee </t></r><r><t>June</t></r></is></c></row>`,
+			want: []readRow{{line: 1, record: []string{"BRX002", "Fee June"}}},
+		},
+		{
+			name: "cell references leave gaps for missing cells",
+			rows: `<row r="3"><c r="B3"><v>1</v></c><c r="D3"><v>2</v></c></row>
+<row r="4"><c r="AA4"><v>3</v></c></row>`,
+			want: []readRow{
+				{line: 3, record: []string{"", "1", "", "2"}},
+				{line: 4, record: append(make([]string, 26), "3")},
+			},
+		},
+		{
+			name: "cells without references follow each other",
+			rows: `<row><c><v>1</v></c><c t="str"><v>x</v></c></row><row><c t="b"><v>1</v></c><c t="b"><v>0</v></c></row>`,
+			want: []readRow{
+				{line: 1, record: []string{"1", "x"}},
+				{line: 2, record: []string{"TRUE", "FALSE"}},
+			},
+		},
+		{
+			name: "empty rows are skipped but keep their numbers",
+			rows: `<row r="1"><c r="A1"><v>1</v></c></row>
+<row r="2"><c r="A2" t="inlineStr"><is><t> </t></is></c></row>
+<row r="5"><c r="A5"><v>5</v></c></row>`,
+			want: []readRow{
+				{line: 1, record: []string{"1"}},
+				{line: 5, record: []string{"5"}},
+			},
+		},
+		{
+			name: "numbers keep 15 significant digits",
+			rows: `<row r="1"><c r="A1"><v>1234.5599999999999</v></c><c r="B1"><v>-20.5</v></c><c r="C1"><v>1E-3</v></c><c r="D1"><v>15000</v></c></row>`,
+			want: []readRow{{line: 1, record: []string{"1234.56", "-20.5", "0.001", "15000"}}},
+		},
+		{
+			name: "serial dates",
+			rows: `<row r="1"><c r="A1" s="1"><v>45444</v></c><c r="B1" s="2"><v>45444.5</v></c>` +
+				`<c r="C1" s="3"><v>45444</v></c><c r="D1" s="1"><v>1</v></c></row>`,
+			want: []readRow{{line: 1, record: []string{"2024-06-01", "2024-06-01", "45444", "1899-12-31"}}},
+		},
+		{
+			name:       "serial dates with a layout",
+			rows:       `<row r="1"><c r="A1" s="2"><v>45444.75</v></c><c r="B1" s="1"><v>45444.0000115741</v></c></row>`,
+			dateLayout: "2006-01-02 15:04:05",
+			want:       []readRow{{line: 1, record: []string{"2024-06-01 18:00:00", "2024-06-01 00:00:01"}}},
+		},
+		{
+			name:     "serial dates in the 1904 date system",
+			rows:     `<row r="1"><c r="A1" s="1"><v>43982</v></c><c r="B1" s="1"><v>0</v></c></row>`,
+			date1904: true,
+			want:     []readRow{{line: 1, record: []string{"2024-06-01", "1904-01-01"}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries := testWorkbook(tt.rows)
+			entries["xl/workbook.xml"] = testWorkbookXML(tt.date1904)
+			w, err := Open(writeWorkbook(t, entries))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer w.Close()
+
+			r, err := w.OpenSheet("", tt.dateLayout)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			if got := readAll(t, r); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("rows = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSheetReaderErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		rows string
+		want string
+	}{
+		{name: "shared string out of range", rows: `<row r="7"><c r="A7" t="s"><v>3</v></c></row>`, want: `row 7: cell A7: invalid shared string index "3"`},
+		{name: "bad shared string index", rows: `<row r="1"><c r="B1" t="s"><v>x</v></c></row>`, want: `row 1: cell B1: invalid shared string index "x"`},
+		{name: "bad cell reference", rows: `<row r="2"><c r="12"><v>1</v></c></row>`, want: `row 2: invalid cell reference "12"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, err := Open(writeWorkbook(t, testWorkbook(tt.rows)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer w.Close()
+			r, err := w.OpenSheet("", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+
+			_, err = r.Read()
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("Read() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestWorkbookSheets(t *testing.T) {
+	w, err := Open(writeWorkbook(t, testWorkbook(`<row r="1"><c r="A1"><v>1</v></c></row>`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	if got, want := w.SheetNames(), []string{"Statement", "Other"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("SheetNames() = %q, want %q", got, want)
+	}
+
+	r, err := w.OpenSheet("Other", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if got, want := readAll(t, r), []readRow{{line: 1, record: []string{"other"}}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("rows of Other = %q, want %q", got, want)
+	}
+	if got, want := r.Raw(), "other"; got != want {
+		t.Errorf("Raw() = %q, want %q", got, want)
+	}
+
+	if _, err := w.OpenSheet("Missing", ""); !errors.Is(err, ErrSheetNotFound) {
+		t.Errorf("OpenSheet(Missing) error = %v, want ErrSheetNotFound", err)
+	}
+}
+
+func TestWorkbookWithoutSharedStringsOrStyles(t *testing.T) {
+	entries := testWorkbook(`<row r="1"><c r="A1" s="1"><v>45444</v></c><c r="B1" t="inlineStr"><is><t>a,"b"</t></is></c></row>`)
+	delete(entries, "xl/sharedStrings.xml")
+	delete(entries, "xl/styles.xml")
+	w, err := Open(writeWorkbook(t, entries))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	r, err := w.OpenSheet("Statement", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	// Without styles no cell is a date.
+	if got, want := readAll(t, r), []readRow{{line: 1, record: []string{"45444", `a,"b"`}}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("rows = %q, want %q", got, want)
+	}
+	if got, want := r.Raw(), `45444,"a,""b"""`; got != want {
+		t.Errorf("Raw() = %q, want %q", got, want)
+	}
+}
+
+func TestOpenRejectsIncompleteWorkbook(t *testing.T) {
+	entries := testWorkbook("")
+	delete(entries, "xl/workbook.xml")
+	_, err := Open(writeWorkbook(t, entries))
+	if err == nil || !strings.Contains(err.Error(), "xl/workbook.xml") {
+		t.Errorf("Open() error = %v, want a missing xl/workbook.xml", err)
+	}
+}
+
+func TestIsDateFormatCode(t *testing.T) {
+	tests := []struct {
+		code string
+		want bool
+	}{
+		{code: "yyyy-mm-dd", want: true},
+		{code: "dd/mm/yyyy\\ hh:mm", want: true},
+		{code: "[$-409]mmm d", want: true},
+		{code: "0.00", want: false},
+		{code: "#,##0", want: false},
+		{code: `"Day "0`, want: false},
+		{code: "[Red]0.00", want: false},
+		{code: `\d0`, want: false},
+	}
+	for _, tt := range tests {
+		if got := isDateFormatCode(tt.code); got != tt.want {
+			t.Errorf("isDateFormatCode(%q) = %v, want %v", tt.code, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package reconciliation
+
+import (
+	"sort"
+	"time"
+
+	"github.com/labstack/gommon/log"
+	"github.com/radhian/reconciliation-system/consts"
+	"github.com/radhian/reconciliation-system/entity"
+	"github.com/radhian/reconciliation-system/infra/db/dao"
+)
+
+// matchedGroup links several system transactions to one bank row (many-to-one) or one
+// system transaction to several bank rows (one-to-many).
+type matchedGroup struct {
+	System []entity.Transaction
+	Bank   []entity.BankStatement
+	Rule   string
+}
+
+// aggregateMatcher looks for lump-sum settlements among the items left over after 1:1
+// matching. A group holds between 2 and maxGroupSize items of the same type, currency and
+// day whose amounts sum exactly to the single item on the other side, whose date must be
+// within maxDateLagDays of that day. Jobs without a lag limit only group items of one day,
+// since a search over every day would sum up unrelated items.
+type aggregateMatcher struct {
+	maxGroupSize   int
+	maxDateLagDays int64
+}
+
+// newAggregateMatcher returns nil when the job did not ask for aggregate matching.
+func newAggregateMatcher(metadata entity.ProcessMetadata) *aggregateMatcher {
+	if metadata.MaxGroupSize < 2 {
+		return nil
+	}
+	m := &aggregateMatcher{
+		maxGroupSize:   metadata.MaxGroupSize,
+		maxDateLagDays: metadata.MaxDateLagDays,
+	}
+	if m.maxDateLagDays == consts.NoDateLagLimit {
+		m.maxDateLagDays = 0
+	}
+	return m
+}
+
+// BankRowFilter selects the bank rows sharing a currency and direction with any of
+// systemTxs; no other row can join one of their groups.
+func (m *aggregateMatcher) BankRowFilter(systemTxs []entity.Transaction) dao.BankRowFilter {
+	var filter dao.BankRowFilter
+	seen := make(map[string]bool)
+	for _, trx := range systemTxs {
+		key := transactionCurrencyKey(trx)
+		if !seen[key] {
+			seen[key] = true
+			filter.GroupKeys = append(filter.GroupKeys, key)
+		}
+	}
+	return filter
+}
+
+// Match indexes each side by currency, direction and day, so every search only looks at
+// the items of the days within the date lag. The whole pass is held to
+// consts.AggregatePassLimit steps; whatever it has not reached by then stays unmatched.
+func (m *aggregateMatcher) Match(
+	systemTxs []entity.Transaction,
+	bankTxs []entity.BankStatement,
+) (groups []matchedGroup, unmatchedSys []entity.Transaction, unmatchedBank []entity.BankStatement) {
+	usedSys := make([]bool, len(systemTxs))
+	usedBank := make([]bool, len(bankTxs))
+	budget := consts.AggregatePassLimit
+
+	// Many system transactions settled by one bank line.
+	systemIndex := newDayIndex(len(systemTxs), func(i int) (string, time.Time) {
+		return transactionCurrencyKey(systemTxs[i]), calendarDay(systemTxs[i].TransactionTime)
+	})
+	systemAmount := func(i int) entity.Amount { return systemTxs[i].Amount }
+	for bi, b := range bankTxs {
+		if budget <= 0 {
+			break
+		}
+		for _, members := range systemIndex.candidates(bankStatementCurrencyKey(b), calendarDay(b.Date), m.maxDateLagDays, usedSys, &budget) {
+			picked := findSubsetSum(members, systemAmount, b.Amount.Abs(), m.maxGroupSize, &budget)
+			if picked == nil {
+				continue
+			}
+			group := matchedGroup{Bank: []entity.BankStatement{b}, Rule: consts.MatchRuleAggregate}
+			for _, si := range picked {
+				usedSys[si] = true
+				group.System = append(group.System, systemTxs[si])
+			}
+			usedBank[bi] = true
+			groups = append(groups, group)
+			break
+		}
+	}
+
+	// One system transaction paid out as several bank lines.
+	bankIndex := newDayIndex(len(bankTxs), func(i int) (string, time.Time) {
+		return bankStatementCurrencyKey(bankTxs[i]), calendarDay(bankTxs[i].Date)
+	})
+	bankAmount := func(i int) entity.Amount { return bankTxs[i].Amount.Abs() }
+	for si, trx := range systemTxs {
+		if budget <= 0 {
+			break
+		}
+		if usedSys[si] {
+			continue
+		}
+		for _, members := range bankIndex.candidates(transactionCurrencyKey(trx), calendarDay(trx.TransactionTime), m.maxDateLagDays, usedBank, &budget) {
+			picked := findSubsetSum(members, bankAmount, trx.Amount, m.maxGroupSize, &budget)
+			if picked == nil {
+				continue
+			}
+			group := matchedGroup{System: []entity.Transaction{trx}, Rule: consts.MatchRuleAggregate}
+			for _, bi := range picked {
+				usedBank[bi] = true
+				group.Bank = append(group.Bank, bankTxs[bi])
+			}
+			usedSys[si] = true
+			groups = append(groups, group)
+			break
+		}
+	}
+	if budget <= 0 {
+		log.Warnf("[Aggregate] Search limit of %d steps reached, remaining items are left unmatched", consts.AggregatePassLimit)
+	}
+
+	for i, trx := range systemTxs {
+		if !usedSys[i] {
+			unmatchedSys = append(unmatchedSys, trx)
+		}
+	}
+	for i, b := range bankTxs {
+		if !usedBank[i] {
+			unmatchedBank = append(unmatchedBank, b)
+		}
+	}
+	return groups, unmatchedSys, unmatchedBank
+}
+
+// dayIndex holds the items of one side by currency key, then by calendar day in date order.
+type dayIndex map[string][]dayBucket
+
+type dayBucket struct {
+	day     time.Time
+	members []int // item indexes in file order
+}
+
+func newDayIndex(n int, keyOf func(i int) (string, time.Time)) dayIndex {
+	byKey := make(map[string]map[time.Time][]int)
+	for i := 0; i < n; i++ {
+		key, day := keyOf(i)
+		if byKey[key] == nil {
+			byKey[key] = make(map[time.Time][]int)
+		}
+		byKey[key][day] = append(byKey[key][day], i)
+	}
+
+	index := make(dayIndex, len(byKey))
+	for key, days := range byKey {
+		buckets := make([]dayBucket, 0, len(days))
+		for day, members := range days {
+			buckets = append(buckets, dayBucket{day: day, members: members})
+		}
+		sort.Slice(buckets, func(i, j int) bool { return buckets[i].day.Before(buckets[j].day) })
+		index[key] = buckets
+	}
+	return index
+}
+
+// candidates returns the unused items under key, one group per day within maxDateLagDays
+// of day. Groups come in the order their first item appears in the file, so the search
+// stays deterministic. Every item looked at is taken from budget.
+func (x dayIndex) candidates(key string, day time.Time, maxDateLagDays int64, used []bool, budget *int) [][]int {
+	buckets := x[key]
+	from, to := day.AddDate(0, 0, -int(maxDateLagDays)), day.AddDate(0, 0, int(maxDateLagDays))
+	first := sort.Search(len(buckets), func(i int) bool { return !buckets[i].day.Before(from) })
+
+	var groups [][]int
+	for i := first; i < len(buckets) && !buckets[i].day.After(to); i++ {
+		var members []int
+		for _, idx := range buckets[i].members {
+			if !used[idx] {
+				members = append(members, idx)
+			}
+		}
+		*budget -= len(buckets[i].members)
+		if len(members) > 0 {
+			groups = append(groups, members)
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i][0] < groups[j][0] })
+	return groups
+}
+
+// findSubsetSum searches members for 2 to maxSize amounts summing exactly to target, and
+// returns the matching item indexes in file order. One search visits at most
+// consts.AggregateSearchLimit nodes; the nodes visited are taken from budget.
+func findSubsetSum(members []int, amountOf func(i int) entity.Amount, target entity.Amount, maxSize int, budget *int) []int {
+	pool := make([]int, 0, len(members))
+	for _, idx := range members {
+		if amount := amountOf(idx); amount > 0 && amount <= target {
+			pool = append(pool, idx)
+		}
+	}
+	if len(pool) < 2 {
+		return nil
+	}
+	// Largest first, so a branch can stop as soon as the largest remaining amounts
+	// cannot reach what is left of the target.
+	sort.SliceStable(pool, func(i, j int) bool { return amountOf(pool[i]) > amountOf(pool[j]) })
+
+	limit := consts.AggregateSearchLimit
+	if *budget < limit {
+		limit = *budget
+	}
+	visited := 0
+	chosen := make([]int, 0, maxSize)
+	var search func(start int, remaining entity.Amount) bool
+	search = func(start int, remaining entity.Amount) bool {
+		for i := start; i < len(pool); i++ {
+			visited++
+			if visited > limit {
+				return false
+			}
+			amount := amountOf(pool[i])
+			if amount > remaining {
+				continue
+			}
+			if !canReach(pool[i:], amountOf, maxSize-len(chosen), remaining) {
+				return false
+			}
+
+			chosen = append(chosen, pool[i])
+			if amount == remaining && len(chosen) >= 2 {
+				return true
+			}
+			if amount < remaining && len(chosen) < maxSize && search(i+1, remaining-amount) {
+				return true
+			}
+			chosen = chosen[:len(chosen)-1]
+		}
+		return false
+	}
+	found := search(0, target)
+	*budget -= len(members) + visited
+	if !found {
+		return nil
+	}
+
+	picked := append([]int(nil), chosen...)
+	sort.Ints(picked)
+	return picked
+}
+
+// canReach reports whether the largest slots amounts of a descending pool can still add up to target.
+func canReach(pool []int, amountOf func(i int) entity.Amount, slots int, target entity.Amount) bool {
+	var sum entity.Amount
+	for i := 0; i < slots && i < len(pool); i++ {
+		sum += amountOf(pool[i])
+	}
+	return sum >= target
+}
@@ -0,0 +1,142 @@
+package reconciliation
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/radhian/reconciliation-system/consts"
+	"github.com/radhian/reconciliation-system/entity"
+)
+
+func TestFindSubsetSum(t *testing.T) {
+	tests := []struct {
+		amounts []string
+		target  string
+		maxSize int
+		want    []int
+	}{
+		{amounts: []string{"50", "30", "20", "10"}, target: "60", maxSize: 3, want: []int{0, 3}},
+		{amounts: []string{"50", "30", "20", "10"}, target: "100", maxSize: 3, want: []int{0, 1, 2}},
+		{amounts: []string{"50", "30", "20", "10"}, target: "100", maxSize: 2},
+		{amounts: []string{"50", "30", "20", "10"}, target: "111", maxSize: 4},
+		// A single item equal to the target is not a group.
+		{amounts: []string{"10", "50", "30", "20"}, target: "50", maxSize: 3, want: []int{2, 3}},
+		// Zero, negative and too large amounts are left out.
+		{amounts: []string{"0", "-5", "70", "40", "20", "5"}, target: "60", maxSize: 3, want: []int{3, 4}},
+		{amounts: []string{"30", "0", "-30"}, target: "30", maxSize: 3},
+		{amounts: []string{"0.0001", "0.0002", "1000000000"}, target: "0.0003", maxSize: 2, want: []int{0, 1}},
+	}
+	for _, tt := range tests {
+		amounts := make([]entity.Amount, len(tt.amounts))
+		members := make([]int, len(tt.amounts))
+		for i, amount := range tt.amounts {
+			amounts[i], members[i] = entity.MustParseAmount(amount), i
+		}
+		budget := consts.AggregatePassLimit
+		got := findSubsetSum(members, func(i int) entity.Amount { return amounts[i] }, entity.MustParseAmount(tt.target), tt.maxSize, &budget)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("findSubsetSum(%v, %s, %d) = %v, want %v", tt.amounts, tt.target, tt.maxSize, got, tt.want)
+		}
+	}
+}
+
+func TestFindSubsetSumBudget(t *testing.T) {
+	amounts := []entity.Amount{entity.MustParseAmount("50"), entity.MustParseAmount("10")}
+	amountOf := func(i int) entity.Amount { return amounts[i] }
+
+	budget := 0
+	if got := findSubsetSum([]int{0, 1}, amountOf, entity.MustParseAmount("60"), 2, &budget); got != nil {
+		t.Errorf("findSubsetSum with no budget = %v, want nil", got)
+	}
+
+	// The members looked at and the nodes visited are both taken.
+	budget = 100
+	if got := findSubsetSum([]int{0, 1}, amountOf, entity.MustParseAmount("60"), 2, &budget); !reflect.DeepEqual(got, []int{0, 1}) {
+		t.Errorf("findSubsetSum = %v, want [0 1]", got)
+	}
+	if budget != 100-2-2 {
+		t.Errorf("budget = %d, want %d", budget, 100-2-2)
+	}
+}
+
+func groupIDs(groups []matchedGroup) [][]string {
+	ids := [][]string{}
+	for _, g := range groups {
+		ids = append(ids, append(systemIDs(g.System), bankIDs(g.Bank)...))
+	}
+	return ids
+}
+
+func TestNewAggregateMatcher(t *testing.T) {
+	if m := newAggregateMatcher(entity.ProcessMetadata{MaxGroupSize: 1}); m != nil {
+		t.Errorf("newAggregateMatcher(MaxGroupSize 1) = %+v, want nil", m)
+	}
+	m := newAggregateMatcher(entity.ProcessMetadata{MaxGroupSize: 3, MaxDateLagDays: consts.NoDateLagLimit})
+	if m == nil || m.maxGroupSize != 3 || m.maxDateLagDays != 0 {
+		t.Errorf("newAggregateMatcher(MaxGroupSize 3, no lag limit) = %+v, want size 3 and a lag of 0", m)
+	}
+}
+
+func TestAggregateMatcher(t *testing.T) {
+	systemTxs := []entity.Transaction{
+		systemTx("T1", "60", "CREDIT", 0, "IDR"),
+		systemTx("T2", "25", "CREDIT", 0, "IDR"),
+		systemTx("T3", "40", "CREDIT", 0, "IDR"),
+		systemTx("T4", "90", "DEBIT", 0, "IDR"),
+		systemTx("T5", "40", "CREDIT", 0, "USD"),
+		systemTx("T6", "70", "DEBIT", 0, "IDR"),
+	}
+	bankTxs := []entity.BankStatement{
+		bankRow("B1", "100", 0, "IDR"),
+		bankRow("B2", "-50", 1, "IDR"),
+		bankRow("B3", "-40", 1, "IDR"),
+		bankRow("B4", "-35", 3, "IDR"),
+		bankRow("B5", "-35", 3, "IDR"),
+		bankRow("B6", "65", 0, "USD"),
+	}
+
+	tests := []struct {
+		maxLag     int64
+		wantGroups [][]string
+		wantSys    []string
+		wantBank   []string
+	}{
+		{
+			// Only T1 and T3 make up B1 on the same day; T4 is paid out a day later.
+			maxLag:     1,
+			wantGroups: [][]string{{"T1", "T3", "B1"}, {"T4", "B2", "B3"}},
+			wantSys:    []string{"T2", "T5", "T6"},
+			wantBank:   []string{"B4", "B5", "B6"},
+		},
+		{
+			maxLag:     consts.NoDateLagLimit,
+			wantGroups: [][]string{{"T1", "T3", "B1"}},
+			wantSys:    []string{"T2", "T4", "T5", "T6"},
+			wantBank:   []string{"B2", "B3", "B4", "B5", "B6"},
+		},
+		{
+			maxLag:     3,
+			wantGroups: [][]string{{"T1", "T3", "B1"}, {"T4", "B2", "B3"}, {"T6", "B4", "B5"}},
+			wantSys:    []string{"T2", "T5"},
+			wantBank:   []string{"B6"},
+		},
+	}
+	for _, tt := range tests {
+		m := newAggregateMatcher(entity.ProcessMetadata{MaxGroupSize: 3, MaxDateLagDays: tt.maxLag})
+		groups, unmatchedSys, unmatchedBank := m.Match(systemTxs, bankTxs)
+		if got := groupIDs(groups); !reflect.DeepEqual(got, tt.wantGroups) {
+			t.Errorf("lag %d: groups = %v, want %v", tt.maxLag, got, tt.wantGroups)
+		}
+		for _, g := range groups {
+			if g.Rule != consts.MatchRuleAggregate {
+				t.Errorf("lag %d: group rule = %q, want %q", tt.maxLag, g.Rule, consts.MatchRuleAggregate)
+			}
+		}
+		if got := systemIDs(unmatchedSys); !reflect.DeepEqual(got, tt.wantSys) {
+			t.Errorf("lag %d: unmatched system = %v, want %v", tt.maxLag, got, tt.wantSys)
+		}
+		if got := bankIDs(unmatchedBank); !reflect.DeepEqual(got, tt.wantBank) {
+			t.Errorf("lag %d: unmatched bank = %v, want %v", tt.maxLag, got, tt.wantBank)
+		}
+	}
+}
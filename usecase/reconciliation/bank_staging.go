@@ -0,0 +1,342 @@
+package reconciliation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/labstack/gommon/log"
+	"github.com/radhian/reconciliation-system/consts"
+	"github.com/radhian/reconciliation-system/entity"
+	"github.com/radhian/reconciliation-system/infra/db/dao"
+	"github.com/radhian/reconciliation-system/infra/db/model"
+)
+
+// bankRowStageChunk is how many parsed bank rows are held before they are written.
+const bankRowStageChunk = 1000
+
+// bankRowPageSize is how many staged bank rows are read at a time for the leftover report.
+const bankRowPageSize = 1000
+
+// stageJobInputs runs once per job before its first batch: it counts the in-window system
+// rows, copies every in-window bank row into ReconciliationBankRow with the keys the match
+// rules look rows up by, and records every row the parsers reject. Statement balances found
+// in the bank files are added to the job's process info. Neither file is held in memory as
+// a whole. Every file is staged in a transaction of its own and marked as staged in it, so
+// a job that stops part way through picks up at the first file not yet staged.
+func (u *reconciliationUsecase) stageJobInputs(
+	ctx context.Context,
+	logEntry model.ReconciliationProcessLog,
+	assets []model.ReconciliationProcessLogAsset,
+	systemAsset model.ReconciliationProcessLogAsset,
+	metadata entity.ProcessMetadata,
+) (model.ReconciliationProcessLog, error) {
+	startTime, endTime, err := requestTimeRange(metadata)
+	if err != nil {
+		return logEntry, permanentError(consts.JobErrorInvalidTimezone, err)
+	}
+
+	extractor, err := newReferenceExtractor(metadata.ReferenceExtraction)
+	if err != nil {
+		return logEntry, permanentError(consts.JobErrorInvalidReferenceRule, err)
+	}
+
+	now := time.Now().Unix()
+	for _, asset := range assets {
+		if asset.StageTime != 0 || (asset.ID != systemAsset.ID && asset.DataType != consts.DataTypeBankStatement) {
+			continue
+		}
+		logEntry, metadata, err = u.stageAsset(ctx, logEntry, metadata, asset, asset.ID == systemAsset.ID, startTime, endTime, extractor, now)
+		if errors.Is(err, errJobLost) || ctx.Err() != nil {
+			return logEntry, err
+		}
+		if err != nil {
+			return logEntry, retryableError(fmt.Errorf("failed to stage %s: %w", asset.FileName, err))
+		}
+	}
+
+	logEntry.StageTime = now
+	logEntry.UpdateTime = now
+	logEntry.UpdateBy = "system"
+	err = u.saveProcessLog(u.dao, logEntry)
+	if errors.Is(err, errJobLost) {
+		return logEntry, err
+	}
+	if err != nil {
+		return logEntry, retryableError(fmt.Errorf("failed to stage job inputs: %w", err))
+	}
+	return logEntry, nil
+}
+
+// stageAsset stages one file: the system file is counted, a bank file is copied into the
+// staging table. Its rejected rows, its share of the result summary and of the process
+// info, and its staged mark are written in one transaction.
+func (u *reconciliationUsecase) stageAsset(
+	ctx context.Context,
+	logEntry model.ReconciliationProcessLog,
+	metadata entity.ProcessMetadata,
+	asset model.ReconciliationProcessLogAsset,
+	isSystem bool,
+	startTime, endTime time.Time,
+	extractor referenceExtractor,
+	now int64,
+) (model.ReconciliationProcessLog, entity.ProcessMetadata, error) {
+	staged, stagedMetadata := logEntry, metadata
+	err := u.dao.WithTransaction(func(txDao dao.DaoMethod) error {
+		rejected := newRejectedRowWriter(txDao, logEntry.ID, now)
+
+		if isSystem {
+			// An unreadable system file leaves the total at zero; the first batch then
+			// fails to read it too and fails the job.
+			staged.TotalMainRow = 0
+			if err := countSystemRows(asset, startTime, endTime, metadata.DefaultCurrency, &staged.TotalMainRow, rejected.forAsset(asset)); err != nil {
+				log.Errorf("[Stage] System count failed: %v", err)
+				staged.TotalMainRow = 0
+				rejected.add(asset, 0, "", err.Error())
+			}
+			log.Infof("[Stage] Found %d system transactions in range", staged.TotalMainRow)
+		} else {
+			count, balances, err := stageBankAsset(txDao, logEntry.ID, asset, startTime, endTime, metadata.DefaultCurrency, metadata.BankDate, extractor, now, rejected.forAsset(asset))
+			if err != nil {
+				log.Errorf("failed to parse bank statements from %s: %v", asset.FileUrl, err)
+				if err := txDao.DeleteReconciliationBankRowsByAssetID(uint(asset.ID)); err != nil {
+					return err
+				}
+				rejected.add(asset, 0, "", err.Error())
+			} else {
+				log.Infof("[Stage] Staged %d bank rows from %s", count, asset.FileName)
+				if len(balances) > 0 {
+					stagedMetadata.StatementBalances = append(append([]entity.StatementBalance(nil), metadata.StatementBalances...), balances...)
+					infoBytes, err := json.Marshal(stagedMetadata)
+					if err != nil {
+						return fmt.Errorf("failed to marshal process info: %w", err)
+					}
+					staged.ProcessInfo = string(infoBytes)
+				}
+			}
+		}
+		if err := rejected.flush(); err != nil {
+			return err
+		}
+
+		summary, err := parseResultSummary(logEntry.Result)
+		if err != nil {
+			return err
+		}
+		rejected.summarize(&summary)
+		resBytes, err := json.Marshal(summary)
+		if err != nil {
+			return fmt.Errorf("failed to marshal result summary: %w", err)
+		}
+		staged.Result = string(resBytes)
+
+		// A worker that lost the job while parsing leaves the file to its new worker.
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := txDao.UpdateReconciliationProcessLogAssetStageTime(asset.ID, now); err != nil {
+			return err
+		}
+		staged.UpdateTime = now
+		staged.UpdateBy = "system"
+		return u.saveProcessLog(txDao, staged)
+	})
+	if err != nil {
+		return logEntry, metadata, err
+	}
+	return staged, stagedMetadata, nil
+}
+
+func countSystemRows(
+	systemAsset model.ReconciliationProcessLogAsset,
+	startTime, endTime time.Time,
+	defaultCurrency string,
+	count *int64,
+	onReject rejectFunc,
+) error {
+	profile, err := parseProfileOf(systemAsset)
+	if err != nil {
+		return err
+	}
+	_, err = parseSystemTransactions(systemAsset.FileUrl, profile, startTime, endTime, defaultCurrency, csvPosition{},
+		func(entity.Transaction) bool {
+			*count++
+			return true
+		}, onReject)
+	return err
+}
+
+// stageBankAsset streams one bank file into the staging table and returns the number of
+// rows written with the statement balances the file states. Rows written before an error
+// are left for the caller to remove.
+func stageBankAsset(
+	txDao dao.DaoMethod,
+	logID int64,
+	asset model.ReconciliationProcessLogAsset,
+	startTime, endTime time.Time,
+	defaultCurrency string,
+	bankDate string,
+	extractor referenceExtractor,
+	createTime int64,
+	onReject rejectFunc,
+) (int, []entity.StatementBalance, error) {
+	profile, err := parseProfileOf(asset)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	staged := 0
+	chunk := make([]model.ReconciliationBankRow, 0, bankRowStageChunk)
+	flush := func() error {
+		if len(chunk) == 0 {
+			return nil
+		}
+		if err := txDao.CreateReconciliationBankRows(chunk); err != nil {
+			return err
+		}
+		staged += len(chunk)
+		chunk = chunk[:0]
+		return nil
+	}
+
+	var balances []entity.StatementBalance
+	err = parseBankStatements(asset.FileUrl, asset.FileFormat, profile, startTime, endTime, defaultCurrency, bankDate, func(b entity.BankStatement) error {
+		b.SourceFile = asset.FileName
+		chunk = append(chunk, bankRowFromStatement(logID, asset.ID, b, extractor, createTime))
+		if len(chunk) < bankRowStageChunk {
+			return nil
+		}
+		return flush()
+	}, func(balance entity.StatementBalance) {
+		balance.SourceFile = asset.FileName
+		balances = append(balances, balance)
+	}, onReject)
+	if err != nil {
+		return staged, nil, err
+	}
+	if err := flush(); err != nil {
+		return staged, nil, err
+	}
+	return staged, balances, nil
+}
+
+func bankRowFromStatement(logID, assetID int64, b entity.BankStatement, extractor referenceExtractor, createTime int64) model.ReconciliationBankRow {
+	return model.ReconciliationBankRow{
+		ReconciliationProcessLogID: logID,
+		AssetID:                    assetID,
+		SourceFile:                 b.SourceFile,
+		RowNumber:                  b.RowNumber,
+		UniqueIdentifier:           b.UniqueIdentifier,
+		Description:                b.Description,
+		Amount:                     b.Amount,
+		Currency:                   b.Currency,
+		BankDate:                   b.Date,
+		ValueDate:                  b.ValueDate,
+		Direction:                  b.Direction,
+		MatchKey:                   bankStatementKey(b),
+		GroupKey:                   bankStatementCurrencyKey(b),
+		AbsAmount:                  b.Amount.Abs(),
+		Reference:                  extractor.extract(b),
+		CreateTime:                 createTime,
+	}
+}
+
+func bankStatementsFromRows(rows []model.ReconciliationBankRow) []entity.BankStatement {
+	statements := make([]entity.BankStatement, 0, len(rows))
+	for _, r := range rows {
+		statements = append(statements, entity.BankStatement{
+			UniqueIdentifier: r.UniqueIdentifier,
+			Amount:           r.Amount,
+			Date:             r.BankDate.UTC(),
+			Currency:         r.Currency,
+			Description:      r.Description,
+			SourceFile:       r.SourceFile,
+			RowNumber:        r.RowNumber,
+			ValueDate:        utcDate(r.ValueDate),
+			Direction:        r.Direction,
+		})
+	}
+	return statements
+}
+
+func utcDate(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	date := t.UTC()
+	return &date
+}
+
+// fetchFinalPassBank returns the unconsumed bank rows the follow-up rules or the aggregate
+// pass could pair with one of systemTxs, selected by the same keys the rules match on, so
+// rows no leftover transaction could reach are never loaded. Rows in taken are left out.
+// Without follow-up rules or an aggregate pass nothing is loaded.
+func (u *reconciliationUsecase) fetchFinalPassBank(
+	logID int64,
+	systemTxs []entity.Transaction,
+	followUpMatchers []Matcher,
+	aggregator *aggregateMatcher,
+	taken map[string]bool,
+) ([]entity.BankStatement, error) {
+	if len(systemTxs) == 0 || (len(followUpMatchers) == 0 && aggregator == nil) {
+		return nil, nil
+	}
+
+	var filter dao.BankRowFilter
+	for _, matcher := range followUpMatchers {
+		ruleFilter := matcher.BankRowFilter(systemTxs)
+		filter.MatchKeys = append(filter.MatchKeys, ruleFilter.MatchKeys...)
+		filter.GroupKeys = append(filter.GroupKeys, ruleFilter.GroupKeys...)
+		filter.References = append(filter.References, ruleFilter.References...)
+		filter.Ranges = append(filter.Ranges, ruleFilter.Ranges...)
+	}
+	if aggregator != nil {
+		filter.GroupKeys = append(filter.GroupKeys, aggregator.BankRowFilter(systemTxs).GroupKeys...)
+	}
+
+	rows, err := u.dao.GetUnconsumedReconciliationBankRows(uint(logID), filter)
+	if err != nil {
+		return nil, err
+	}
+	candidates := make([]entity.BankStatement, 0, len(rows))
+	for _, b := range bankStatementsFromRows(rows) {
+		if !taken[bankRowKey(b.SourceFile, b.RowNumber)] {
+			candidates = append(candidates, b)
+		}
+	}
+	return candidates, nil
+}
+
+// fetchLeftoverBank returns the bank rows still unconsumed after the last batch, grouped
+// by source file in file order. They are read a page at a time, leaving out the rows in
+// taken: those matched by the last batch and the final pass, whose consumption rows are
+// not written yet.
+func (u *reconciliationUsecase) fetchLeftoverBank(logID int64, taken map[string]bool) (map[string][]entity.BankStatement, error) {
+	bankGroups := make(map[string][]entity.BankStatement)
+	afterID := int64(0)
+	for {
+		rows, err := u.dao.GetUnconsumedReconciliationBankRowsByLogID(uint(logID), afterID, bankRowPageSize)
+		if err != nil {
+			return nil, err
+		}
+		for _, b := range bankStatementsFromRows(rows) {
+			if !taken[bankRowKey(b.SourceFile, b.RowNumber)] {
+				bankGroups[b.SourceFile] = append(bankGroups[b.SourceFile], b)
+			}
+		}
+		if len(rows) > 0 {
+			afterID = rows[len(rows)-1].ID
+		}
+		if len(rows) < bankRowPageSize {
+			break
+		}
+	}
+
+	for _, list := range bankGroups {
+		sort.Slice(list, func(i, j int) bool { return list[i].RowNumber < list[j].RowNumber })
+	}
+	return bankGroups, nil
+}
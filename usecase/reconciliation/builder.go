@@ -2,26 +2,56 @@ package reconciliation
 
 import (
 	"context"
+	"io"
+	"sync"
+	"time"
 
+	"github.com/radhian/reconciliation-system/entity"
 	"github.com/radhian/reconciliation-system/infra/db/dao"
 	"github.com/radhian/reconciliation-system/infra/db/model"
 	"github.com/radhian/reconciliation-system/infra/locker"
 )
 
 type ReconciliationUsecase interface {
-	ProcessReconciliationInit(transactionCSV string, referenceCSVs []string, startTime, endTime int64, operator string) (*model.ReconciliationProcessLog, error)
+	ProcessReconciliationInit(transactionFile entity.ReconciliationFile, referenceFiles []entity.ReconciliationFile, fxRateCSV string, processInfo entity.ProcessMetadata, operator string) (*model.ReconciliationProcessLog, error)
 	GetReconciliationResult(logID int64) (model.ReconciliationProcessLog, error)
+	GetReconciliationMatches(logID int64, trxID string, page, pageSize int) (ReconciliationMatchPage, error)
+	ExportRejectedRows(logID int64, out io.Writer) error
+	ValidateFiles(transactionFile entity.ReconciliationFile, referenceFiles []entity.ReconciliationFile, processInfo entity.ProcessMetadata, maxErrors int) entity.FileValidationResult
+	SaveCsvProfile(profile entity.CsvProfile, operator string) (*model.ReconciliationCsvProfile, error)
+	GetCsvProfiles() ([]entity.CsvProfile, error)
 	ProcessReconciliationJob(ctx context.Context, logID int64) error
-	TryAcquireLock(ctx context.Context) (bool, int64, error)
+	RunReconciliationJob(ctx context.Context, logID int64, budget time.Duration) error
+	TryAcquireLock(ctx context.Context, workerID string) (bool, int64, error)
 	UnlockProcess(ctx context.Context, logsID int64)
+	RecoverStaleJobs(ctx context.Context, staleAfter time.Duration) (int, error)
 }
 
 type reconciliationUsecase struct {
-	dao       dao.DaoMethod
-	locker    *locker.Locker
-	batchSize int64
+	dao         dao.DaoMethod
+	locker      locker.Locker
+	batchSize   int64
+	inputDir    string // the only directory validation requests may name files in
+	jobInputDir string // the only directory jobs may be created from, any when empty
+
+	// Jobs one operator may have running at once, 0 for no limit
+	maxRunningPerOperator int
+
+	mu         sync.Mutex
+	heartbeats map[int64]*heartbeat
 }
 
-func NewReconciliationUsecase(dao dao.DaoMethod, locker *locker.Locker, batchSize int64) ReconciliationUsecase {
-	return &reconciliationUsecase{dao: dao, locker: locker, batchSize: batchSize}
+// NewReconciliationUsecase returns the usecase. inputDir is the directory validation
+// requests may name files in; with none set, they cannot name any. jobInputDir limits the
+// files jobs are created from in the same way, and leaves them unlimited when empty.
+func NewReconciliationUsecase(dao dao.DaoMethod, locker locker.Locker, batchSize int64, maxRunningPerOperator int, inputDir, jobInputDir string) ReconciliationUsecase {
+	return &reconciliationUsecase{
+		dao:                   dao,
+		locker:                locker,
+		batchSize:             batchSize,
+		inputDir:              inputDir,
+		jobInputDir:           jobInputDir,
+		maxRunningPerOperator: maxRunningPerOperator,
+		heartbeats:            make(map[int64]*heartbeat),
+	}
 }
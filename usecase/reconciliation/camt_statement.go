@@ -0,0 +1,191 @@
+package reconciliation
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/labstack/gommon/log"
+	"github.com/radhian/reconciliation-system/consts"
+	"github.com/radhian/reconciliation-system/entity"
+	"github.com/radhian/reconciliation-system/infra/camt"
+)
+
+// camtDateLayouts are the ISODate and ISODateTime forms a camt booking or value date takes.
+var camtDateLayouts = []string{"2006-01-02", time.RFC3339Nano, "2006-01-02T15:04:05.999999999"}
+
+// parseCamtStatements reads the booked entries of a camt.053/054 file the way
+// parseBankStatements reads rows: debits become negative amounts, the end-to-end ID (or
+// the bank's own reference) is the unique identifier and the remittance information is
+// the description. Date is the booking date unless bankDate asks for the value date.
+// RowNumber is the record number within the file. The balances of every statement are
+// handed to onBalance once the file has been read.
+func parseCamtStatements(
+	sourceFile string,
+	startTime, endTime time.Time,
+	defaultCurrency string,
+	bankDate string,
+	fn func(b entity.BankStatement) error,
+	onBalance func(entity.StatementBalance),
+	onReject rejectFunc,
+) error {
+	log.Infof("[BankParser] Reading camt file: %s", sourceFile)
+
+	file, err := os.Open(sourceFile)
+	if err != nil {
+		log.Infof("[BankParser] Failed to open file: %v", err)
+		return fmt.Errorf("failed to read bank statement file: %w", err)
+	}
+	defer file.Close()
+	reader := camt.NewReader(file)
+
+	location := startTime.Location()
+	startDate, endDate := calendarDay(startTime), calendarDay(endTime)
+
+	parsed, number := 0, 0
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Infof("[BankParser] Failed to read camt: %v", err)
+			return fmt.Errorf("failed to read bank statement file %s: %w", sourceFile, err)
+		}
+		number++
+
+		if !record.Booked() {
+			log.Infof("[BankParser] Skipping record %d: entry status %s", number, record.Status)
+			continue
+		}
+
+		currency := entity.NormalizeCurrency(record.Currency)
+		if currency == "" {
+			currency = entity.NormalizeCurrency(defaultCurrency)
+		}
+		var amount entity.Amount
+		signed, err := record.SignedAmount()
+		if err == nil {
+			amount, err = entity.ParseAmount(signed, entity.CurrencyFractionDigits(currency))
+		}
+		if err != nil {
+			log.Infof("[BankParser] Skipping record %d: %v", number, err)
+			onReject.add(number, record.Raw, err.Error())
+			continue
+		}
+		direction := consts.DirectionCredit
+		if record.CreditDebit == "DBIT" {
+			direction = consts.DirectionDebit
+		}
+
+		booking, err := parseCamtDate(record.BookingDate, location)
+		if err != nil {
+			log.Infof("[BankParser] Skipping record %d: invalid booking date '%s'", number, record.BookingDate)
+			onReject.add(number, record.Raw, fmt.Sprintf("invalid booking date '%s'", record.BookingDate))
+			continue
+		}
+		var valueDate *time.Time
+		if record.ValueDate != "" {
+			value, err := parseCamtDate(record.ValueDate, location)
+			if err != nil {
+				log.Infof("[BankParser] Skipping record %d: invalid value date '%s'", number, record.ValueDate)
+				onReject.add(number, record.Raw, fmt.Sprintf("invalid value date '%s'", record.ValueDate))
+				continue
+			}
+			valueDate = &value
+		}
+
+		date := statementDate(booking, valueDate, bankDate)
+		if date.Before(startDate) || date.After(endDate) {
+			log.Infof("[BankParser] Skipping record %d: date out of range", number)
+			continue
+		}
+
+		parsed++
+		err = fn(entity.BankStatement{
+			UniqueIdentifier: firstNonEmpty(record.EndToEndID, record.AccountServicerRef, record.EntryRef),
+			Amount:           amount,
+			Date:             date,
+			Currency:         currency,
+			Description:      record.RemittanceInfo,
+			RowNumber:        int64(number),
+			ValueDate:        valueDate,
+			Direction:        direction,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if onBalance != nil {
+		for _, statement := range reader.Statements() {
+			onBalance(camtStatementBalance(statement, location, onReject))
+		}
+	}
+	log.Infof("[BankParser] Parsed %d valid bank statements", parsed)
+	return nil
+}
+
+func camtStatementBalance(statement camt.Statement, location *time.Location, onReject rejectFunc) entity.StatementBalance {
+	balance := entity.StatementBalance{
+		Reference:       statement.Reference,
+		Account:         statement.Account,
+		StatementNumber: statement.Number,
+	}
+	balance.OpeningBalance, balance.OpeningDate = camtBalance(statement.Opening, location, onReject)
+	balance.ClosingBalance, balance.ClosingDate = camtBalance(statement.Closing, location, onReject)
+	if statement.Opening != nil {
+		balance.Currency = entity.NormalizeCurrency(statement.Opening.Currency)
+	} else if statement.Closing != nil {
+		balance.Currency = entity.NormalizeCurrency(statement.Closing.Currency)
+	}
+	return balance
+}
+
+// camtBalance parses a statement balance; a balance that cannot be read is rejected with
+// line 0, as it belongs to no record.
+func camtBalance(balance *camt.Balance, location *time.Location, onReject rejectFunc) (*entity.Amount, string) {
+	if balance == nil {
+		return nil, ""
+	}
+	signed, err := balance.SignedAmount()
+	var amount entity.Amount
+	if err == nil {
+		amount, err = entity.ParseAmount(signed, entity.CurrencyFractionDigits(entity.NormalizeCurrency(balance.Currency)))
+	}
+	var date time.Time
+	if err == nil {
+		if date, err = parseCamtDate(balance.Date, location); err != nil {
+			err = fmt.Errorf("invalid balance date '%s'", balance.Date)
+		}
+	}
+	if err != nil {
+		log.Infof("[BankParser] Skipping %s balance: %v", balance.Type, err)
+		onReject.add(0, balance.Raw, err.Error())
+		return nil, ""
+	}
+	return &amount, date.Format("2006-01-02")
+}
+
+// parseCamtDate returns the calendar date of an ISODate or ISODateTime in location; a
+// date time without an offset is taken to be in location already.
+func parseCamtDate(raw string, location *time.Location) (time.Time, error) {
+	var err error
+	for _, layout := range camtDateLayouts {
+		var t time.Time
+		if t, err = time.ParseInLocation(layout, raw, location); err == nil {
+			return calendarDay(t.In(location)), nil
+		}
+	}
+	return time.Time{}, err
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,419 @@
+package reconciliation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/radhian/reconciliation-system/consts"
+	"github.com/radhian/reconciliation-system/entity"
+	"github.com/radhian/reconciliation-system/infra/db/dao"
+	"github.com/radhian/reconciliation-system/infra/db/model"
+)
+
+var ErrCsvProfileNotFound = errors.New("csv profile not found")
+
+var (
+	systemCsvFields = map[string]bool{
+		consts.CsvFieldTrxID:           true,
+		consts.CsvFieldAmount:          true,
+		consts.CsvFieldType:            true,
+		consts.CsvFieldTransactionTime: true,
+		consts.CsvFieldCurrency:        false,
+	}
+	// The amount of a bank file is either one column or a debit and a credit column; see
+	// validateBankAmountColumns.
+	bankCsvFields = map[string]bool{
+		consts.CsvFieldUniqueIdentifier: true,
+		consts.CsvFieldAmount:           false,
+		consts.CsvFieldDate:             true,
+		consts.CsvFieldCurrency:         false,
+		consts.CsvFieldDescription:      false,
+		consts.CsvFieldDirection:        false,
+		consts.CsvFieldDebitAmount:      false,
+		consts.CsvFieldCreditAmount:     false,
+		consts.CsvFieldValueDate:        false,
+	}
+
+	// Values of a direction column, compared case-insensitively.
+	directionValues = map[string]string{
+		"C":      consts.DirectionCredit,
+		"CR":     consts.DirectionCredit,
+		"CRDT":   consts.DirectionCredit,
+		"CREDIT": consts.DirectionCredit,
+		"+":      consts.DirectionCredit,
+		"D":      consts.DirectionDebit,
+		"DR":     consts.DirectionDebit,
+		"DB":     consts.DirectionDebit,
+		"DBIT":   consts.DirectionDebit,
+		"DEBIT":  consts.DirectionDebit,
+		"-":      consts.DirectionDebit,
+	}
+)
+
+// csvFieldsFor returns the logical fields of a file type, mapped to whether they are required.
+func csvFieldsFor(dataType int64) map[string]bool {
+	if dataType == consts.DataTypeSystemFile {
+		return systemCsvFields
+	}
+	return bankCsvFields
+}
+
+func columnIndex(i int) entity.CsvColumn {
+	return entity.CsvColumn{Index: &i}
+}
+
+// defaultCsvProfile is the built-in layout: trxID,amount,type,transactionTime for system
+// files and unique_identifier,amount,date for bank files, by position, plus the optional
+// columns found by header name.
+func defaultCsvProfile(dataType int64) entity.CsvProfile {
+	if dataType == consts.DataTypeSystemFile {
+		return entity.CsvProfile{
+			DataType: dataType,
+			Columns: map[string]entity.CsvColumn{
+				consts.CsvFieldTrxID:           columnIndex(0),
+				consts.CsvFieldAmount:          columnIndex(1),
+				consts.CsvFieldType:            columnIndex(2),
+				consts.CsvFieldTransactionTime: columnIndex(3),
+				consts.CsvFieldCurrency:        {Header: "currency"},
+			},
+			DateLayout: time.RFC3339,
+		}
+	}
+	return entity.CsvProfile{
+		DataType: consts.DataTypeBankStatement,
+		Columns: map[string]entity.CsvColumn{
+			consts.CsvFieldUniqueIdentifier: columnIndex(0),
+			consts.CsvFieldAmount:           columnIndex(1),
+			consts.CsvFieldDate:             columnIndex(2),
+			consts.CsvFieldCurrency:         {Header: "currency"},
+			consts.CsvFieldDescription:      {Header: "description"},
+			consts.CsvFieldDirection:        {Header: "direction"},
+			consts.CsvFieldValueDate:        {Header: "value_date"},
+		},
+		DateLayout: "2006-01-02",
+	}
+}
+
+// ValidateCsvProfile reports the first problem that would stop the profile from parsing a file.
+func ValidateCsvProfile(profile entity.CsvProfile) error {
+	if strings.TrimSpace(profile.Name) == "" {
+		return errors.New("profile name is required")
+	}
+	if profile.DataType != consts.DataTypeSystemFile && profile.DataType != consts.DataTypeBankStatement {
+		return fmt.Errorf("data type must be %d (system file) or %d (bank statement)", consts.DataTypeSystemFile, consts.DataTypeBankStatement)
+	}
+
+	fields := csvFieldsFor(profile.DataType)
+	for field, required := range fields {
+		if _, ok := profile.Columns[field]; required && !ok {
+			return fmt.Errorf("column for %q is required", field)
+		}
+	}
+	for field, column := range profile.Columns {
+		if _, ok := fields[field]; !ok {
+			return fmt.Errorf("unknown column field %q", field)
+		}
+		if column.Index == nil && strings.TrimSpace(column.Header) == "" {
+			return fmt.Errorf("column %q needs a header or an index", field)
+		}
+		if column.Index != nil && *column.Index < 0 {
+			return fmt.Errorf("column %q index must not be negative", field)
+		}
+	}
+	if profile.DataType == consts.DataTypeBankStatement {
+		if err := validateBankAmountColumns(profile.Columns); err != nil {
+			return err
+		}
+	}
+
+	if profile.SkipRows < 0 {
+		return errors.New("skip rows must not be negative")
+	}
+	if profile.Delimiter != "" && utf8.RuneCountInString(profile.Delimiter) != 1 {
+		return errors.New("delimiter must be a single character")
+	}
+	if profile.QuoteChar != "" && utf8.RuneCountInString(profile.QuoteChar) != 1 {
+		return errors.New("quote char must be a single character")
+	}
+	if profile.Delimiter != "" && profile.Delimiter == profile.QuoteChar {
+		return errors.New("delimiter and quote char must differ")
+	}
+	if profile.DateLayout != "" {
+		sample := time.Date(2024, 6, 1, 8, 30, 0, 0, time.UTC).Format(profile.DateLayout)
+		if _, err := time.Parse(profile.DateLayout, sample); err != nil || sample == profile.DateLayout {
+			return fmt.Errorf("invalid date layout %q", profile.DateLayout)
+		}
+	}
+	if profile.DecimalSeparator != "" && profile.DecimalSeparator != "." && profile.DecimalSeparator != "," {
+		return errors.New("decimal separator must be \".\" or \",\"")
+	}
+	if profile.ThousandsSeparator != "" && profile.ThousandsSeparator == decimalSeparator(profile) {
+		return errors.New("thousands separator must differ from the decimal separator")
+	}
+	switch profile.SignConvention {
+	case "", consts.SignConventionSigned, consts.SignConventionInverted:
+	default:
+		return fmt.Errorf("unknown sign convention %q", profile.SignConvention)
+	}
+	return nil
+}
+
+// validateBankAmountColumns checks that a bank profile maps either one amount column,
+// optionally with a direction column, or a debit and a credit column.
+func validateBankAmountColumns(columns map[string]entity.CsvColumn) error {
+	_, amount := columns[consts.CsvFieldAmount]
+	_, direction := columns[consts.CsvFieldDirection]
+	_, debit := columns[consts.CsvFieldDebitAmount]
+	_, credit := columns[consts.CsvFieldCreditAmount]
+	switch {
+	case amount && (debit || credit):
+		return fmt.Errorf("map either %q or %q and %q, not both", consts.CsvFieldAmount, consts.CsvFieldDebitAmount, consts.CsvFieldCreditAmount)
+	case !amount && !(debit && credit):
+		return fmt.Errorf("column for %q is required, or columns for both %q and %q", consts.CsvFieldAmount, consts.CsvFieldDebitAmount, consts.CsvFieldCreditAmount)
+	case direction && !amount:
+		return fmt.Errorf("column %q only applies with an %q column", consts.CsvFieldDirection, consts.CsvFieldAmount)
+	}
+	return nil
+}
+
+func (u *reconciliationUsecase) SaveCsvProfile(profile entity.CsvProfile, operator string) (*model.ReconciliationCsvProfile, error) {
+	profile.Name = strings.TrimSpace(profile.Name)
+	if err := ValidateCsvProfile(profile); err != nil {
+		return nil, err
+	}
+
+	config, err := json.Marshal(profile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal csv profile: %w", err)
+	}
+
+	timeNowUnix := time.Now().Unix()
+	record := &model.ReconciliationCsvProfile{
+		Name:       profile.Name,
+		DataType:   profile.DataType,
+		Config:     string(config),
+		CreateTime: timeNowUnix,
+		CreateBy:   operator,
+		UpdateTime: timeNowUnix,
+		UpdateBy:   operator,
+	}
+	if err := u.dao.SaveReconciliationCsvProfile(record); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+func (u *reconciliationUsecase) GetCsvProfiles() ([]entity.CsvProfile, error) {
+	records, err := u.dao.GetReconciliationCsvProfiles()
+	if err != nil {
+		return nil, err
+	}
+	profiles := make([]entity.CsvProfile, 0, len(records))
+	for _, record := range records {
+		var profile entity.CsvProfile
+		if err := json.Unmarshal([]byte(record.Config), &profile); err != nil {
+			return nil, fmt.Errorf("failed to parse csv profile %s: %w", record.Name, err)
+		}
+		profiles = append(profiles, profile)
+	}
+	return profiles, nil
+}
+
+// loadCsvProfileConfig returns the stored configuration of a named profile, checking that
+// it describes the expected kind of file. An empty name means the built-in layout.
+func (u *reconciliationUsecase) loadCsvProfileConfig(name string, dataType int64) (string, error) {
+	if name == "" {
+		return "", nil
+	}
+	record, err := u.dao.GetReconciliationCsvProfileByName(name)
+	if errors.Is(err, dao.ErrRecordNotFound) {
+		return "", fmt.Errorf("%w: %s", ErrCsvProfileNotFound, name)
+	}
+	if err != nil {
+		return "", err
+	}
+	if record.DataType != dataType {
+		return "", fmt.Errorf("csv profile %s is for data type %d, not %d", name, record.DataType, dataType)
+	}
+	return record.Config, nil
+}
+
+// parseProfileOf returns the profile snapshot stored on an asset, or the built-in layout.
+func parseProfileOf(asset model.ReconciliationProcessLogAsset) (entity.CsvProfile, error) {
+	if strings.TrimSpace(asset.ParseProfile) == "" {
+		return defaultCsvProfile(asset.DataType), nil
+	}
+	var profile entity.CsvProfile
+	if err := json.Unmarshal([]byte(asset.ParseProfile), &profile); err != nil {
+		return profile, fmt.Errorf("failed to parse csv profile of %s: %w", asset.FileName, err)
+	}
+	return profile, nil
+}
+
+// csvLayout is a profile resolved against the header row of one file.
+type csvLayout struct {
+	profile entity.CsvProfile
+	columns map[string]int
+}
+
+// newCsvLayout finds every mapped column. A required column that is missing from the
+// header is an error; a missing optional one is simply absent.
+func newCsvLayout(profile entity.CsvProfile, header []string) (csvLayout, error) {
+	layout := csvLayout{profile: profile, columns: make(map[string]int)}
+	for field, column := range profile.Columns {
+		index := -1
+		if column.Index != nil {
+			index = *column.Index
+		} else {
+			index = findHeaderIndex(header, column.Header)
+		}
+		if index < 0 {
+			if layout.mandatory(field) {
+				return layout, fmt.Errorf("column %q for %s not found in header", column.Header, field)
+			}
+			continue
+		}
+		layout.columns[field] = index
+	}
+	return layout, nil
+}
+
+// value returns the trimmed field of a record, or "" when the column is absent.
+func (l csvLayout) value(record []string, field string) string {
+	index, ok := l.columns[field]
+	if !ok || index >= len(record) {
+		return ""
+	}
+	return strings.TrimSpace(record[index])
+}
+
+// mandatory reports whether a mapped field must be present: the required fields, and the
+// amount columns of a bank file, which the profile maps in one of two ways.
+func (l csvLayout) mandatory(field string) bool {
+	if csvFieldsFor(l.profile.DataType)[field] {
+		return true
+	}
+	switch field {
+	case consts.CsvFieldAmount, consts.CsvFieldDebitAmount, consts.CsvFieldCreditAmount:
+		return true
+	}
+	return false
+}
+
+// hasRequiredFields reports whether the record is wide enough for every mandatory column.
+// The debit and credit columns are left out: a worksheet row ends at its last non-empty
+// cell, so one of them may be cut off, and splitBankAmount reads it as empty.
+func (l csvLayout) hasRequiredFields(record []string) bool {
+	for field, index := range l.columns {
+		if field == consts.CsvFieldDebitAmount || field == consts.CsvFieldCreditAmount {
+			continue
+		}
+		if l.mandatory(field) && index >= len(record) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseAmount normalises the profile's separators and parses the amount with the
+// fractional digits allowed for the currency.
+func (l csvLayout) parseAmount(raw, currency string) (entity.Amount, error) {
+	value := strings.Replace(raw, " ", "", -1)
+	if l.profile.ThousandsSeparator != "" {
+		value = strings.Replace(value, l.profile.ThousandsSeparator, "", -1)
+	}
+	if separator := decimalSeparator(l.profile); separator != "." {
+		value = strings.Replace(value, separator, ".", 1)
+	}
+	amount, err := entity.ParseAmount(value, entity.CurrencyFractionDigits(currency))
+	if err != nil {
+		return 0, err
+	}
+	if l.profile.SignConvention == consts.SignConventionInverted {
+		amount = -amount
+	}
+	return amount, nil
+}
+
+// bankAmount returns the signed amount of a bank record and its direction, from a D/C
+// column next to the amount, from separate debit and credit columns, or from the sign.
+func (l csvLayout) bankAmount(record []string, currency string) (entity.Amount, string, error) {
+	if _, ok := l.columns[consts.CsvFieldDebitAmount]; ok {
+		return l.splitBankAmount(record, currency)
+	}
+
+	amount, err := l.parseAmount(l.value(record, consts.CsvFieldAmount), currency)
+	if err != nil {
+		return 0, "", err
+	}
+	if _, ok := l.columns[consts.CsvFieldDirection]; !ok {
+		if amount < 0 {
+			return amount, consts.DirectionDebit, nil
+		}
+		return amount, consts.DirectionCredit, nil
+	}
+
+	raw := l.value(record, consts.CsvFieldDirection)
+	direction, ok := directionValues[strings.ToUpper(raw)]
+	if !ok {
+		return 0, "", fmt.Errorf("invalid direction %q", raw)
+	}
+	if direction == consts.DirectionDebit {
+		return -amount.Abs(), direction, nil
+	}
+	return amount.Abs(), direction, nil
+}
+
+// splitBankAmount reads a record whose amount sits in either its debit or its credit
+// column; the other one is empty or zero.
+func (l csvLayout) splitBankAmount(record []string, currency string) (entity.Amount, string, error) {
+	rawDebit := l.value(record, consts.CsvFieldDebitAmount)
+	rawCredit := l.value(record, consts.CsvFieldCreditAmount)
+	var debit, credit entity.Amount
+	var err error
+	if rawDebit != "" {
+		if debit, err = l.parseAmount(rawDebit, currency); err != nil {
+			return 0, "", err
+		}
+	}
+	if rawCredit != "" {
+		if credit, err = l.parseAmount(rawCredit, currency); err != nil {
+			return 0, "", err
+		}
+	}
+
+	switch {
+	case debit != 0 && credit != 0:
+		return 0, "", errors.New("both debit and credit amounts given")
+	case debit != 0:
+		return -debit.Abs(), consts.DirectionDebit, nil
+	case credit != 0:
+		return credit.Abs(), consts.DirectionCredit, nil
+	case rawDebit != "":
+		return 0, consts.DirectionDebit, nil
+	case rawCredit != "":
+		return 0, consts.DirectionCredit, nil
+	}
+	return 0, "", errors.New("missing debit and credit amount")
+}
+
+// parseTime reads a date or time with the profile's layout; a value without a zone is
+// taken to be in location.
+func (l csvLayout) parseTime(raw string, location *time.Location) (time.Time, error) {
+	layout := l.profile.DateLayout
+	if layout == "" {
+		layout = defaultCsvProfile(l.profile.DataType).DateLayout
+	}
+	return time.ParseInLocation(layout, raw, location)
+}
+
+func decimalSeparator(profile entity.CsvProfile) string {
+	if profile.DecimalSeparator == "" {
+		return "."
+	}
+	return profile.DecimalSeparator
+}
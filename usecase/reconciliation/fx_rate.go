@@ -0,0 +1,114 @@
+package reconciliation
+
+import (
+	"encoding/csv"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/labstack/gommon/log"
+	"github.com/radhian/reconciliation-system/consts"
+	"github.com/radhian/reconciliation-system/entity"
+	"github.com/radhian/reconciliation-system/infra/db/model"
+)
+
+// fxRateTable holds exchange rates as rates[from][to]: one unit of from buys rate units of to.
+type fxRateTable map[string]map[string]*big.Rat
+
+// rate returns the rate from one currency to another, deriving it from the opposite
+// direction when only that one was uploaded.
+func (t fxRateTable) rate(from, to string) (*big.Rat, bool) {
+	if rate, ok := t[from][to]; ok {
+		return rate, true
+	}
+	if rate, ok := t[to][from]; ok {
+		return new(big.Rat).Inv(rate), true
+	}
+	return nil, false
+}
+
+// targets lists, in a stable order, every currency the given one can be converted into.
+func (t fxRateTable) targets(from string) []string {
+	seen := make(map[string]bool)
+	for to := range t[from] {
+		seen[to] = true
+	}
+	for other, rates := range t {
+		if _, ok := rates[from]; ok {
+			seen[other] = true
+		}
+	}
+	delete(seen, from)
+
+	currencies := make([]string, 0, len(seen))
+	for currency := range seen {
+		currencies = append(currencies, currency)
+	}
+	sort.Strings(currencies)
+	return currencies
+}
+
+func (t fxRateTable) add(from, to string, rate *big.Rat) {
+	if t[from] == nil {
+		t[from] = make(map[string]*big.Rat)
+	}
+	t[from][to] = rate
+}
+
+// loadFxRates reads every FX rate asset of the job; later files override earlier ones.
+func loadFxRates(assets []model.ReconciliationProcessLogAsset) (fxRateTable, error) {
+	rates := make(fxRateTable)
+	for _, asset := range assets {
+		if asset.DataType != consts.DataTypeFxRate {
+			continue
+		}
+		if err := parseFxRates(asset.FileUrl, rates); err != nil {
+			return nil, err
+		}
+	}
+	return rates, nil
+}
+
+// parseFxRates reads a from_currency,to_currency,rate CSV, locating the columns by header name.
+func parseFxRates(sourceFile string, rates fxRateTable) error {
+	log.Infof("[FxRateParser] Reading fx rate file: %s", sourceFile)
+
+	file, err := os.Open(sourceFile)
+	if err != nil {
+		return fmt.Errorf("failed to open fx rate file %s: %w", sourceFile, err)
+	}
+	defer file.Close()
+
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		return fmt.Errorf("failed to read CSV from fx rate file %s: %w", sourceFile, err)
+	}
+	if len(records) == 0 {
+		return fmt.Errorf("fx rate file %s is empty", sourceFile)
+	}
+
+	fromIndex := findHeaderIndex(records[0], "from_currency")
+	toIndex := findHeaderIndex(records[0], "to_currency")
+	rateIndex := findHeaderIndex(records[0], "rate")
+	if fromIndex < 0 || toIndex < 0 || rateIndex < 0 {
+		return fmt.Errorf("fx rate file %s must have from_currency, to_currency and rate columns", sourceFile)
+	}
+
+	for i, record := range records[1:] {
+		if len(record) <= fromIndex || len(record) <= toIndex || len(record) <= rateIndex {
+			return fmt.Errorf("fx rate file %s row %d: insufficient fields", sourceFile, i+2)
+		}
+		from := entity.NormalizeCurrency(record[fromIndex])
+		to := entity.NormalizeCurrency(record[toIndex])
+		rate, ok := new(big.Rat).SetString(strings.TrimSpace(record[rateIndex]))
+		if from == "" || to == "" || !ok || rate.Sign() <= 0 {
+			return fmt.Errorf("fx rate file %s row %d: invalid rate %v", sourceFile, i+2, record)
+		}
+		rates.add(from, to, rate)
+	}
+
+	log.Infof("[FxRateParser] Parsed %d fx rates", len(records)-1)
+	return nil
+}
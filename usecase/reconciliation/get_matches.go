@@ -0,0 +1,25 @@
+package reconciliation
+
+import (
+	"github.com/radhian/reconciliation-system/infra/db/model"
+)
+
+type ReconciliationMatchPage struct {
+	Matches  []model.ReconciliationMatch `json:"matches"`
+	Page     int                         `json:"page"`
+	PageSize int                         `json:"page_size"`
+	Total    int64                       `json:"total"`
+}
+
+func (u *reconciliationUsecase) GetReconciliationMatches(logID int64, trxID string, page, pageSize int) (ReconciliationMatchPage, error) {
+	matches, total, err := u.dao.GetReconciliationMatchesByLogID(uint(logID), trxID, pageSize, (page-1)*pageSize)
+	if err != nil {
+		return ReconciliationMatchPage{}, err
+	}
+	return ReconciliationMatchPage{
+		Matches:  matches,
+		Page:     page,
+		PageSize: pageSize,
+		Total:    total,
+	}, nil
+}
@@ -0,0 +1,189 @@
+package reconciliation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/radhian/reconciliation-system/consts"
+	"github.com/radhian/reconciliation-system/infra/db/dao"
+	"github.com/radhian/reconciliation-system/infra/db/model"
+)
+
+// errJobLost is returned when a batch finds its job taken back from the worker, whose
+// work is then left uncommitted for the job's new worker.
+var errJobLost = errors.New("job is no longer held by this worker")
+
+type heartbeat struct {
+	workerID string
+	stop     chan struct{}
+	ctx      context.Context // cancelled once the job is found lost
+	cancel   context.CancelFunc
+
+	mu       sync.Mutex // held while the heartbeat is written or checked
+	beatTime int64      // the heartbeat this worker last wrote
+}
+
+// startHeartbeat puts workerID on the job and keeps its heartbeat fresh until
+// stopHeartbeat, so the reaper can tell a job whose worker died from one still running.
+// It reports false when another worker is still on the job. Once the job is taken back or
+// the locker's claim runs out, the heartbeat stops and work on the job is cancelled.
+func (u *reconciliationUsecase) startHeartbeat(logID int64, workerID string) (bool, error) {
+	now := time.Now().Unix()
+	started, err := u.dao.UpdateReconciliationProcessLogHeartbeat(logID, workerID, now)
+	if err != nil || !started {
+		return false, err
+	}
+
+	beat := &heartbeat{workerID: workerID, stop: make(chan struct{}), beatTime: now}
+	beat.ctx, beat.cancel = context.WithCancel(context.Background())
+	u.mu.Lock()
+	u.heartbeats[logID] = beat
+	u.mu.Unlock()
+
+	claimLost := u.locker.Lost(logID)
+	go func() {
+		ticker := time.NewTicker(consts.HeartbeatIntervalInSec * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-beat.stop:
+				return
+			case <-claimLost:
+				log.Printf("[HEARTBEAT] log_id:%d worker:%s lost the claim", logID, workerID)
+				beat.cancel()
+				return
+			case <-ticker.C:
+				held, err := beat.renew(u.dao, logID)
+				if err != nil {
+					log.Printf("[HEARTBEAT] log_id:%d worker:%s error: %v", logID, workerID, err)
+					continue
+				}
+				if !held {
+					log.Printf("[HEARTBEAT] log_id:%d worker:%s lost the job", logID, workerID)
+					beat.cancel()
+					return
+				}
+			}
+		}
+	}()
+	return true, nil
+}
+
+// renew moves the heartbeat on from the one this worker last wrote.
+func (b *heartbeat) renew(d dao.DaoMethod, logID int64) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now().Unix()
+	held, err := d.RenewReconciliationProcessLogHeartbeat(logID, b.workerID, b.beatTime, now)
+	if err == nil && held {
+		b.beatTime = now
+	}
+	return held, err
+}
+
+// stopHeartbeat stops the job's heartbeat and takes the worker off the job.
+func (u *reconciliationUsecase) stopHeartbeat(logID int64) {
+	u.mu.Lock()
+	beat, ok := u.heartbeats[logID]
+	delete(u.heartbeats, logID)
+	u.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	close(beat.stop)
+	beat.cancel()
+	if err := u.dao.ClearReconciliationProcessLogHeartbeat(logID, beat.workerID); err != nil {
+		log.Printf("[HEARTBEAT] log_id:%d worker:%s error: %v", logID, beat.workerID, err)
+	}
+}
+
+// jobContext returns a context for work on a job that ends with parent or as soon as the
+// worker loses the job. A job run without a heartbeat only ends with parent.
+func (u *reconciliationUsecase) jobContext(parent context.Context, logID int64) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	u.mu.Lock()
+	beat, ok := u.heartbeats[logID]
+	u.mu.Unlock()
+	if !ok {
+		return ctx, cancel
+	}
+
+	go func() {
+		select {
+		case <-beat.ctx.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// saveProcessLog writes the job's log through d. While the worker has a heartbeat on the
+// job, the log is only written if it still carries that heartbeat and errJobLost is
+// returned otherwise, which rolls back the transaction d belongs to. The heartbeat is held
+// still until the write is made; a heartbeat after it waits for the transaction's row lock,
+// so the log must not have been written earlier in the same transaction.
+func (u *reconciliationUsecase) saveProcessLog(d dao.DaoMethod, logEntry model.ReconciliationProcessLog) error {
+	u.mu.Lock()
+	beat, ok := u.heartbeats[logEntry.ID]
+	u.mu.Unlock()
+	if !ok {
+		return d.UpdateReconciliationProcessLog(logEntry)
+	}
+
+	beat.mu.Lock()
+	defer beat.mu.Unlock()
+	saved, err := d.UpdateReconciliationProcessLogAsWorker(logEntry, beat.workerID, beat.beatTime)
+	if err != nil {
+		return err
+	}
+	if !saved {
+		return errJobLost
+	}
+	return nil
+}
+
+// RecoverStaleJobs takes back the unfinished jobs whose worker has not sent a heartbeat
+// for staleAfter, so another worker can pick them up. A job taken back MaxJobRecoveries
+// times is failed instead. It returns how many jobs were taken back.
+func (u *reconciliationUsecase) RecoverStaleJobs(ctx context.Context, staleAfter time.Duration) (int, error) {
+	now := time.Now()
+	staleLogs, err := u.dao.GetStaleReconciliationProcessLogs(
+		[]int{consts.StatusInit, consts.StatusRunning},
+		now.Add(-staleAfter).Unix(),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	recovered := 0
+	for _, logEntry := range staleLogs {
+		workerID, heartbeatTime := logEntry.WorkerID, logEntry.HeartbeatTime
+
+		logEntry.RecoveryCount++
+		if logEntry.RecoveryCount >= consts.MaxJobRecoveries {
+			logEntry.Status = consts.StatusFailed
+			logEntry.ErrorCode = consts.JobErrorWorkerLost
+			logEntry.ErrorMessage = fmt.Sprintf("worker %s stopped sending heartbeats %d times", workerID, logEntry.RecoveryCount)
+		}
+		logEntry.UpdateTime = now.Unix()
+		logEntry.UpdateBy = "system"
+
+		released, err := u.dao.ReleaseStaleReconciliationProcessLog(logEntry, workerID, heartbeatTime)
+		if err != nil {
+			return recovered, err
+		}
+		if !released {
+			continue
+		}
+		recovered++
+		log.Printf("[REAPER] log_id:%d taken back from worker:%s (last heartbeat %s, recovery %d, status %d)",
+			logEntry.ID, workerID, time.Unix(heartbeatTime, 0).UTC().Format(time.RFC3339), logEntry.RecoveryCount, logEntry.Status)
+	}
+	return recovered, nil
+}
@@ -0,0 +1,297 @@
+package reconciliation
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/radhian/reconciliation-system/consts"
+	"github.com/radhian/reconciliation-system/entity"
+	"github.com/radhian/reconciliation-system/infra/csvreader"
+	"github.com/radhian/reconciliation-system/infra/xlsx"
+)
+
+// ValidateReferenceFile checks the requested format of a bank file and that a CSV profile
+// is only given for a format that uses one.
+func ValidateReferenceFile(file entity.ReconciliationFile) error {
+	switch strings.ToLower(file.Format) {
+	case "", consts.FileFormatCSV, consts.FileFormatXLSX, consts.FileFormatCamt, consts.FileFormatMT940:
+	default:
+		return fmt.Errorf("unknown file format %q", file.Format)
+	}
+	if format := fileFormatOf(file); file.Profile != "" && !usesCsvProfile(format) {
+		return fmt.Errorf("%s file %s does not take a csv profile", format, file.Path)
+	}
+	return nil
+}
+
+// ErrInputFileRejected is wrapped by the errors for input files a request may not name:
+// files outside the input directory, missing files and anything but a regular file.
+var ErrInputFileRejected = errors.New("input file rejected")
+
+// resolveInputPath returns the real path of a file a validation request names. Those may
+// only name files inside the input directory, so the endpoint cannot be used to read
+// anything else on the server.
+func (u *reconciliationUsecase) resolveInputPath(path string) (string, error) {
+	if u.inputDir == "" {
+		return "", fmt.Errorf("%w: no input directory is configured", ErrInputFileRejected)
+	}
+	return resolvePathIn(u.inputDir, path)
+}
+
+// resolveJobInputPath returns the real path of a file a job is created from. Jobs may
+// name any regular file, as they always could, unless a job input directory is set.
+func (u *reconciliationUsecase) resolveJobInputPath(path string) (string, error) {
+	if u.jobInputDir == "" {
+		return resolveRegularFile(path, path)
+	}
+	return resolvePathIn(u.jobInputDir, path)
+}
+
+// resolvePathIn returns the real path of a regular file inside dir, cleaned and with
+// symlinks resolved. The path as given is checked first, so nothing outside the directory
+// is looked up at all.
+func resolvePathIn(inputDir, path string) (string, error) {
+	dir, err := filepath.Abs(inputDir)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve input directory %s: %w", inputDir, err)
+	}
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve input directory %s: %w", inputDir, err)
+	}
+
+	absPath, err := filepath.Abs(path)
+	if err != nil || !insideDir(dir, absPath) {
+		return "", fmt.Errorf("%w: %s is outside the input directory", ErrInputFileRejected, path)
+	}
+	realPath, err := resolveRegularFile(absPath, path)
+	if err != nil {
+		return "", err
+	}
+	if !insideDir(realDir, realPath) {
+		return "", fmt.Errorf("%w: %s is outside the input directory", ErrInputFileRejected, path)
+	}
+	return realPath, nil
+}
+
+// resolveRegularFile resolves the symlinks of path and checks that it leads to a regular
+// file. Errors name the file as the request did, given.
+func resolveRegularFile(path, given string) (string, error) {
+	realPath, err := filepath.EvalSymlinks(path)
+	if os.IsNotExist(err) {
+		return "", fmt.Errorf("%w: %s does not exist", ErrInputFileRejected, given)
+	}
+	if err != nil {
+		return "", fmt.Errorf("%w: %s cannot be read", ErrInputFileRejected, given)
+	}
+	info, err := os.Stat(realPath)
+	if err != nil || !info.Mode().IsRegular() {
+		return "", fmt.Errorf("%w: %s is not a regular file", ErrInputFileRejected, given)
+	}
+	return realPath, nil
+}
+
+// insideDir reports whether path lies below dir. Both must be absolute and clean.
+func insideDir(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil || rel == "." {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// usesCsvProfile reports whether files of a format are laid out by a CSV profile.
+func usesCsvProfile(format string) bool {
+	return format == consts.FileFormatCSV || format == consts.FileFormatXLSX
+}
+
+// IsCsvFile reports whether a file at path would be read as CSV, the only format a
+// transaction file may have.
+func IsCsvFile(path string) bool {
+	return fileFormatOf(entity.ReconciliationFile{Path: path}) == consts.FileFormatCSV
+}
+
+// fileFormatOf returns the requested format of a file, or the one its extension implies.
+func fileFormatOf(file entity.ReconciliationFile) string {
+	if file.Format != "" {
+		return strings.ToLower(file.Format)
+	}
+	switch strings.ToLower(filepath.Ext(file.Path)) {
+	case ".xlsx":
+		return consts.FileFormatXLSX
+	case ".xml":
+		return consts.FileFormatCamt
+	case ".sta", ".mt940", ".940":
+		return consts.FileFormatMT940
+	}
+	return consts.FileFormatCSV
+}
+
+// csvPosition is a resumable place in a CSV file: the byte offset of the next record and
+// the line number found there. The zero value means the first data row.
+type csvPosition struct {
+	Offset int64
+	Line   int64
+}
+
+// rowReader yields the records of a CSV file or of a worksheet.
+type rowReader interface {
+	Read() ([]string, error)
+	Line() int
+	Raw() string
+}
+
+// inputFile streams the data rows of a CSV or XLSX file described by a profile.
+type inputFile struct {
+	closer io.Closer
+	reader rowReader
+	layout csvLayout
+}
+
+// openInputFile reads up to the header row and resolves the profile against it. A non-zero
+// from continues at that position instead of the first data row; only CSV files resume.
+func openInputFile(sourceFile, format string, profile entity.CsvProfile, from csvPosition) (*inputFile, error) {
+	if from.Offset > 0 && format == consts.FileFormatXLSX {
+		return nil, fmt.Errorf("%s: xlsx files cannot be resumed from an offset", sourceFile)
+	}
+
+	closer, reader, err := openRowReader(sourceFile, format, profile)
+	if err != nil {
+		return nil, err
+	}
+	header, err := readHeader(reader, profile)
+	if err != nil {
+		closer.Close()
+		return nil, fmt.Errorf("failed to read %s: %w", sourceFile, err)
+	}
+
+	layout, err := newCsvLayout(profile, header)
+	if err != nil {
+		closer.Close()
+		return nil, fmt.Errorf("%s: %w", sourceFile, err)
+	}
+
+	if from.Offset > 0 {
+		file := closer.(*os.File)
+		if _, err := file.Seek(from.Offset, io.SeekStart); err != nil {
+			closer.Close()
+			return nil, fmt.Errorf("failed to seek %s: %w", sourceFile, err)
+		}
+		delimiter, quote := csvDialect(profile)
+		reader = csvreader.NewReaderAt(file, delimiter, quote, from.Offset, int(from.Line))
+	}
+	return &inputFile{closer: closer, reader: reader, layout: layout}, nil
+}
+
+// detectHeader returns the header row of a file as the profile locates it, without
+// checking that the mapped columns are present.
+func detectHeader(sourceFile, format string, profile entity.CsvProfile) ([]string, error) {
+	closer, reader, err := openRowReader(sourceFile, format, profile)
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+
+	header, err := readHeader(reader, profile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", sourceFile, err)
+	}
+	return header, nil
+}
+
+func openRowReader(sourceFile, format string, profile entity.CsvProfile) (io.Closer, rowReader, error) {
+	if format == consts.FileFormatXLSX {
+		workbook, err := xlsx.Open(sourceFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open workbook %s: %w", sourceFile, err)
+		}
+		layout := profile.DateLayout
+		if layout == "" {
+			layout = defaultCsvProfile(profile.DataType).DateLayout
+		}
+		sheet, err := workbook.OpenSheet(profile.Sheet, layout)
+		if err != nil {
+			workbook.Close()
+			return nil, nil, fmt.Errorf("%s: %w", sourceFile, err)
+		}
+		return sheetCloser{sheet: sheet, workbook: workbook}, sheet, nil
+	}
+
+	file, err := os.Open(sourceFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open file %s: %w", sourceFile, err)
+	}
+	delimiter, quote := csvDialect(profile)
+	return file, csvreader.NewReader(file, delimiter, quote), nil
+}
+
+// sheetCloser closes a worksheet stream together with its workbook.
+type sheetCloser struct {
+	sheet    *xlsx.SheetReader
+	workbook *xlsx.Workbook
+}
+
+func (c sheetCloser) Close() error {
+	c.sheet.Close()
+	return c.workbook.Close()
+}
+
+func csvDialect(profile entity.CsvProfile) (delimiter, quote rune) {
+	delimiter, quote = ',', '"'
+	if profile.Delimiter != "" {
+		delimiter, _ = utf8.DecodeRuneInString(profile.Delimiter)
+	}
+	if profile.QuoteChar != "" {
+		quote, _ = utf8.DecodeRuneInString(profile.QuoteChar)
+	}
+	return delimiter, quote
+}
+
+// readHeader skips the profile's leading rows and returns the header row, or nil for a
+// file that ends first.
+func readHeader(reader rowReader, profile entity.CsvProfile) ([]string, error) {
+	var header []string
+	for i := 0; i <= profile.SkipRows; i++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		header = record
+	}
+	return header, nil
+}
+
+// next returns the next data row and the line it starts on, or io.EOF.
+func (f *inputFile) next() ([]string, int, error) {
+	record, err := f.reader.Read()
+	if err != nil {
+		return nil, 0, err
+	}
+	return record, f.reader.Line(), nil
+}
+
+// raw is the text of the row last returned by next, as it appears in the file.
+func (f *inputFile) raw() string {
+	return f.reader.Raw()
+}
+
+// position is where reading would continue after the last row returned by next. Only
+// CSV files have one.
+func (f *inputFile) position() csvPosition {
+	if r, ok := f.reader.(*csvreader.Reader); ok {
+		return csvPosition{Offset: r.Offset(), Line: int64(r.NextLine())}
+	}
+	return csvPosition{}
+}
+
+func (f *inputFile) close() {
+	f.closer.Close()
+}
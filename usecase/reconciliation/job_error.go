@@ -0,0 +1,71 @@
+package reconciliation
+
+import (
+	"errors"
+	"time"
+
+	"github.com/labstack/gommon/log"
+	"github.com/radhian/reconciliation-system/consts"
+)
+
+// jobError classifies a failure of a reconciliation job. A permanent failure comes from
+// the job's own inputs and would happen again, so the job is failed at once; a retryable
+// one, such as a database error, leaves the job to be picked up again until it has used
+// up its attempts.
+type jobError struct {
+	code      string
+	retryable bool
+	err       error
+}
+
+func (e *jobError) Error() string {
+	return e.err.Error()
+}
+
+func (e *jobError) Unwrap() error {
+	return e.err
+}
+
+func permanentError(code string, err error) error {
+	return &jobError{code: code, err: err}
+}
+
+func retryableError(err error) error {
+	return &jobError{code: consts.JobErrorStorage, retryable: true, err: err}
+}
+
+// failJob records err on the job. The job is failed when the error is permanent or it has
+// run out of attempts; otherwise it keeps its status so a worker retries it. Errors that
+// were not classified are treated as permanent. Nothing is recorded on a job the worker
+// has lost.
+func (u *reconciliationUsecase) failJob(logID int64, err error) {
+	var jobErr *jobError
+	if !errors.As(err, &jobErr) {
+		jobErr = &jobError{code: consts.JobErrorInternal, err: err}
+	}
+
+	logEntry, fetchErr := u.fetchProcessLog(logID)
+	if fetchErr != nil {
+		log.Errorf("[ReconcileJob] Could not record failure of LogID %d: %v", logID, fetchErr)
+		return
+	}
+
+	logEntry.ErrorCode = jobErr.code
+	logEntry.ErrorMessage = jobErr.err.Error()
+	logEntry.FailedAttempts++
+	if !jobErr.retryable || logEntry.FailedAttempts >= consts.MaxJobAttempts {
+		logEntry.Status = consts.StatusFailed
+	}
+	logEntry.UpdateTime = time.Now().Unix()
+	logEntry.UpdateBy = "system"
+
+	if updateErr := u.saveProcessLog(u.dao, logEntry); updateErr != nil {
+		log.Errorf("[ReconcileJob] Could not record failure of LogID %d: %v", logID, updateErr)
+		return
+	}
+	if logEntry.Status == consts.StatusFailed {
+		log.Errorf("[ReconcileJob] LogID %d failed (%s): %v", logID, jobErr.code, jobErr.err)
+	} else {
+		log.Errorf("[ReconcileJob] LogID %d will be retried after attempt %d (%s): %v", logID, logEntry.FailedAttempts, jobErr.code, jobErr.err)
+	}
+}
@@ -0,0 +1,71 @@
+package reconciliation
+
+import (
+	"sort"
+
+	"github.com/radhian/reconciliation-system/infra/db/model"
+)
+
+// scheduleJobs orders the unfinished jobs no worker is on for a worker to try in turn.
+// Higher priorities come first. Within a priority, operators take turns one job at a time,
+// starting with the operator after lastOperator, so one operator's backlog cannot hold up
+// everyone else's; each operator's own jobs stay oldest first. Operators already running
+// maxPerOperator jobs are left out, 0 meaning no limit. logs must be ordered by priority,
+// highest first, then by create time.
+func scheduleJobs(logs []model.ReconciliationProcessLog, lastOperator string, maxPerOperator int) []model.ReconciliationProcessLog {
+	running := make(map[string]int)
+	for _, l := range logs {
+		if l.WorkerID != "" {
+			running[l.CreateBy]++
+		}
+	}
+
+	var scheduled []model.ReconciliationProcessLog
+	for start := 0; start < len(logs); {
+		end := start
+		for end < len(logs) && logs[end].Priority == logs[start].Priority {
+			end++
+		}
+		scheduled = append(scheduled, takeTurns(logs[start:end], running, lastOperator, maxPerOperator)...)
+		start = end
+	}
+	return scheduled
+}
+
+// takeTurns interleaves the waiting jobs of one priority by operator.
+func takeTurns(logs []model.ReconciliationProcessLog, running map[string]int, lastOperator string, maxPerOperator int) []model.ReconciliationProcessLog {
+	waiting := make(map[string][]model.ReconciliationProcessLog)
+	var operators []string
+	for _, l := range logs {
+		if l.WorkerID != "" {
+			continue
+		}
+		if maxPerOperator > 0 && running[l.CreateBy] >= maxPerOperator {
+			continue
+		}
+		if _, ok := waiting[l.CreateBy]; !ok {
+			operators = append(operators, l.CreateBy)
+		}
+		waiting[l.CreateBy] = append(waiting[l.CreateBy], l)
+	}
+
+	// Operators are visited in name order, wrapping around after lastOperator.
+	sort.Strings(operators)
+	next := sort.Search(len(operators), func(i int) bool { return operators[i] > lastOperator })
+	operators = append(operators[next:], operators[:next]...)
+
+	var turns []model.ReconciliationProcessLog
+	for round := 0; len(turns) < len(logs); round++ {
+		taken := false
+		for _, operator := range operators {
+			if round < len(waiting[operator]) {
+				turns = append(turns, waiting[operator][round])
+				taken = true
+			}
+		}
+		if !taken {
+			break
+		}
+	}
+	return turns
+}
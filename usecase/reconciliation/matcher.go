@@ -0,0 +1,559 @@
+package reconciliation
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/radhian/reconciliation-system/consts"
+	"github.com/radhian/reconciliation-system/entity"
+	"github.com/radhian/reconciliation-system/infra/db/dao"
+)
+
+// Matcher is a single matching rule. Rules run as an ordered chain in which every rule
+// only sees the transactions and bank rows left unmatched by the rules before it.
+// BankRowFilter selects every staged bank row the rule could pair with one of systemTxs,
+// so a batch only loads those rows.
+type Matcher interface {
+	Name() string
+	Match(systemTxs []entity.Transaction, bankTxs []entity.BankStatement) (matches []matchedPair, unmatchedSys []entity.Transaction, unmatchedBank []entity.BankStatement)
+	BankRowFilter(systemTxs []entity.Transaction) dao.BankRowFilter
+}
+
+// matchedPair links a system transaction to the bank row that cleared it.
+type matchedPair struct {
+	System entity.Transaction
+	Bank   entity.BankStatement
+	Rule   string
+	// System amount in the bank currency, set for pairs matched across currencies
+	ConvertedAmount entity.Amount
+}
+
+// amountDifference is the bank amount minus the system amount, ignoring direction and
+// comparing in the bank currency for cross-currency pairs.
+func (m matchedPair) amountDifference() entity.Amount {
+	if m.Rule == consts.MatchRuleFxTolerance {
+		return m.Bank.Amount.Abs() - m.ConvertedAmount
+	}
+	return signedAmountDifference(m.System, m.Bank)
+}
+
+// ValidateMatchRules reports an error for any rule name the matcher chain does not know
+// or for a reference extraction it cannot apply.
+func ValidateMatchRules(rules []string, extraction *entity.ReferenceExtraction) error {
+	if _, err := newMatcherChain(entity.ProcessMetadata{MatchRules: rules}, nil); err != nil {
+		return err
+	}
+	_, err := newReferenceExtractor(extraction)
+	return err
+}
+
+// newMatcherChain builds the rule chain recorded in the job metadata, falling back to
+// date-proximity matching for jobs created without an explicit chain. The FX rates are
+// only used by the fx_tolerance rule.
+func newMatcherChain(metadata entity.ProcessMetadata, rates fxRateTable) ([]Matcher, error) {
+	rules := metadata.MatchRules
+	if len(rules) == 0 {
+		rules = []string{consts.MatchRuleAmountDateWindow}
+	}
+
+	chain := make([]Matcher, 0, len(rules))
+	seen := make(map[string]bool, len(rules))
+	for _, name := range rules {
+		if seen[name] {
+			return nil, fmt.Errorf("match rule %q is listed more than once", name)
+		}
+		seen[name] = true
+
+		switch name {
+		case consts.MatchRuleReferenceID:
+			extractor, err := newReferenceExtractor(metadata.ReferenceExtraction)
+			if err != nil {
+				return nil, err
+			}
+			chain = append(chain, referenceIDMatcher{extractor: extractor})
+		case consts.MatchRuleAmountType:
+			chain = append(chain, amountTypeMatcher{})
+		case consts.MatchRuleAmountDateWindow:
+			chain = append(chain, amountDateWindowMatcher{maxDateLagDays: metadata.MaxDateLagDays})
+		case consts.MatchRuleAmountTolerance:
+			chain = append(chain, newAmountToleranceMatcher(metadata))
+		case consts.MatchRuleFxTolerance:
+			chain = append(chain, newFxToleranceMatcher(metadata, rates))
+		default:
+			return nil, fmt.Errorf("unknown match rule %q", name)
+		}
+	}
+	return chain, nil
+}
+
+// runMatcherChain applies every rule in order, feeding each one the leftovers of the previous.
+func runMatcherChain(
+	chain []Matcher,
+	systemTxs []entity.Transaction,
+	bankTxs []entity.BankStatement,
+) (matches []matchedPair, unmatchedSys []entity.Transaction, unmatchedBank []entity.BankStatement) {
+	unmatchedSys, unmatchedBank = systemTxs, bankTxs
+	for _, matcher := range chain {
+		if len(unmatchedSys) == 0 || len(unmatchedBank) == 0 {
+			break
+		}
+		var ruleMatches []matchedPair
+		ruleMatches, unmatchedSys, unmatchedBank = matcher.Match(unmatchedSys, unmatchedBank)
+		matches = append(matches, ruleMatches...)
+	}
+	return matches, unmatchedSys, unmatchedBank
+}
+
+// matchInFileOrder pairs every system transaction, in file order, with the best unused
+// bank row among its candidates. Candidates are bank indexes in ascending order; accept
+// filters them and better reports whether a should be preferred over b, so the earlier
+// bank row wins ties. Because each transaction only depends on the ones before it,
+// running this batch by batch gives the same pairs as a single pass over the file.
+func matchInFileOrder(
+	rule string,
+	systemTxs []entity.Transaction,
+	bankTxs []entity.BankStatement,
+	candidates func(trx entity.Transaction) []int,
+	accept func(trx entity.Transaction, b entity.BankStatement) bool,
+	better func(trx entity.Transaction, a, b entity.BankStatement) bool,
+) (matches []matchedPair, unmatchedSys []entity.Transaction, unmatchedBank []entity.BankStatement) {
+	used := make([]bool, len(bankTxs))
+	for _, trx := range systemTxs {
+		best := -1
+		for _, i := range candidates(trx) {
+			if used[i] || (accept != nil && !accept(trx, bankTxs[i])) {
+				continue
+			}
+			if best == -1 || (better != nil && better(trx, bankTxs[i], bankTxs[best])) {
+				best = i
+			}
+			if better == nil {
+				break
+			}
+		}
+
+		if best == -1 {
+			unmatchedSys = append(unmatchedSys, trx)
+			continue
+		}
+		used[best] = true
+		matches = append(matches, matchedPair{System: trx, Bank: bankTxs[best], Rule: rule})
+	}
+
+	for i, b := range bankTxs {
+		if !used[i] {
+			unmatchedBank = append(unmatchedBank, b)
+		}
+	}
+	return matches, unmatchedSys, unmatchedBank
+}
+
+// indexBankStatements groups bank row indexes by key, keeping file order inside a group.
+func indexBankStatements(bankTxs []entity.BankStatement, key func(b entity.BankStatement) string) map[string][]int {
+	index := make(map[string][]int)
+	for i, b := range bankTxs {
+		k := key(b)
+		index[k] = append(index[k], i)
+	}
+	return index
+}
+
+// referenceIDMatcher pairs a transaction with the bank row carrying its TrxID as the
+// extracted reference. Amounts are not compared; see recordMatches.
+type referenceIDMatcher struct {
+	extractor referenceExtractor
+}
+
+func (referenceIDMatcher) Name() string { return consts.MatchRuleReferenceID }
+
+func (referenceIDMatcher) BankRowFilter(systemTxs []entity.Transaction) dao.BankRowFilter {
+	var filter dao.BankRowFilter
+	for _, trx := range systemTxs {
+		if trx.TrxID != "" {
+			filter.References = append(filter.References, trx.TrxID)
+		}
+	}
+	return filter
+}
+
+func (m referenceIDMatcher) Match(systemTxs []entity.Transaction, bankTxs []entity.BankStatement) ([]matchedPair, []entity.Transaction, []entity.BankStatement) {
+	index := indexBankStatements(bankTxs, m.extractor.extract)
+	return matchInFileOrder(m.Name(), systemTxs, bankTxs,
+		func(trx entity.Transaction) []int {
+			if trx.TrxID == "" {
+				return nil
+			}
+			return index[trx.TrxID]
+		},
+		nil, nil)
+}
+
+// referenceExtractor reads the reference from a bank row field, optionally narrowed by a
+// regular expression.
+type referenceExtractor struct {
+	field   string
+	pattern *regexp.Regexp
+}
+
+func newReferenceExtractor(cfg *entity.ReferenceExtraction) (referenceExtractor, error) {
+	extractor := referenceExtractor{field: consts.ReferenceFieldUniqueIdentifier}
+	if cfg == nil {
+		return extractor, nil
+	}
+
+	switch cfg.Field {
+	case "", consts.ReferenceFieldUniqueIdentifier:
+	case consts.ReferenceFieldDescription:
+		extractor.field = consts.ReferenceFieldDescription
+	default:
+		return extractor, fmt.Errorf("unknown reference field %q", cfg.Field)
+	}
+
+	if cfg.Pattern != "" {
+		pattern, err := regexp.Compile(cfg.Pattern)
+		if err != nil {
+			return extractor, fmt.Errorf("invalid reference pattern: %v", err)
+		}
+		extractor.pattern = pattern
+	}
+	return extractor, nil
+}
+
+func (e referenceExtractor) extract(b entity.BankStatement) string {
+	value := b.UniqueIdentifier
+	if e.field == consts.ReferenceFieldDescription {
+		value = b.Description
+	}
+	if e.pattern == nil {
+		return strings.TrimSpace(value)
+	}
+
+	match := e.pattern.FindStringSubmatch(value)
+	if match == nil {
+		return ""
+	}
+	if len(match) > 1 {
+		return match[1]
+	}
+	return match[0]
+}
+
+// amountTypeMatcher pairs transactions and bank rows sharing a type|currency|amount key in file order.
+type amountTypeMatcher struct{}
+
+func (amountTypeMatcher) Name() string { return consts.MatchRuleAmountType }
+
+func (amountTypeMatcher) BankRowFilter(systemTxs []entity.Transaction) dao.BankRowFilter {
+	return matchKeyFilter(systemTxs)
+}
+
+func (m amountTypeMatcher) Match(systemTxs []entity.Transaction, bankTxs []entity.BankStatement) ([]matchedPair, []entity.Transaction, []entity.BankStatement) {
+	index := indexBankStatements(bankTxs, bankStatementKey)
+	return matchInFileOrder(m.Name(), systemTxs, bankTxs,
+		func(trx entity.Transaction) []int { return index[transactionKey(trx)] },
+		nil, nil)
+}
+
+// amountDateWindowMatcher pairs transactions and bank rows sharing a type|currency|amount key,
+// taking the bank row with the closest date. Bank rows further than maxDateLagDays
+// away are never paired, unless it is consts.NoDateLagLimit.
+type amountDateWindowMatcher struct {
+	maxDateLagDays int64
+}
+
+func (amountDateWindowMatcher) Name() string { return consts.MatchRuleAmountDateWindow }
+
+func (amountDateWindowMatcher) BankRowFilter(systemTxs []entity.Transaction) dao.BankRowFilter {
+	return matchKeyFilter(systemTxs)
+}
+
+func (m amountDateWindowMatcher) Match(systemTxs []entity.Transaction, bankTxs []entity.BankStatement) ([]matchedPair, []entity.Transaction, []entity.BankStatement) {
+	index := indexBankStatements(bankTxs, bankStatementKey)
+	return matchInFileOrder(m.Name(), systemTxs, bankTxs,
+		func(trx entity.Transaction) []int { return index[transactionKey(trx)] },
+		func(trx entity.Transaction, b entity.BankStatement) bool {
+			return m.maxDateLagDays == consts.NoDateLagLimit || dateLagInDays(trx, b) <= m.maxDateLagDays
+		},
+		func(trx entity.Transaction, a, b entity.BankStatement) bool {
+			return dateLagInDays(trx, a) < dateLagInDays(trx, b)
+		})
+}
+
+// amountToleranceMatcher pairs transactions and bank rows of the same type and currency whose amounts
+// differ by at most the absolute tolerance or by at most the percentage of the system
+// amount, whichever is larger, preferring the smallest difference and then the closest date.
+type amountToleranceMatcher struct {
+	tolerance        entity.Amount
+	tolerancePercent entity.Amount
+}
+
+func newAmountToleranceMatcher(metadata entity.ProcessMetadata) amountToleranceMatcher {
+	if metadata.AmountTolerance == 0 && metadata.AmountTolerancePercent == 0 {
+		return amountToleranceMatcher{tolerance: entity.MustParseAmount(consts.DefaultAmountTolerance)}
+	}
+	return amountToleranceMatcher{
+		tolerance:        metadata.AmountTolerance,
+		tolerancePercent: metadata.AmountTolerancePercent,
+	}
+}
+
+func (m amountToleranceMatcher) toleranceFor(trx entity.Transaction) entity.Amount {
+	return entity.MaxAmount(m.tolerance, percentOf(trx.Amount.Abs(), m.tolerancePercent))
+}
+
+func (amountToleranceMatcher) Name() string { return consts.MatchRuleAmountTolerance }
+
+func (m amountToleranceMatcher) BankRowFilter(systemTxs []entity.Transaction) dao.BankRowFilter {
+	var filter dao.BankRowFilter
+	for _, trx := range systemTxs {
+		tolerance := m.toleranceFor(trx)
+		filter.Ranges = append(filter.Ranges, dao.BankRowRange{
+			GroupKey: transactionCurrencyKey(trx),
+			Min:      trx.Amount - tolerance,
+			Max:      trx.Amount + tolerance,
+		})
+	}
+	return filter
+}
+
+func (m amountToleranceMatcher) Match(systemTxs []entity.Transaction, bankTxs []entity.BankStatement) ([]matchedPair, []entity.Transaction, []entity.BankStatement) {
+	index := indexBankStatementsByAmount(bankTxs, bankStatementCurrencyKey)
+
+	return matchInFileOrder(m.Name(), systemTxs, bankTxs,
+		func(trx entity.Transaction) []int {
+			list := index[transactionCurrencyKey(trx)]
+			tolerance := m.toleranceFor(trx)
+			window := amountWindow(bankTxs, list, trx.Amount-tolerance, trx.Amount+tolerance)
+			sort.Ints(window)
+			return window
+		},
+		func(trx entity.Transaction, b entity.BankStatement) bool {
+			return amountDifference(trx, b) <= m.toleranceFor(trx)
+		},
+		func(trx entity.Transaction, a, b entity.BankStatement) bool {
+			diffA, diffB := amountDifference(trx, a), amountDifference(trx, b)
+			if diffA != diffB {
+				return diffA < diffB
+			}
+			return dateLagInDays(trx, a) < dateLagInDays(trx, b)
+		})
+}
+
+// fxToleranceMatcher pairs transactions and bank rows of the same type in different
+// currencies. The system amount is converted into the bank currency with the uploaded
+// rates, and the pair is accepted when the converted amount is within the tolerance,
+// preferring the smallest difference and then the closest date.
+type fxToleranceMatcher struct {
+	rates            fxRateTable
+	tolerance        entity.Amount
+	tolerancePercent entity.Amount
+}
+
+func newFxToleranceMatcher(metadata entity.ProcessMetadata, rates fxRateTable) fxToleranceMatcher {
+	m := fxToleranceMatcher{
+		rates:            rates,
+		tolerance:        metadata.AmountTolerance,
+		tolerancePercent: metadata.AmountTolerancePercent,
+	}
+	if m.tolerance == 0 && m.tolerancePercent == 0 {
+		m.tolerancePercent = entity.MustParseAmount(consts.DefaultFxTolerancePercent)
+	}
+	return m
+}
+
+func (fxToleranceMatcher) Name() string { return consts.MatchRuleFxTolerance }
+
+func (m fxToleranceMatcher) BankRowFilter(systemTxs []entity.Transaction) dao.BankRowFilter {
+	var filter dao.BankRowFilter
+	for _, trx := range systemTxs {
+		for _, currency := range m.rates.targets(trx.Currency) {
+			converted, _ := m.convert(trx, currency)
+			tolerance := m.toleranceFor(converted)
+			filter.Ranges = append(filter.Ranges, dao.BankRowRange{
+				GroupKey: fmt.Sprintf("%s|%s", transactionTypeCode(trx), currency),
+				Min:      converted - tolerance,
+				Max:      converted + tolerance,
+			})
+		}
+	}
+	return filter
+}
+
+func (m fxToleranceMatcher) convert(trx entity.Transaction, currency string) (entity.Amount, bool) {
+	if trx.Currency == "" || currency == "" || trx.Currency == currency {
+		return 0, false
+	}
+	rate, ok := m.rates.rate(trx.Currency, currency)
+	if !ok {
+		return 0, false
+	}
+	converted, err := trx.Amount.MulRate(rate)
+	if err != nil {
+		// No bank row can hold an amount that does not fit in an Amount.
+		return 0, false
+	}
+	return converted, true
+}
+
+func (m fxToleranceMatcher) toleranceFor(converted entity.Amount) entity.Amount {
+	return entity.MaxAmount(m.tolerance, percentOf(converted.Abs(), m.tolerancePercent))
+}
+
+// percentOf returns percent of a non-negative amount. Requests cap the percentage at 100,
+// so the result never exceeds the amount, which is also the bound it falls back to should
+// the product not fit.
+func percentOf(amount, percent entity.Amount) entity.Amount {
+	value, err := amount.MulPercent(percent)
+	if err != nil {
+		return amount
+	}
+	return value
+}
+
+// difference is the distance between the bank amount and the converted system amount.
+func (m fxToleranceMatcher) difference(trx entity.Transaction, b entity.BankStatement) entity.Amount {
+	converted, _ := m.convert(trx, b.Currency)
+	return (b.Amount.Abs() - converted).Abs()
+}
+
+func (m fxToleranceMatcher) Match(systemTxs []entity.Transaction, bankTxs []entity.BankStatement) ([]matchedPair, []entity.Transaction, []entity.BankStatement) {
+	index := indexBankStatementsByAmount(bankTxs, bankStatementCurrencyKey)
+
+	matches, unmatchedSys, unmatchedBank := matchInFileOrder(m.Name(), systemTxs, bankTxs,
+		func(trx entity.Transaction) []int {
+			var window []int
+			for _, currency := range m.rates.targets(trx.Currency) {
+				converted, _ := m.convert(trx, currency)
+				tolerance := m.toleranceFor(converted)
+				list := index[fmt.Sprintf("%s|%s", transactionTypeCode(trx), currency)]
+				window = append(window, amountWindow(bankTxs, list, converted-tolerance, converted+tolerance)...)
+			}
+			sort.Ints(window)
+			return window
+		},
+		func(trx entity.Transaction, b entity.BankStatement) bool {
+			converted, ok := m.convert(trx, b.Currency)
+			return ok && (b.Amount.Abs()-converted).Abs() <= m.toleranceFor(converted)
+		},
+		func(trx entity.Transaction, a, b entity.BankStatement) bool {
+			diffA, diffB := m.difference(trx, a), m.difference(trx, b)
+			if diffA != diffB {
+				return diffA < diffB
+			}
+			return dateLagInDays(trx, a) < dateLagInDays(trx, b)
+		})
+
+	for i := range matches {
+		matches[i].ConvertedAmount, _ = m.convert(matches[i].System, matches[i].Bank.Currency)
+	}
+	return matches, unmatchedSys, unmatchedBank
+}
+
+// matchKeyFilter selects the bank rows sharing a type|currency|amount key with any transaction.
+func matchKeyFilter(systemTxs []entity.Transaction) dao.BankRowFilter {
+	var filter dao.BankRowFilter
+	seen := make(map[string]bool, len(systemTxs))
+	for _, trx := range systemTxs {
+		key := transactionKey(trx)
+		if !seen[key] {
+			seen[key] = true
+			filter.MatchKeys = append(filter.MatchKeys, key)
+		}
+	}
+	return filter
+}
+
+// indexBankStatementsByAmount groups bank row indexes by key, sorted by absolute amount.
+func indexBankStatementsByAmount(bankTxs []entity.BankStatement, key func(b entity.BankStatement) string) map[string][]int {
+	index := indexBankStatements(bankTxs, key)
+	for _, list := range index {
+		sort.SliceStable(list, func(i, j int) bool {
+			return bankTxs[list[i]].Amount.Abs() < bankTxs[list[j]].Amount.Abs()
+		})
+	}
+	return index
+}
+
+// amountWindow narrows an amount-sorted group down to the rows whose absolute amount lies
+// within [lo, hi]. Callers sort the result back into file order so ties resolve to the
+// earlier row.
+func amountWindow(bankTxs []entity.BankStatement, list []int, lo, hi entity.Amount) []int {
+	from := sort.Search(len(list), func(i int) bool { return bankTxs[list[i]].Amount.Abs() >= lo })
+	to := sort.Search(len(list), func(i int) bool { return bankTxs[list[i]].Amount.Abs() > hi })
+	if from >= to {
+		return nil
+	}
+	return append([]int(nil), list[from:to]...)
+}
+
+func transactionTypeCode(trx entity.Transaction) string {
+	if trx.Type == "CREDIT" {
+		return "c"
+	} else if trx.Type == "DEBIT" {
+		return "d"
+	}
+	return "u"
+}
+
+// bankStatementTypeCode follows the direction the statement states, falling back to the
+// sign of the amount for rows parsed without one.
+func bankStatementTypeCode(b entity.BankStatement) string {
+	switch b.Direction {
+	case consts.DirectionDebit:
+		return "d"
+	case consts.DirectionCredit:
+		return "c"
+	}
+	if b.Amount < 0 {
+		return "d"
+	}
+	return "c"
+}
+
+func transactionCurrencyKey(trx entity.Transaction) string {
+	return fmt.Sprintf("%s|%s", transactionTypeCode(trx), trx.Currency)
+}
+
+func bankStatementCurrencyKey(b entity.BankStatement) string {
+	return fmt.Sprintf("%s|%s", bankStatementTypeCode(b), b.Currency)
+}
+
+func transactionKey(trx entity.Transaction) string {
+	return fmt.Sprintf("%s|%s", transactionCurrencyKey(trx), trx.Amount)
+}
+
+func bankStatementKey(b entity.BankStatement) string {
+	return fmt.Sprintf("%s|%s", bankStatementCurrencyKey(b), b.Amount.Abs())
+}
+
+// signedAmountDifference is the bank amount minus the system amount, ignoring direction.
+func signedAmountDifference(trx entity.Transaction, b entity.BankStatement) entity.Amount {
+	return b.Amount.Abs() - trx.Amount
+}
+
+func amountDifference(trx entity.Transaction, b entity.BankStatement) entity.Amount {
+	return signedAmountDifference(trx, b).Abs()
+}
+
+func dateLagInDays(trx entity.Transaction, b entity.BankStatement) int64 {
+	return absInt64(dateDifferenceInDays(trx.TransactionTime, b.Date))
+}
+
+// dateDifferenceInDays returns the calendar day distance from the system transaction
+// date to the bank statement date. The parser puts transaction times in the job's time
+// zone, so the transaction date is the business day it falls on.
+func dateDifferenceInDays(trxTime, bankDate time.Time) int64 {
+	trxDate := time.Date(trxTime.Year(), trxTime.Month(), trxTime.Day(), 0, 0, 0, 0, time.UTC)
+	bankDay := time.Date(bankDate.Year(), bankDate.Month(), bankDate.Day(), 0, 0, 0, 0, time.UTC)
+	return int64(bankDay.Sub(trxDate).Hours() / 24)
+}
+
+func absInt64(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,413 @@
+package reconciliation
+
+import (
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/radhian/reconciliation-system/consts"
+	"github.com/radhian/reconciliation-system/entity"
+	"github.com/radhian/reconciliation-system/infra/db/dao"
+)
+
+var testDay = time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+
+// systemTx is a transaction at 09:00 on the given day after testDay.
+func systemTx(id, amount, trxType string, day int, currency string) entity.Transaction {
+	return entity.Transaction{
+		TrxID:           id,
+		Amount:          entity.MustParseAmount(amount),
+		Type:            trxType,
+		TransactionTime: testDay.AddDate(0, 0, day).Add(9 * time.Hour),
+		Currency:        currency,
+	}
+}
+
+// bankRow is a bank row on the given day after testDay whose direction is the sign of amount.
+func bankRow(id, amount string, day int, currency string) entity.BankStatement {
+	return entity.BankStatement{
+		UniqueIdentifier: id,
+		Amount:           entity.MustParseAmount(amount),
+		Date:             testDay.AddDate(0, 0, day),
+		Currency:         currency,
+	}
+}
+
+// pairIDs lists matches as "trxID=bankID".
+func pairIDs(matches []matchedPair) []string {
+	ids := []string{}
+	for _, m := range matches {
+		ids = append(ids, m.System.TrxID+"="+m.Bank.UniqueIdentifier)
+	}
+	return ids
+}
+
+func systemIDs(txs []entity.Transaction) []string {
+	ids := []string{}
+	for _, trx := range txs {
+		ids = append(ids, trx.TrxID)
+	}
+	return ids
+}
+
+func bankIDs(rows []entity.BankStatement) []string {
+	ids := []string{}
+	for _, b := range rows {
+		ids = append(ids, b.UniqueIdentifier)
+	}
+	return ids
+}
+
+func checkMatch(t *testing.T, name string, matcher Matcher, systemTxs []entity.Transaction, bankTxs []entity.BankStatement, wantPairs, wantSys, wantBank []string) {
+	t.Helper()
+	matches, unmatchedSys, unmatchedBank := matcher.Match(systemTxs, bankTxs)
+	if got := pairIDs(matches); !reflect.DeepEqual(got, wantPairs) {
+		t.Errorf("%s: matches = %v, want %v", name, got, wantPairs)
+	}
+	if got := systemIDs(unmatchedSys); !reflect.DeepEqual(got, wantSys) {
+		t.Errorf("%s: unmatched system = %v, want %v", name, got, wantSys)
+	}
+	if got := bankIDs(unmatchedBank); !reflect.DeepEqual(got, wantBank) {
+		t.Errorf("%s: unmatched bank = %v, want %v", name, got, wantBank)
+	}
+	for _, m := range matches {
+		if m.Rule != matcher.Name() {
+			t.Errorf("%s: pair %s=%s has rule %q, want %q", name, m.System.TrxID, m.Bank.UniqueIdentifier, m.Rule, matcher.Name())
+		}
+	}
+}
+
+func TestNewMatcherChain(t *testing.T) {
+	tests := []struct {
+		metadata entity.ProcessMetadata
+		want     []string
+		wantErr  string
+	}{
+		{want: []string{consts.MatchRuleAmountDateWindow}},
+		{
+			metadata: entity.ProcessMetadata{MatchRules: []string{consts.MatchRuleReferenceID, consts.MatchRuleAmountTolerance, consts.MatchRuleFxTolerance}},
+			want:     []string{consts.MatchRuleReferenceID, consts.MatchRuleAmountTolerance, consts.MatchRuleFxTolerance},
+		},
+		{
+			metadata: entity.ProcessMetadata{MatchRules: []string{consts.MatchRuleAmountType, consts.MatchRuleAmountType}},
+			wantErr:  "more than once",
+		},
+		{
+			metadata: entity.ProcessMetadata{MatchRules: []string{"closest_amount"}},
+			wantErr:  "unknown match rule",
+		},
+		{
+			metadata: entity.ProcessMetadata{
+				MatchRules:          []string{consts.MatchRuleReferenceID},
+				ReferenceExtraction: &entity.ReferenceExtraction{Field: consts.ReferenceFieldDescription, Pattern: "("},
+			},
+			wantErr: "invalid reference pattern",
+		},
+	}
+	for _, tt := range tests {
+		chain, err := newMatcherChain(tt.metadata, nil)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("newMatcherChain(%v) error = %v, want %q", tt.metadata.MatchRules, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("newMatcherChain(%v): %v", tt.metadata.MatchRules, err)
+			continue
+		}
+		var names []string
+		for _, matcher := range chain {
+			names = append(names, matcher.Name())
+		}
+		if !reflect.DeepEqual(names, tt.want) {
+			t.Errorf("newMatcherChain(%v) = %v, want %v", tt.metadata.MatchRules, names, tt.want)
+		}
+	}
+}
+
+// recordingMatcher matches nothing and keeps what the chain handed it.
+type recordingMatcher struct {
+	calls    int
+	sysSeen  []string
+	bankSeen []string
+}
+
+func (m *recordingMatcher) Name() string { return "recording" }
+
+func (m *recordingMatcher) BankRowFilter(systemTxs []entity.Transaction) dao.BankRowFilter {
+	return dao.BankRowFilter{}
+}
+
+func (m *recordingMatcher) Match(systemTxs []entity.Transaction, bankTxs []entity.BankStatement) ([]matchedPair, []entity.Transaction, []entity.BankStatement) {
+	m.calls++
+	m.sysSeen, m.bankSeen = systemIDs(systemTxs), bankIDs(bankTxs)
+	return nil, systemTxs, bankTxs
+}
+
+func TestRunMatcherChain(t *testing.T) {
+	reference, err := newReferenceExtractor(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	systemTxs := []entity.Transaction{
+		systemTx("T1", "100", "CREDIT", 0, "IDR"),
+		systemTx("T2", "200", "CREDIT", 0, "IDR"),
+		systemTx("T3", "300", "DEBIT", 0, "IDR"),
+		systemTx("T4", "400", "CREDIT", 0, "IDR"),
+	}
+	bankTxs := []entity.BankStatement{
+		bankRow("B1", "200", 0, "IDR"),
+		bankRow("T1", "150", 0, "IDR"),
+		bankRow("B3", "-300", 0, "IDR"),
+		bankRow("B4", "100", 0, "IDR"),
+	}
+
+	last := &recordingMatcher{}
+	chain := []Matcher{referenceIDMatcher{extractor: reference}, amountTypeMatcher{}, last}
+	matches, unmatchedSys, unmatchedBank := runMatcherChain(chain, systemTxs, bankTxs)
+
+	// The reference rule takes T1 whatever its amount, so the 100 row is left over.
+	if got, want := pairIDs(matches), []string{"T1=T1", "T2=B1", "T3=B3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("matches = %v, want %v", got, want)
+	}
+	var rules []string
+	for _, m := range matches {
+		rules = append(rules, m.Rule)
+	}
+	if want := []string{consts.MatchRuleReferenceID, consts.MatchRuleAmountType, consts.MatchRuleAmountType}; !reflect.DeepEqual(rules, want) {
+		t.Errorf("rules = %v, want %v", rules, want)
+	}
+	if last.calls != 1 || !reflect.DeepEqual(last.sysSeen, []string{"T4"}) || !reflect.DeepEqual(last.bankSeen, []string{"B4"}) {
+		t.Errorf("last rule saw %v and %v in %d calls, want [T4] and [B4] once", last.sysSeen, last.bankSeen, last.calls)
+	}
+	if got := systemIDs(unmatchedSys); !reflect.DeepEqual(got, []string{"T4"}) {
+		t.Errorf("unmatched system = %v, want [T4]", got)
+	}
+	if got := bankIDs(unmatchedBank); !reflect.DeepEqual(got, []string{"B4"}) {
+		t.Errorf("unmatched bank = %v, want [B4]", got)
+	}
+
+	// A rule is not run once either side is used up.
+	skipped := &recordingMatcher{}
+	runMatcherChain([]Matcher{amountTypeMatcher{}, skipped}, systemTxs[1:3], bankTxs)
+	if skipped.calls != 0 {
+		t.Errorf("rule after every transaction was matched ran %d times", skipped.calls)
+	}
+}
+
+func TestReferenceIDMatcher(t *testing.T) {
+	extractor, err := newReferenceExtractor(&entity.ReferenceExtraction{Field: consts.ReferenceFieldDescription, Pattern: `INV-(\d+)`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	withDescription := func(b entity.BankStatement, description string) entity.BankStatement {
+		b.Description = description
+		return b
+	}
+	systemTxs := []entity.Transaction{
+		systemTx("1001", "100", "CREDIT", 0, "IDR"),
+		systemTx("1002", "200", "CREDIT", 0, "IDR"),
+		systemTx("", "300", "CREDIT", 0, "IDR"),
+	}
+	bankTxs := []entity.BankStatement{
+		withDescription(bankRow("B1", "999", 5, "USD"), "payment INV-1002"),
+		withDescription(bankRow("B2", "100", 0, "IDR"), "no reference"),
+		withDescription(bankRow("B3", "1", 0, "IDR"), "INV-1001 first"),
+		withDescription(bankRow("B4", "1", 0, "IDR"), "INV-1001 second"),
+	}
+	// Amounts, dates and currencies are not compared; the earlier of two rows wins.
+	checkMatch(t, "reference", referenceIDMatcher{extractor: extractor}, systemTxs, bankTxs,
+		[]string{"1001=B3", "1002=B1"}, []string{""}, []string{"B2", "B4"})
+}
+
+func TestAmountTypeMatcher(t *testing.T) {
+	debit := bankRow("B5", "50", 0, "IDR")
+	debit.Direction = consts.DirectionDebit
+
+	systemTxs := []entity.Transaction{
+		systemTx("T1", "100", "CREDIT", 0, "IDR"),
+		systemTx("T2", "100", "CREDIT", 0, "IDR"),
+		systemTx("T3", "100", "DEBIT", 0, "IDR"),
+		systemTx("T4", "100", "CREDIT", 0, "USD"),
+		systemTx("T5", "50", "DEBIT", 0, "IDR"),
+		systemTx("T6", "100", "CREDIT", 0, "IDR"),
+	}
+	bankTxs := []entity.BankStatement{
+		bankRow("B1", "-100", 0, "IDR"),
+		bankRow("B2", "100", 0, "IDR"),
+		bankRow("B3", "100", 30, "IDR"),
+		bankRow("B4", "100", 0, "USD"),
+		debit,
+	}
+	// Rows pair in file order whatever their date; a stated direction beats the sign.
+	checkMatch(t, "amount_type", amountTypeMatcher{}, systemTxs, bankTxs,
+		[]string{"T1=B2", "T2=B3", "T3=B1", "T4=B4", "T5=B5"}, []string{"T6"}, []string{})
+}
+
+func TestAmountDateWindowMatcher(t *testing.T) {
+	tests := []struct {
+		name      string
+		maxLag    int64
+		bankTxs   []entity.BankStatement
+		wantPairs []string
+		wantBank  []string
+	}{
+		{
+			name:   "closest date, earlier row on a tie",
+			maxLag: 3,
+			bankTxs: []entity.BankStatement{
+				bankRow("B1", "100", 2, "IDR"),
+				bankRow("B2", "100", -1, "IDR"),
+				bankRow("B3", "100", 1, "IDR"),
+			},
+			wantPairs: []string{"T1=B2"},
+			wantBank:  []string{"B1", "B3"},
+		},
+		{
+			name:      "outside the lag",
+			maxLag:    1,
+			bankTxs:   []entity.BankStatement{bankRow("B1", "100", 2, "IDR")},
+			wantPairs: []string{},
+			wantBank:  []string{"B1"},
+		},
+		{
+			name:      "same day only",
+			maxLag:    0,
+			bankTxs:   []entity.BankStatement{bankRow("B1", "100", 1, "IDR"), bankRow("B2", "100", 0, "IDR")},
+			wantPairs: []string{"T1=B2"},
+			wantBank:  []string{"B1"},
+		},
+		{
+			name:      "no lag limit",
+			maxLag:    consts.NoDateLagLimit,
+			bankTxs:   []entity.BankStatement{bankRow("B1", "100", 40, "IDR"), bankRow("B2", "100", -90, "IDR")},
+			wantPairs: []string{"T1=B1"},
+			wantBank:  []string{"B2"},
+		},
+		{
+			name:      "other amount",
+			maxLag:    consts.NoDateLagLimit,
+			bankTxs:   []entity.BankStatement{bankRow("B1", "100.01", 0, "IDR")},
+			wantPairs: []string{},
+			wantBank:  []string{"B1"},
+		},
+	}
+	for _, tt := range tests {
+		wantSys := []string{}
+		if len(tt.wantPairs) == 0 {
+			wantSys = []string{"T1"}
+		}
+		checkMatch(t, tt.name, amountDateWindowMatcher{maxDateLagDays: tt.maxLag},
+			[]entity.Transaction{systemTx("T1", "100", "CREDIT", 0, "IDR")}, tt.bankTxs,
+			tt.wantPairs, wantSys, tt.wantBank)
+	}
+}
+
+func TestAmountToleranceMatcher(t *testing.T) {
+	tests := []struct {
+		name      string
+		metadata  entity.ProcessMetadata
+		amount    string
+		bankTxs   []entity.BankStatement
+		wantPairs []string
+	}{
+		{
+			name:      "default tolerance",
+			amount:    "100",
+			bankTxs:   []entity.BankStatement{bankRow("B1", "100.02", 0, "IDR"), bankRow("B2", "99.99", 0, "IDR")},
+			wantPairs: []string{"T1=B2"},
+		},
+		{
+			name:      "smallest difference",
+			metadata:  entity.ProcessMetadata{AmountTolerance: entity.MustParseAmount("5")},
+			amount:    "100",
+			bankTxs:   []entity.BankStatement{bankRow("B1", "104", 0, "IDR"), bankRow("B2", "97", 0, "IDR"), bankRow("B3", "106", 0, "IDR")},
+			wantPairs: []string{"T1=B2"},
+		},
+		{
+			name:      "closest date on an equal difference",
+			metadata:  entity.ProcessMetadata{AmountTolerance: entity.MustParseAmount("5")},
+			amount:    "100",
+			bankTxs:   []entity.BankStatement{bankRow("B1", "103", 4, "IDR"), bankRow("B2", "97", 1, "IDR")},
+			wantPairs: []string{"T1=B2"},
+		},
+		{
+			name:      "outside the tolerance",
+			metadata:  entity.ProcessMetadata{AmountTolerance: entity.MustParseAmount("5")},
+			amount:    "100",
+			bankTxs:   []entity.BankStatement{bankRow("B1", "105.01", 0, "IDR"), bankRow("B2", "100", 0, "USD")},
+			wantPairs: []string{},
+		},
+		{
+			name:      "percentage larger than the absolute tolerance",
+			metadata:  entity.ProcessMetadata{AmountTolerance: entity.MustParseAmount("1"), AmountTolerancePercent: entity.MustParseAmount("10")},
+			amount:    "1000",
+			bankTxs:   []entity.BankStatement{bankRow("B1", "1100.01", 0, "IDR"), bankRow("B2", "1100", 0, "IDR")},
+			wantPairs: []string{"T1=B2"},
+		},
+		{
+			name:      "absolute tolerance larger than the percentage",
+			metadata:  entity.ProcessMetadata{AmountTolerance: entity.MustParseAmount("50"), AmountTolerancePercent: entity.MustParseAmount("1")},
+			amount:    "1000",
+			bankTxs:   []entity.BankStatement{bankRow("B1", "950", 0, "IDR")},
+			wantPairs: []string{"T1=B1"},
+		},
+	}
+	for _, tt := range tests {
+		matcher := newAmountToleranceMatcher(tt.metadata)
+		matches, _, _ := matcher.Match([]entity.Transaction{systemTx("T1", tt.amount, "CREDIT", 0, "IDR")}, tt.bankTxs)
+		if got := pairIDs(matches); !reflect.DeepEqual(got, tt.wantPairs) {
+			t.Errorf("%s: matches = %v, want %v", tt.name, got, tt.wantPairs)
+		}
+
+		// Every row the rule could take is selected by its filter.
+		filter := matcher.BankRowFilter([]entity.Transaction{systemTx("T1", tt.amount, "CREDIT", 0, "IDR")})
+		for _, m := range matches {
+			r := filter.Ranges[0]
+			if abs := m.Bank.Amount.Abs(); r.GroupKey != bankStatementCurrencyKey(m.Bank) || abs < r.Min || abs > r.Max {
+				t.Errorf("%s: filter %+v does not select %s", tt.name, r, m.Bank.UniqueIdentifier)
+			}
+		}
+	}
+}
+
+func TestFxToleranceMatcher(t *testing.T) {
+	rates := make(fxRateTable)
+	rates.add("USD", "IDR", big.NewRat(16000, 1))
+	// Only EUR to USD is given; USD to EUR is derived from it.
+	rates.add("EUR", "USD", big.NewRat(11, 10))
+
+	systemTxs := []entity.Transaction{
+		systemTx("T1", "100", "CREDIT", 0, "USD"),
+		systemTx("T2", "110", "DEBIT", 0, "USD"),
+		systemTx("T3", "100", "CREDIT", 0, "IDR"),
+		systemTx("T4", "100", "CREDIT", 0, "JPY"),
+	}
+	bankTxs := []entity.BankStatement{
+		bankRow("B1", "1609000", 0, "IDR"),
+		bankRow("B2", "1600500", 3, "IDR"),
+		bankRow("B3", "-100.2", 0, "EUR"),
+		bankRow("B4", "100", 0, "IDR"),
+		bankRow("B5", "100", 0, "USD"),
+	}
+	matcher := newFxToleranceMatcher(entity.ProcessMetadata{}, rates)
+	checkMatch(t, "fx", matcher, systemTxs, bankTxs,
+		[]string{"T1=B2", "T2=B3"}, []string{"T3", "T4"}, []string{"B1", "B4", "B5"})
+
+	matches, _, _ := matcher.Match(systemTxs, bankTxs)
+	wantConverted := []string{"1600000", "100"}
+	wantDifference := []string{"500", "0.2"}
+	for i, m := range matches {
+		if m.ConvertedAmount.String() != wantConverted[i] || m.amountDifference().String() != wantDifference[i] {
+			t.Errorf("pair %s=%s: converted %s, difference %s; want %s and %s", m.System.TrxID, m.Bank.UniqueIdentifier,
+				m.ConvertedAmount, m.amountDifference(), wantConverted[i], wantDifference[i])
+		}
+	}
+
+	// An absolute tolerance replaces the default percentage.
+	strict := newFxToleranceMatcher(entity.ProcessMetadata{AmountTolerance: entity.MustParseAmount("100")}, rates)
+	checkMatch(t, "fx absolute", strict, systemTxs[:1], bankTxs[:2], []string{}, []string{"T1"}, []string{"B1", "B2"})
+}
@@ -0,0 +1,149 @@
+package reconciliation
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/labstack/gommon/log"
+	"github.com/radhian/reconciliation-system/consts"
+	"github.com/radhian/reconciliation-system/entity"
+	"github.com/radhian/reconciliation-system/infra/mt940"
+)
+
+// parseMT940Statements reads the statement lines of an MT940 file the way
+// parseBankStatements reads rows: debits and reversed credits become negative amounts,
+// the entry date (or the value date when there is none) is the booking date and the
+// :86: narrative is the description. The balances of every statement are handed to
+// onBalance once the file has been read.
+func parseMT940Statements(
+	sourceFile string,
+	startTime, endTime time.Time,
+	defaultCurrency string,
+	bankDate string,
+	fn func(b entity.BankStatement) error,
+	onBalance func(entity.StatementBalance),
+	onReject rejectFunc,
+) error {
+	log.Infof("[BankParser] Reading MT940 file: %s", sourceFile)
+
+	file, err := os.Open(sourceFile)
+	if err != nil {
+		log.Infof("[BankParser] Failed to open file: %v", err)
+		return fmt.Errorf("failed to read bank statement file: %w", err)
+	}
+	defer file.Close()
+	reader := mt940.NewReader(file)
+
+	startDate, endDate := calendarDay(startTime), calendarDay(endTime)
+
+	parsed := 0
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		var lineErr *mt940.LineError
+		if errors.As(err, &lineErr) {
+			log.Infof("[BankParser] Skipping line %d: %v", lineErr.Line, lineErr.Err)
+			onReject.add(lineErr.Line, lineErr.Raw, lineErr.Err.Error())
+			continue
+		}
+		if err != nil {
+			log.Infof("[BankParser] Failed to read MT940: %v", err)
+			return fmt.Errorf("failed to read bank statement file %s: %w", sourceFile, err)
+		}
+
+		currency := entity.NormalizeCurrency(record.Currency)
+		if currency == "" {
+			currency = entity.NormalizeCurrency(defaultCurrency)
+		}
+		amount, err := entity.ParseAmount(record.Amount, entity.CurrencyFractionDigits(currency))
+		if err != nil {
+			log.Infof("[BankParser] Skipping line %d: %v", record.Line, err)
+			onReject.add(record.Line, record.Raw, err.Error())
+			continue
+		}
+		direction := consts.DirectionCredit
+		if record.Mark == "D" || record.Mark == "RC" {
+			amount, direction = -amount, consts.DirectionDebit
+		}
+
+		valueDate := record.ValueDate
+		booking := record.EntryDate
+		if booking.IsZero() {
+			booking = valueDate
+		}
+		date := statementDate(booking, &valueDate, bankDate)
+		if date.Before(startDate) || date.After(endDate) {
+			log.Infof("[BankParser] Skipping line %d: date out of range", record.Line)
+			continue
+		}
+
+		parsed++
+		err = fn(entity.BankStatement{
+			UniqueIdentifier: mt940Reference(record),
+			Amount:           amount,
+			Date:             date,
+			Currency:         currency,
+			Description:      record.Narrative,
+			RowNumber:        int64(record.Line),
+			ValueDate:        &valueDate,
+			Direction:        direction,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if onBalance != nil {
+		for _, statement := range reader.Statements() {
+			onBalance(mt940StatementBalance(statement, onReject))
+		}
+	}
+	log.Infof("[BankParser] Parsed %d valid bank statements", parsed)
+	return nil
+}
+
+// mt940Reference is the account owner's reference of a statement line, or the bank's
+// when the owner gave none.
+func mt940Reference(record mt940.Record) string {
+	if record.CustomerRef != "" && record.CustomerRef != "NONREF" {
+		return record.CustomerRef
+	}
+	return record.BankRef
+}
+
+func mt940StatementBalance(statement mt940.Statement, onReject rejectFunc) entity.StatementBalance {
+	balance := entity.StatementBalance{
+		Reference:       statement.Reference,
+		Account:         statement.Account,
+		StatementNumber: statement.Number,
+	}
+	balance.OpeningBalance, balance.OpeningDate = mt940Balance(statement.Opening, onReject)
+	balance.ClosingBalance, balance.ClosingDate = mt940Balance(statement.Closing, onReject)
+	if statement.Opening != nil {
+		balance.Currency = entity.NormalizeCurrency(statement.Opening.Currency)
+	} else if statement.Closing != nil {
+		balance.Currency = entity.NormalizeCurrency(statement.Closing.Currency)
+	}
+	return balance
+}
+
+func mt940Balance(balance *mt940.Balance, onReject rejectFunc) (*entity.Amount, string) {
+	if balance == nil {
+		return nil, ""
+	}
+	amount, err := entity.ParseAmount(balance.Amount, entity.CurrencyFractionDigits(entity.NormalizeCurrency(balance.Currency)))
+	if err != nil {
+		log.Infof("[BankParser] Skipping balance on line %d: %v", balance.Line, err)
+		onReject.add(balance.Line, "", err.Error())
+		return nil, ""
+	}
+	if balance.Mark == "D" {
+		amount = -amount
+	}
+	return &amount, balance.Date.Format("2006-01-02")
+}
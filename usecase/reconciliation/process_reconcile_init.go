@@ -2,6 +2,7 @@ package reconciliation
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -9,30 +10,56 @@ import (
 
 	"github.com/radhian/reconciliation-system/consts"
 	"github.com/radhian/reconciliation-system/entity"
+	"github.com/radhian/reconciliation-system/infra/db/dao"
 	"github.com/radhian/reconciliation-system/infra/db/model"
 )
 
-func (u *reconciliationUsecase) ProcessReconciliationInit(transactionCSV string, referenceCSVs []string, startTime, endTime int64, operator string) (*model.ReconciliationProcessLog, error) {
+// pendingAsset is an uploaded input file waiting to be recorded against the new log.
+type pendingAsset struct {
+	url          string
+	dataType     int64
+	parseProfile string
+	fileFormat   string
+}
+
+func (u *reconciliationUsecase) ProcessReconciliationInit(transactionFile entity.ReconciliationFile, referenceFiles []entity.ReconciliationFile, fxRateCSV string, processInfo entity.ProcessMetadata, operator string) (*model.ReconciliationProcessLog, error) {
 	timeNowUnix := time.Now().Unix()
 
-	mainFileURL, err := u.uploadFile(transactionCSV)
+	// Profiles are copied onto the assets, so editing a profile later does not change how
+	// a running job parses its files.
+	mainProfile, err := u.loadCsvProfileConfig(transactionFile.Profile, consts.DataTypeSystemFile)
+	if err != nil {
+		return nil, err
+	}
+	refProfiles := make([]string, 0, len(referenceFiles))
+	for _, ref := range referenceFiles {
+		profile, err := u.loadCsvProfileConfig(ref.Profile, consts.DataTypeBankStatement)
+		if err != nil {
+			return nil, err
+		}
+		refProfiles = append(refProfiles, profile)
+	}
+
+	mainFileURL, err := u.uploadFile(transactionFile.Path)
 	if err != nil {
-		return nil, fmt.Errorf("failed to upload main file: %v", err)
+		return nil, uploadError("main file", err)
 	}
+	pending := []pendingAsset{{url: mainFileURL, dataType: consts.DataTypeSystemFile, parseProfile: mainProfile, fileFormat: consts.FileFormatCSV}}
 
-	refFileURLs := make([]string, 0, len(referenceCSVs))
-	for _, ref := range referenceCSVs {
-		url, err := u.uploadFile(ref)
+	for i, ref := range referenceFiles {
+		url, err := u.uploadFile(ref.Path)
 		if err != nil {
-			return nil, fmt.Errorf("failed to upload reference file %s: %v", ref, err)
+			return nil, uploadError("reference file "+ref.Path, err)
 		}
-		refFileURLs = append(refFileURLs, url)
+		pending = append(pending, pendingAsset{url: url, dataType: consts.DataTypeBankStatement, parseProfile: refProfiles[i], fileFormat: fileFormatOf(ref)})
 	}
 
-	// Create process info
-	processInfo := entity.ProcessMetadata{
-		StartTime: startTime,
-		EndTime:   endTime,
+	if fxRateCSV != "" {
+		url, err := u.uploadFile(fxRateCSV)
+		if err != nil {
+			return nil, uploadError("fx rate file "+fxRateCSV, err)
+		}
+		pending = append(pending, pendingAsset{url: url, dataType: consts.DataTypeFxRate, fileFormat: consts.FileFormatCSV})
 	}
 
 	processInfoJSON, err := json.Marshal(processInfo)
@@ -47,33 +74,39 @@ func (u *reconciliationUsecase) ProcessReconciliationInit(transactionCSV string,
 		ProcessInfo:        string(processInfoJSON),
 		Status:             consts.StatusInit,
 		Result:             "",
+		Priority:           processInfo.Priority,
 		CreateTime:         timeNowUnix,
 		CreateBy:           operator,
 		UpdateTime:         timeNowUnix,
 		UpdateBy:           operator,
 	}
 
-	if err := u.dao.CreateReconciliationProcessLog(log); err != nil {
-		return nil, fmt.Errorf("failed to create reconciliation process log: %v", err)
-	}
-
-	for i, url := range append([]string{mainFileURL}, refFileURLs...) {
-		dataType := int64(consts.DataTypeSystemFile)
-		if i > 0 {
-			dataType = consts.DataTypeBankStatement
+	// The log and its assets are created together, so a worker never picks up a log
+	// whose files are not recorded yet.
+	err = u.dao.WithTransaction(func(txDao dao.DaoMethod) error {
+		if err := txDao.CreateReconciliationProcessLog(log); err != nil {
+			return fmt.Errorf("failed to create reconciliation process log: %v", err)
 		}
 
-		asset := &model.ReconciliationProcessLogAsset{
-			ReconciliationProcessLogID: log.ID,
-			FileName:                   filepath.Base(url),
-			FileUrl:                    url,
-			DataType:                   dataType,
-			CreateTime:                 timeNowUnix,
-			CreateBy:                   operator,
-		}
-		if err := u.dao.CreateReconciliationProcessLogAsset(asset); err != nil {
-			return nil, fmt.Errorf("failed to save file asset: %v", err)
+		for _, p := range pending {
+			asset := &model.ReconciliationProcessLogAsset{
+				ReconciliationProcessLogID: log.ID,
+				FileName:                   filepath.Base(p.url),
+				FileUrl:                    p.url,
+				DataType:                   p.dataType,
+				ParseProfile:               p.parseProfile,
+				FileFormat:                 p.fileFormat,
+				CreateTime:                 timeNowUnix,
+				CreateBy:                   operator,
+			}
+			if err := txDao.CreateReconciliationProcessLogAsset(asset); err != nil {
+				return fmt.Errorf("failed to save file asset: %v", err)
+			}
 		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	return log, nil
@@ -81,7 +114,11 @@ func (u *reconciliationUsecase) ProcessReconciliationInit(transactionCSV string,
 
 // NOTES: this is the simulation version of object storage, later we can implement object storage uploader in production.
 func (u *reconciliationUsecase) uploadFile(filePath string) (string, error) {
-	input, err := os.ReadFile(filePath)
+	sourcePath, err := u.resolveJobInputPath(filePath)
+	if err != nil {
+		return "", err
+	}
+	input, err := os.ReadFile(sourcePath)
 	if err != nil {
 		return "", err
 	}
@@ -102,3 +139,12 @@ func (u *reconciliationUsecase) uploadFile(filePath string) (string, error) {
 
 	return destPath, nil
 }
+
+// uploadError keeps a rejected input file as the caller's error, so it can be told apart
+// from a failed upload.
+func uploadError(what string, err error) error {
+	if errors.Is(err, ErrInputFileRejected) {
+		return err
+	}
+	return fmt.Errorf("failed to upload %s: %v", what, err)
+}
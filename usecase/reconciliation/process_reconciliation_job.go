@@ -2,77 +2,183 @@ package reconciliation
 
 import (
 	"context"
-	"encoding/csv"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"math"
-	"os"
-	"strconv"
+	"io"
 	"strings"
 	"time"
 
 	"github.com/labstack/gommon/log"
 	"github.com/radhian/reconciliation-system/consts"
 	"github.com/radhian/reconciliation-system/entity"
+	"github.com/radhian/reconciliation-system/infra/db/dao"
 	"github.com/radhian/reconciliation-system/infra/db/model"
-	"github.com/radhian/reconciliation-system/utils"
 )
 
-func (u *reconciliationUsecase) ProcessReconciliationJob(ctx context.Context, logID int64) error {
+// ProcessReconciliationJob runs the next batch of a job. A failure is recorded on the
+// job: a permanent one fails it, a retryable one leaves it for a later run. A batch is
+// stopped and nothing of it saved once ctx ends or the worker loses the job; that is not
+// a failure of the job, which goes on from its last checkpoint.
+func (u *reconciliationUsecase) ProcessReconciliationJob(ctx context.Context, logID int64) (err error) {
 	defer func() {
 		if r := recover(); r != nil {
 			log.Errorf("[ReconcileJob] Panic recovered for LogID %d: %v", logID, r)
+			err = permanentError(consts.JobErrorInternal, fmt.Errorf("panic: %v", r))
+			u.failJob(logID, err)
 		}
 	}()
 
 	log.Infof("[ReconcileJob] Starting job for LogID: %d", logID)
 
+	ctx, cancel := u.jobContext(ctx, logID)
+	defer cancel()
+
+	if err := u.reconcileNextBatch(ctx, logID); err != nil {
+		if errors.Is(err, errJobLost) || ctx.Err() != nil {
+			log.Warnf("[ReconcileJob] Batch of LogID %d stopped without saving: %v", logID, err)
+			return err
+		}
+		u.failJob(logID, err)
+		return err
+	}
+
+	log.Infof("[ReconcileJob] Job completed for LogID %d", logID)
+	return nil
+}
+
+func (u *reconciliationUsecase) reconcileNextBatch(ctx context.Context, logID int64) error {
 	logEntry, err := u.fetchProcessLog(logID)
 	if err != nil {
 		log.Errorf("[ReconcileJob] Could not fetch process log %d: %v", logID, err)
-		return err
+		return retryableError(err)
 	}
 
 	assets, err := u.fetchProcessLogAssets(logID)
 	if err != nil {
 		log.Errorf("[ReconcileJob] Could not fetch assets for LogID %d: %v", logID, err)
-		return err
+		return retryableError(err)
 	}
 
-	systemFileUrl, err := findSystemFileUrl(assets)
+	systemAsset, err := findSystemFileAsset(assets)
 	if err != nil {
 		log.Errorf("[ReconcileJob] System file URL not found: %v", err)
-		return err
+		return permanentError(consts.JobErrorSystemFileMissing, err)
 	}
 
-	requestStartTime, requestEndTime, err := parseProcessMetadata(logEntry.ProcessInfo)
+	metadata, err := parseProcessMetadata(logEntry.ProcessInfo)
 	if err != nil {
 		log.Errorf("[ReconcileJob] Metadata parse error for LogID %d: %v", logID, err)
+		return permanentError(consts.JobErrorInvalidProcessInfo, err)
+	}
+	requestStartTime, requestEndTime, err := requestTimeRange(metadata)
+	if err != nil {
+		log.Errorf("[ReconcileJob] Invalid timezone for LogID %d: %v", logID, err)
+		return permanentError(consts.JobErrorInvalidTimezone, err)
+	}
+
+	fxRates, err := loadFxRates(assets)
+	if err != nil {
+		log.Errorf("[ReconcileJob] Could not load fx rates for LogID %d: %v", logID, err)
+		return permanentError(consts.JobErrorInvalidFxRates, err)
+	}
+
+	matcherChain, err := newMatcherChain(metadata, fxRates)
+	if err != nil {
+		log.Errorf("[ReconcileJob] Invalid match rules for LogID %d: %v", logID, err)
+		return permanentError(consts.JobErrorInvalidMatchRules, err)
+	}
+
+	if logEntry.StageTime == 0 {
+		logEntry, err = u.stageJobInputs(ctx, logEntry, assets, systemAsset, metadata)
+		if err != nil {
+			log.Errorf("[ReconcileJob] Could not stage inputs for LogID %d: %v", logID, err)
+			return err
+		}
+	}
+	if err := ctx.Err(); err != nil {
 		return err
 	}
+	totalRows := logEntry.TotalMainRow
 
 	log.Infof("[ReconcileJob] Reconciling batch (start row: %d, size: %d)", logEntry.CurrentMainRow, u.batchSize)
 
-	totalRows, processedRows, result := u.reconcileData(
-		systemFileUrl,
-		assets,
-		requestStartTime,
-		requestEndTime,
-		int(logEntry.CurrentMainRow),
+	processedRows, nextPosition, result, matches, err := u.reconcileData(
+		logEntry,
+		systemAsset,
+		metadata,
+		matcherChain[0],
 		int(u.batchSize),
 	)
+	if err != nil {
+		log.Errorf("[ReconcileJob] Batch failed for LogID %d: %v", logID, err)
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	logEntry.CurrentMainOffset = nextPosition.Offset
+	logEntry.CurrentMainLine = nextPosition.Line
 
 	log.Infof("[ReconcileJob] Batch done for LogID %d: total=%d, processed=%d", logID, totalRows, processedRows)
 
-	logEntry = u.updateProcessLogAfterBatch(logEntry, totalRows, processedRows, result, requestStartTime, requestEndTime)
+	var finalMatches []matchedPair
+	var finalGroups []matchedGroup
+	logEntry, finalMatches, finalGroups, err = u.updateProcessLogAfterBatch(
+		logEntry,
+		totalRows,
+		processedRows,
+		*result,
+		matcherChain[1:],
+		newAggregateMatcher(metadata),
+		matches,
+		requestStartTime,
+		requestEndTime,
+	)
+	if err != nil {
+		log.Errorf("[ReconcileJob] Failed to merge batch result for LogID %d: %v", logID, err)
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 
-	if err := u.dao.UpdateReconciliationProcessLog(logEntry); err != nil {
+	// Matches, the ledger and the checkpoint are written together so a resumed batch
+	// never sees bank rows consumed by an attempt that was not committed. The checkpoint
+	// is written last and only while the worker still holds the job.
+	err = u.dao.WithTransaction(func(txDao dao.DaoMethod) error {
+		for _, m := range append(matches, finalMatches...) {
+			if err := saveMatchedPair(txDao, logID, m, 0, logEntry.UpdateTime); err != nil {
+				return err
+			}
+		}
+		for i, g := range finalGroups {
+			if err := saveMatchedGroup(txDao, logID, g, int64(i+1), logEntry.UpdateTime); err != nil {
+				return err
+			}
+		}
+		// Leftovers on both sides are in the result by now; the staged copies are no
+		// longer needed.
+		if logEntry.Status == consts.StatusFinished {
+			if err := txDao.DeleteReconciliationBankRowsByLogID(uint(logID)); err != nil {
+				return err
+			}
+			if err := txDao.DeleteReconciliationUnmatchedTransactionsByLogID(uint(logID)); err != nil {
+				return err
+			}
+		} else if err := saveUnmatchedTransactions(txDao, logID, result.SystemUnmatched, logEntry.UpdateTime); err != nil {
+			return err
+		}
+		return u.saveProcessLog(txDao, logEntry)
+	})
+	var jobErr *jobError
+	if errors.Is(err, errJobLost) || errors.As(err, &jobErr) {
+		return err
+	}
+	if err != nil {
 		log.Errorf("[ReconcileJob] Failed to update log %d: %v", logID, err)
-		return fmt.Errorf("failed to update log: %w", err)
+		return retryableError(fmt.Errorf("failed to update log: %w", err))
 	}
-
-	log.Infof("[ReconcileJob] Job completed for LogID %d", logID)
 	return nil
 }
 
@@ -94,358 +200,767 @@ func (u *reconciliationUsecase) fetchProcessLogAssets(logID int64) ([]model.Reco
 	return assets, nil
 }
 
-func findSystemFileUrl(assets []model.ReconciliationProcessLogAsset) (string, error) {
+func saveMatchedPair(txDao dao.DaoMethod, logID int64, m matchedPair, groupNumber int64, createTime int64) error {
+	if err := saveBankConsumption(txDao, logID, m.Bank, createTime); err != nil {
+		return err
+	}
+	return saveMatchRecord(txDao, logID, m, m.amountDifference(), groupNumber, createTime)
+}
+
+// saveMatchedGroup stores one match row per system/bank pair of the group, all sharing
+// the group number and the group-level amount difference, and consumes every bank row
+// of the group once.
+func saveMatchedGroup(txDao dao.DaoMethod, logID int64, g matchedGroup, groupNumber int64, createTime int64) error {
+	var groupDifference entity.Amount
+	var err error
+	for _, b := range g.Bank {
+		if groupDifference, err = groupDifference.Add(b.Amount.Abs()); err != nil {
+			return permanentError(consts.JobErrorAmountOverflow, fmt.Errorf("group amount difference: %w", err))
+		}
+		if err := saveBankConsumption(txDao, logID, b, createTime); err != nil {
+			return err
+		}
+	}
+	for _, trx := range g.System {
+		if groupDifference, err = groupDifference.Sub(trx.Amount); err != nil {
+			return permanentError(consts.JobErrorAmountOverflow, fmt.Errorf("group amount difference: %w", err))
+		}
+	}
+
+	for _, trx := range g.System {
+		for _, b := range g.Bank {
+			pair := matchedPair{System: trx, Bank: b, Rule: g.Rule}
+			if err := saveMatchRecord(txDao, logID, pair, groupDifference, groupNumber, createTime); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func saveBankConsumption(txDao dao.DaoMethod, logID int64, b entity.BankStatement, createTime int64) error {
+	consumption := &model.ReconciliationBankConsumption{
+		ReconciliationProcessLogID: logID,
+		SourceFile:                 b.SourceFile,
+		RowNumber:                  b.RowNumber,
+		CreateTime:                 createTime,
+	}
+	return txDao.CreateReconciliationBankConsumption(consumption)
+}
+
+func saveMatchRecord(txDao dao.DaoMethod, logID int64, m matchedPair, amountDifference entity.Amount, groupNumber int64, createTime int64) error {
+	match := &model.ReconciliationMatch{
+		ReconciliationProcessLogID: logID,
+		SystemTrxID:                m.System.TrxID,
+		BankIdentifier:             m.Bank.UniqueIdentifier,
+		SourceFile:                 m.Bank.SourceFile,
+		BankRowNumber:              m.Bank.RowNumber,
+		MatchRule:                  m.Rule,
+		AmountDifference:           amountDifference,
+		DateDifference:             dateDifferenceInDays(m.System.TransactionTime, m.Bank.Date),
+		GroupNumber:                groupNumber,
+		CreateTime:                 createTime,
+	}
+	return txDao.CreateReconciliationMatch(match)
+}
+
+func bankRowKey(sourceFile string, rowNumber int64) string {
+	return fmt.Sprintf("%s|%d", sourceFile, rowNumber)
+}
+
+func findSystemFileAsset(assets []model.ReconciliationProcessLogAsset) (model.ReconciliationProcessLogAsset, error) {
 	for _, asset := range assets {
 		if asset.DataType == consts.DataTypeSystemFile {
-			return asset.FileUrl, nil
+			return asset, nil
 		}
 	}
-	return "", errors.New("missing system file URL")
+	return model.ReconciliationProcessLogAsset{}, errors.New("missing system file URL")
 }
 
-func parseProcessMetadata(processInfo string) (time.Time, time.Time, error) {
-	var metadata entity.ProcessMetadata
+// parseProcessMetadata decodes the stored job metadata. Jobs saved before a date lag was
+// recorded get the default, no limit, rather than reading as same-day only.
+func parseProcessMetadata(processInfo string) (entity.ProcessMetadata, error) {
+	metadata := entity.ProcessMetadata{MaxDateLagDays: consts.DefaultMaxDateLagDays}
 	if err := json.Unmarshal([]byte(processInfo), &metadata); err != nil {
-		return time.Time{}, time.Time{}, fmt.Errorf("failed to parse process metadata: %w", err)
+		return metadata, fmt.Errorf("failed to parse process metadata: %w", err)
+	}
+	return metadata, nil
+}
+
+// requestTimeRange returns the job window in the job's time zone. The parsers read the
+// zone from the start time: system times are shown in it and bank dates are compared with
+// the window's calendar days in it.
+func requestTimeRange(metadata entity.ProcessMetadata) (time.Time, time.Time, error) {
+	location, err := requestLocation(metadata)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
 	}
-	start := time.Unix(metadata.StartTime, 0).UTC()
-	end := time.Unix(metadata.EndTime, 0).UTC()
+	start := time.Unix(metadata.StartTime, 0).In(location)
+	end := time.Unix(metadata.EndTime, 0).In(location)
 	return start, end, nil
 }
 
+// requestLocation is the time zone of the job's business day, UTC when none is set. A zone
+// that cannot be loaded is an error rather than UTC, which would put rows near midnight on
+// the wrong day.
+func requestLocation(metadata entity.ProcessMetadata) (*time.Location, error) {
+	if metadata.Timezone == "" {
+		return time.UTC, nil
+	}
+	location, err := time.LoadLocation(metadata.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load timezone %q: %w", metadata.Timezone, err)
+	}
+	return location, nil
+}
+
+// calendarDay returns the date of t in t's own location as midnight UTC, the form bank
+// statement dates are kept in.
+func calendarDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// statementDate is the date a bank row is windowed and matched by: its value date when the
+// job asks for value dates and the row has one, else its booking date.
+func statementDate(booking time.Time, valueDate *time.Time, bankDate string) time.Time {
+	if bankDate == consts.BankDateValue && valueDate != nil {
+		return *valueDate
+	}
+	return booking
+}
+
 func (u *reconciliationUsecase) updateProcessLogAfterBatch(
 	logEntry model.ReconciliationProcessLog,
 	totalRows int64,
 	processedRows int64,
-	result string,
+	batchResult entity.ReconciliationResult,
+	followUpMatchers []Matcher,
+	aggregator *aggregateMatcher,
+	batchMatches []matchedPair,
 	requestStartTime time.Time,
 	requestEndTime time.Time,
-) model.ReconciliationProcessLog {
+) (model.ReconciliationProcessLog, []matchedPair, []matchedGroup, error) {
 	logEntry.TotalMainRow = totalRows
 	logEntry.CurrentMainRow += processedRows
-	logEntry.Result = result
+
+	// The accumulated result is saved together with CurrentMainRow, so a resumed
+	// job continues merging from exactly the batches that were already counted.
+	accumulated, err := parseResultSummary(logEntry.Result)
+	if err != nil {
+		return logEntry, nil, nil, err
+	}
+	if err := mergeResultSummary(&accumulated, batchResult); err != nil {
+		return logEntry, nil, nil, permanentError(consts.JobErrorAmountOverflow, err)
+	}
+
+	var finalMatches []matchedPair
+	var finalGroups []matchedGroup
+	if logEntry.CurrentMainRow >= totalRows {
+		saved, err := u.fetchUnmatchedTransactions(logEntry.ID, requestStartTime.Location())
+		if err != nil {
+			return logEntry, nil, nil, retryableError(err)
+		}
+		// Jobs that ran batches before unmatched transactions were saved on their own
+		// still carry those in the result; they come first in file order.
+		unmatchedSys := append(append(accumulated.SystemUnmatched, saved...), batchResult.SystemUnmatched...)
+
+		// The batch's own matches are not written yet, so the rows they took are left out
+		// by hand, as are the rows the final pass takes.
+		taken := make(map[string]bool, len(batchMatches))
+		for _, m := range batchMatches {
+			taken[bankRowKey(m.Bank.SourceFile, m.Bank.RowNumber)] = true
+		}
+		candidates, err := u.fetchFinalPassBank(logEntry.ID, unmatchedSys, followUpMatchers, aggregator, taken)
+		if err != nil {
+			return logEntry, nil, nil, retryableError(err)
+		}
+		finalMatches, finalGroups, unmatchedSys, err = runFinalPass(&accumulated, unmatchedSys, followUpMatchers, aggregator, candidates)
+		if err != nil {
+			return logEntry, nil, nil, permanentError(consts.JobErrorAmountOverflow, err)
+		}
+		for _, m := range finalMatches {
+			taken[bankRowKey(m.Bank.SourceFile, m.Bank.RowNumber)] = true
+		}
+		for _, g := range finalGroups {
+			for _, b := range g.Bank {
+				taken[bankRowKey(b.SourceFile, b.RowNumber)] = true
+			}
+		}
+		unmatchedBank, err := u.fetchLeftoverBank(logEntry.ID, taken)
+		if err != nil {
+			return logEntry, nil, nil, retryableError(err)
+		}
+		if err := finalizeResultSummary(&accumulated, unmatchedSys, unmatchedBank); err != nil {
+			return logEntry, nil, nil, permanentError(consts.JobErrorAmountOverflow, err)
+		}
+	}
+
+	resBytes, err := json.Marshal(accumulated)
+	if err != nil {
+		return logEntry, nil, nil, fmt.Errorf("failed to marshal result summary: %w", err)
+	}
+	logEntry.Result = string(resBytes)
 
 	if logEntry.CurrentMainRow >= totalRows {
 		logEntry.Status = consts.StatusFinished
 	} else {
 		logEntry.Status = consts.StatusRunning
 	}
+	// A good batch ends any run of retryable failures.
+	logEntry.ErrorCode = ""
+	logEntry.ErrorMessage = ""
+	logEntry.FailedAttempts = 0
 
 	logEntry.UpdateTime = time.Now().Unix()
 	logEntry.UpdateBy = "system"
 
-	return logEntry
+	return logEntry, finalMatches, finalGroups, nil
 }
 
-func (u *reconciliationUsecase) parseBankAssets(
-	assets []model.ReconciliationProcessLogAsset,
-	startTime, endTime time.Time,
-) ([]entity.BankStatement, map[string][]entity.BankStatement) {
-	bankTxs := make([]entity.BankStatement, 0)
-	bankBySource := make(map[string][]entity.BankStatement)
+func buildResultSummary(
+	total int,
+	matched int,
+	unmatchedSystem []entity.Transaction,
+	unmatchedBank map[string][]entity.BankStatement,
+) (entity.ReconciliationResult, error) {
+	summary := entity.ReconciliationResult{
+		TotalProcessed:     int64(total),
+		Matched:            int64(matched),
+		Unmatched:          int64(total - matched),
+		MatchedByRule:      make(map[string]int64),
+		SystemUnmatched:    unmatchedSystem,
+		BankUnmatchedBySrc: unmatchedBank,
+	}
+	totalDiscrepancy, err := calculateTotalDiscrepancy(summary)
+	if err != nil {
+		return summary, err
+	}
+	summary.TotalDiscrepancy = totalDiscrepancy
+	if err := calculateCurrencyTotals(&summary); err != nil {
+		return summary, err
+	}
+	return summary, nil
+}
 
-	for _, asset := range assets {
-		if asset.DataType != consts.DataTypeBankStatement {
-			continue
+func calculateTotalDiscrepancy(summary entity.ReconciliationResult) (entity.Amount, error) {
+	var totalDiscrepancy entity.Amount
+	var err error
+
+	// Sum discrepancies from system unmatched
+	for _, trx := range summary.SystemUnmatched {
+		if totalDiscrepancy, err = totalDiscrepancy.Add(trx.Amount); err != nil {
+			return 0, fmt.Errorf("total discrepancy: %w", err)
 		}
-		txs, err := parseBankStatements(asset.FileUrl, startTime, endTime)
-		if err != nil {
-			log.Errorf("failed to parse bank statements from %s: %v", asset.FileUrl, err)
-			continue
+	}
+
+	// Sum discrepancies from bank unmatched
+	for _, group := range summary.BankUnmatchedBySrc {
+		for _, b := range group {
+			if totalDiscrepancy, err = totalDiscrepancy.Add(b.Amount.Abs()); err != nil {
+				return 0, fmt.Errorf("total discrepancy: %w", err)
+			}
 		}
-		bankTxs = append(bankTxs, txs...)
-		bankBySource[asset.FileName] = append(bankBySource[asset.FileName], txs...)
 	}
 
-	return bankTxs, bankBySource
+	return totalDiscrepancy, nil
 }
 
-func buildTransactionMap(transactions []entity.Transaction) map[string][]entity.Transaction {
-	m := make(map[string][]entity.Transaction)
-	for _, trx := range transactions {
-		var typeCode string
-		if trx.Type == "CREDIT" {
-			typeCode = "c"
-		} else if trx.Type == "DEBIT" {
-			typeCode = "d"
-		} else {
-			typeCode = "u"
+// calculateCurrencyTotals recomputes the per-currency unmatched counts and discrepancies
+// from the unmatched lists, keeping the matched counts and variance already recorded.
+func calculateCurrencyTotals(summary *entity.ReconciliationResult) error {
+	totals := make(map[string]entity.CurrencyTotal)
+	for currency, total := range summary.TotalsByCurrency {
+		totals[currency] = entity.CurrencyTotal{Matched: total.Matched, Variance: total.Variance}
+	}
+
+	var err error
+	for _, trx := range summary.SystemUnmatched {
+		if trx.Currency == "" {
+			continue
+		}
+		total := totals[trx.Currency]
+		total.Unmatched++
+		if total.Discrepancy, err = total.Discrepancy.Add(trx.Amount); err != nil {
+			return fmt.Errorf("%s discrepancy: %w", trx.Currency, err)
+		}
+		totals[trx.Currency] = total
+	}
+	for _, group := range summary.BankUnmatchedBySrc {
+		for _, b := range group {
+			if b.Currency == "" {
+				continue
+			}
+			total := totals[b.Currency]
+			if total.Discrepancy, err = total.Discrepancy.Add(b.Amount.Abs()); err != nil {
+				return fmt.Errorf("%s discrepancy: %w", b.Currency, err)
+			}
+			totals[b.Currency] = total
 		}
+	}
 
-		key := fmt.Sprintf("%s|%.2f", typeCode, trx.Amount)
-		m[key] = append(m[key], trx)
+	if len(totals) == 0 {
+		totals = nil
 	}
-	return m
+	summary.TotalsByCurrency = totals
+	return nil
 }
 
-func buildBankStatementMap(bankTxs []entity.BankStatement) map[string][]entity.BankStatement {
-	m := make(map[string][]entity.BankStatement)
-	for _, b := range bankTxs {
-		var typeCode string
-		if b.Amount < 0 {
-			typeCode = "d"
-		} else {
-			typeCode = "c"
-		}
-
-		amount := math.Abs(b.Amount)
-		key := fmt.Sprintf("%s|%.2f", typeCode, amount)
-		m[key] = append(m[key], b)
+func addCurrencyTotal(summary *entity.ReconciliationResult, currency string, matched int64, variance entity.Amount) error {
+	if currency == "" {
+		return nil
+	}
+	if summary.TotalsByCurrency == nil {
+		summary.TotalsByCurrency = make(map[string]entity.CurrencyTotal)
 	}
-	return m
+	total := summary.TotalsByCurrency[currency]
+	total.Matched += matched
+	var err error
+	if total.Variance, err = total.Variance.Add(variance); err != nil {
+		return fmt.Errorf("%s variance: %w", currency, err)
+	}
+	summary.TotalsByCurrency[currency] = total
+	return nil
 }
 
-func compareTransactions(
-	sysMap map[string][]entity.Transaction,
-	bankMap map[string][]entity.BankStatement,
-) (matchedCount int64, unmatchedSys []entity.Transaction, unmatchedBank []entity.BankStatement) {
-	for key, txList := range sysMap {
-		bankList, found := bankMap[key]
-		if found {
-			matchCount := utils.Min(len(txList), len(bankList))
-			matchedCount += int64(matchCount)
-
-			if len(txList) > matchCount {
-				unmatchedSys = append(unmatchedSys, txList[matchCount:]...)
-			}
-			if len(bankList) > matchCount {
-				unmatchedBank = append(unmatchedBank, bankList[matchCount:]...)
-			}
-			delete(bankMap, key)
-		} else {
-			unmatchedSys = append(unmatchedSys, txList...)
-		}
+func parseResultSummary(result string) (entity.ReconciliationResult, error) {
+	var summary entity.ReconciliationResult
+	if strings.TrimSpace(result) == "" {
+		return summary, nil
+	}
+	if err := json.Unmarshal([]byte(result), &summary); err != nil {
+		return summary, fmt.Errorf("failed to parse result summary: %w", err)
 	}
+	return summary, nil
+}
+
+// mergeResultSummary adds the counts and totals of a single batch to the job-wide summary.
+// The batch's unmatched transactions are saved on their own rather than appended, so a
+// merge costs the same however many batches came before.
+func mergeResultSummary(acc *entity.ReconciliationResult, batch entity.ReconciliationResult) error {
+	acc.TotalProcessed += batch.TotalProcessed
+	acc.Matched += batch.Matched
+	acc.Unmatched = acc.TotalProcessed - acc.Matched
 
-	for _, leftover := range bankMap {
-		unmatchedBank = append(unmatchedBank, leftover...)
+	if acc.MatchedByRule == nil {
+		acc.MatchedByRule = make(map[string]int64)
+	}
+	for rule, count := range batch.MatchedByRule {
+		acc.MatchedByRule[rule] += count
+	}
+	var err error
+	if acc.TotalDiscrepancy, err = acc.TotalDiscrepancy.Add(batch.TotalDiscrepancy); err != nil {
+		return fmt.Errorf("total discrepancy: %w", err)
+	}
+	if acc.TotalVariance, err = acc.TotalVariance.Add(batch.TotalVariance); err != nil {
+		return fmt.Errorf("total variance: %w", err)
+	}
+	acc.ReferenceMismatches = append(acc.ReferenceMismatches, batch.ReferenceMismatches...)
+	if acc.ReferenceMismatchDiscrepancy, err = acc.ReferenceMismatchDiscrepancy.Add(batch.ReferenceMismatchDiscrepancy); err != nil {
+		return fmt.Errorf("reference mismatch discrepancy: %w", err)
 	}
 
-	return matchedCount, unmatchedSys, unmatchedBank
+	if len(batch.TotalsByCurrency) > 0 && acc.TotalsByCurrency == nil {
+		acc.TotalsByCurrency = make(map[string]entity.CurrencyTotal)
+	}
+	for currency, batchTotal := range batch.TotalsByCurrency {
+		total := acc.TotalsByCurrency[currency]
+		total.Matched += batchTotal.Matched
+		total.Unmatched += batchTotal.Unmatched
+		if total.Discrepancy, err = total.Discrepancy.Add(batchTotal.Discrepancy); err != nil {
+			return fmt.Errorf("%s discrepancy: %w", currency, err)
+		}
+		if total.Variance, err = total.Variance.Add(batchTotal.Variance); err != nil {
+			return fmt.Errorf("%s variance: %w", currency, err)
+		}
+		acc.TotalsByCurrency[currency] = total
+	}
+	return nil
 }
 
-func groupUnmatchedBanks(
-	unmatchedBank []entity.BankStatement,
-	bankBySource map[string][]entity.BankStatement,
-) map[string][]entity.BankStatement {
-	bankGroups := make(map[string][]entity.BankStatement)
-
-	for _, b := range unmatchedBank {
-		for source, list := range bankBySource {
-			for _, ref := range list {
-				if ref.UniqueIdentifier == b.UniqueIdentifier && ref.Amount == b.Amount {
-					bankGroups[source] = append(bankGroups[source], b)
-					break
-				}
-			}
+// runFinalPass runs the rest of the rule chain, then the optional aggregate pass, over
+// everything left unmatched once the last batch is in, and records what they match. Only
+// the first rule runs per batch; running the others here lets them see every leftover at
+// once, exactly as they would in a single pass over the file. bankCandidates only needs
+// the leftover bank rows the rules could pair; see fetchFinalPassBank. It returns the
+// transactions nothing claimed.
+func runFinalPass(
+	acc *entity.ReconciliationResult,
+	unmatchedSystem []entity.Transaction,
+	followUpMatchers []Matcher,
+	aggregator *aggregateMatcher,
+	bankCandidates []entity.BankStatement,
+) ([]matchedPair, []matchedGroup, []entity.Transaction, error) {
+	matches, unmatchedSys, unmatchedBank := runMatcherChain(followUpMatchers, unmatchedSystem, bankCandidates)
+	acc.Matched += int64(len(matches))
+	if err := recordMatches(acc, matches); err != nil {
+		return nil, nil, nil, err
+	}
+
+	var groups []matchedGroup
+	if aggregator != nil && len(unmatchedSys) > 0 && len(unmatchedBank) > 0 {
+		groups, unmatchedSys, _ = aggregator.Match(unmatchedSys, unmatchedBank)
+		if err := recordGroupedMatches(acc, groups); err != nil {
+			return nil, nil, nil, err
 		}
 	}
-	return bankGroups
+	return matches, groups, unmatchedSys, nil
 }
 
-func buildResultSummary(
-	total int,
-	matched int,
+// finalizeResultSummary reports the transactions and bank rows nothing claimed. The
+// unmatched lists and the totals drawn from them are built here, once per job.
+func finalizeResultSummary(
+	acc *entity.ReconciliationResult,
 	unmatchedSystem []entity.Transaction,
 	unmatchedBank map[string][]entity.BankStatement,
-) (string, error) {
-	var totalDiscrepancy float64
-
-	// Sum discrepancies from system unmatched
-	for _, trx := range unmatchedSystem {
-		totalDiscrepancy += trx.Amount
+) error {
+	acc.Unmatched = acc.TotalProcessed - acc.Matched
+	acc.SystemUnmatched = unmatchedSystem
+	acc.BankUnmatchedBySrc = unmatchedBank
+	totalDiscrepancy, err := calculateTotalDiscrepancy(*acc)
+	if err != nil {
+		return err
 	}
+	acc.TotalDiscrepancy = totalDiscrepancy
+	return calculateCurrencyTotals(acc)
+}
 
-	// Sum discrepancies from bank unmatched
-	for _, group := range unmatchedBank {
-		for _, b := range group {
-			totalDiscrepancy += math.Abs(b.Amount)
+// recordGroupedMatches lists every group in the summary; each system transaction in a
+// group counts as one match.
+func recordGroupedMatches(summary *entity.ReconciliationResult, groups []matchedGroup) error {
+	for i, g := range groups {
+		grouped := entity.GroupedMatch{GroupNumber: int64(i + 1), Rule: g.Rule}
+		for _, trx := range g.System {
+			grouped.SystemTrxIDs = append(grouped.SystemTrxIDs, trx.TrxID)
+		}
+		var err error
+		for _, b := range g.Bank {
+			grouped.BankIdentifiers = append(grouped.BankIdentifiers, b.UniqueIdentifier)
+			if grouped.Amount, err = grouped.Amount.Add(b.Amount.Abs()); err != nil {
+				return fmt.Errorf("group amount: %w", err)
+			}
+		}
+		summary.GroupedMatches = append(summary.GroupedMatches, grouped)
+		summary.Matched += int64(len(g.System))
+		summary.MatchedByRule[g.Rule] += int64(len(g.System))
+		if err := addCurrencyTotal(summary, g.System[0].Currency, int64(len(g.System)), 0); err != nil {
+			return err
 		}
 	}
+	return nil
+}
 
-	summary := struct {
-		TotalProcessed     int                               `json:"total_processed"`
-		Matched            int                               `json:"matched"`
-		Unmatched          int                               `json:"unmatched"`
-		SystemUnmatched    []entity.Transaction              `json:"system_unmatched"`
-		BankUnmatchedBySrc map[string][]entity.BankStatement `json:"bank_unmatched_by_source"`
-		TotalDiscrepancy   float64                           `json:"total_discrepancy"`
-	}{
-		TotalProcessed:     total,
-		Matched:            matched,
-		Unmatched:          total - matched,
-		SystemUnmatched:    unmatchedSystem,
-		BankUnmatchedBySrc: unmatchedBank,
-		TotalDiscrepancy:   totalDiscrepancy,
+// recordMatches adds per-rule and per-currency counts and the fee/rounding variance of
+// matched pairs. The variance is kept apart from TotalDiscrepancy, which only covers
+// unmatched items; for cross-currency pairs it is measured in the bank currency.
+func recordMatches(summary *entity.ReconciliationResult, matches []matchedPair) error {
+	if summary.MatchedByRule == nil {
+		summary.MatchedByRule = make(map[string]int64)
 	}
+	var err error
+	for _, m := range matches {
+		summary.MatchedByRule[m.Rule]++
+		if err := addCurrencyTotal(summary, m.System.Currency, 1, 0); err != nil {
+			return err
+		}
 
-	resBytes, err := json.Marshal(summary)
-	if err != nil {
-		return "", err
+		// A reference match vouches for the pairing, not for the amount, so a differing
+		// amount is its own discrepancy category rather than fee/rounding variance.
+		if m.Rule == consts.MatchRuleReferenceID && m.Bank.Amount.Abs() != m.System.Amount {
+			difference := signedAmountDifference(m.System, m.Bank)
+			summary.ReferenceMismatches = append(summary.ReferenceMismatches, entity.ReferenceMismatch{
+				TrxID:            m.System.TrxID,
+				BankIdentifier:   m.Bank.UniqueIdentifier,
+				SystemAmount:     m.System.Amount,
+				BankAmount:       m.Bank.Amount,
+				AmountDifference: difference,
+			})
+			if summary.ReferenceMismatchDiscrepancy, err = summary.ReferenceMismatchDiscrepancy.Add(difference.Abs()); err != nil {
+				return fmt.Errorf("reference mismatch discrepancy: %w", err)
+			}
+			continue
+		}
+		variance := m.amountDifference().Abs()
+		if summary.TotalVariance, err = summary.TotalVariance.Add(variance); err != nil {
+			return fmt.Errorf("total variance: %w", err)
+		}
+		if err := addCurrencyTotal(summary, m.Bank.Currency, 0, variance); err != nil {
+			return err
+		}
 	}
-	return string(resBytes), nil
+	return nil
 }
 
+// reconcileData runs the first rule of the chain over the next batch of system
+// transactions, streamed from the checkpoint, against the staged bank rows the rule could
+// pair them with that earlier batches have not consumed. Every rule pairs transactions in
+// file order, so the batched answer is the same as a single pass over the whole file.
+// A profile or system file that cannot be read fails the job; a failed bank row lookup
+// can be retried.
 func (u *reconciliationUsecase) reconcileData(
-	systemFileUrl string,
-	assets []model.ReconciliationProcessLogAsset,
-	startTime time.Time,
-	endTime time.Time,
-	startIndex int,
+	logEntry model.ReconciliationProcessLog,
+	systemAsset model.ReconciliationProcessLogAsset,
+	metadata entity.ProcessMetadata,
+	batchMatcher Matcher,
 	batchSize int,
-) (totalRows int64, processedRows int64, result string) {
-	log.Infof("[Reconcile] Start file: %s", systemFileUrl)
-
-	systemTxsAll, err := parseSystemTransactions(systemFileUrl, startTime, endTime)
+) (processedRows int64, next csvPosition, result *entity.ReconciliationResult, matches []matchedPair, err error) {
+	log.Infof("[Reconcile] Start file: %s", systemAsset.FileUrl)
+	startTime, endTime, err := requestTimeRange(metadata)
 	if err != nil {
-		log.Errorf("[Reconcile] System parse failed: %v", err)
-		return 0, 0, "failed"
+		return 0, next, nil, nil, permanentError(consts.JobErrorInvalidTimezone, err)
 	}
-	totalSystemRows := len(systemTxsAll)
-	log.Infof("[Reconcile] Found %d system transactions in range", totalSystemRows)
 
-	if startIndex < 0 || startIndex >= totalSystemRows {
-		log.Warnf("[Reconcile] Invalid start index %d of %d", startIndex, totalSystemRows)
-		return int64(totalSystemRows), 0, "{}"
+	systemProfile, err := parseProfileOf(systemAsset)
+	if err != nil {
+		log.Errorf("[Reconcile] System profile invalid: %v", err)
+		return 0, next, nil, nil, permanentError(consts.JobErrorInvalidProfile, err)
 	}
 
-	endIndex := startIndex + batchSize
-	if endIndex > totalSystemRows {
-		endIndex = totalSystemRows
+	// Jobs checkpointed before byte offsets were recorded only know how many rows they
+	// processed, so those rows are skipped once by counting.
+	from := csvPosition{Offset: logEntry.CurrentMainOffset, Line: logEntry.CurrentMainLine}
+	skip := int64(0)
+	if from.Offset == 0 {
+		skip = logEntry.CurrentMainRow
 	}
-	systemTxsBatch := systemTxsAll[startIndex:endIndex]
 
-	bankTxs, bankBySource := u.parseBankAssets(assets, startTime, endTime)
-	log.Infof("[Reconcile] Parsed %d bank transactions", len(bankTxs))
-
-	sysMap := buildTransactionMap(systemTxsBatch)
-	bankMap := buildBankStatementMap(bankTxs)
+	var systemTxsBatch []entity.Transaction
+	next, err = parseSystemTransactions(systemAsset.FileUrl, systemProfile, startTime, endTime, metadata.DefaultCurrency, from,
+		func(trx entity.Transaction) bool {
+			if skip > 0 {
+				skip--
+				return true
+			}
+			systemTxsBatch = append(systemTxsBatch, trx)
+			return len(systemTxsBatch) < batchSize
+		}, nil)
+	if err != nil {
+		log.Errorf("[Reconcile] System parse failed: %v", err)
+		return 0, next, nil, nil, permanentError(consts.JobErrorSystemFileUnreadable, err)
+	}
+	log.Infof("[Reconcile] Read %d system transactions from line %d", len(systemTxsBatch), from.Line)
 
-	matchedCount, unmatchedSys, unmatchedBank := compareTransactions(sysMap, bankMap)
-	log.Infof("[Reconcile] Matched: %d | Unmatched: System=%d, Bank=%d",
-		matchedCount, len(unmatchedSys), len(unmatchedBank))
+	bankRows, err := u.dao.GetUnconsumedReconciliationBankRows(uint(logEntry.ID), batchMatcher.BankRowFilter(systemTxsBatch))
+	if err != nil {
+		log.Errorf("[Reconcile] Bank candidate lookup failed: %v", err)
+		return 0, next, nil, nil, retryableError(err)
+	}
+	bankTxs := bankStatementsFromRows(bankRows)
+	log.Infof("[Reconcile] Loaded %d unconsumed bank candidates", len(bankTxs))
 
-	bankGroups := groupUnmatchedBanks(unmatchedBank, bankBySource)
+	matches, unmatchedSys, unmatchedBank := runMatcherChain([]Matcher{batchMatcher}, systemTxsBatch, bankTxs)
+	log.Infof("[Reconcile] Matched by %s: %d | Unmatched: System=%d, Bank=%d",
+		batchMatcher.Name(), len(matches), len(unmatchedSys), len(unmatchedBank))
 
-	resultSummary, err := buildResultSummary(len(systemTxsBatch), int(matchedCount), unmatchedSys, bankGroups)
+	resultSummary, err := buildResultSummary(len(systemTxsBatch), len(matches), unmatchedSys, nil)
+	if err == nil {
+		err = recordMatches(&resultSummary, matches)
+	}
 	if err != nil {
-		log.Errorf("[Reconcile] Failed to build result: %v", err)
-		return int64(totalSystemRows), int64(len(systemTxsBatch)), "{}"
+		return 0, next, nil, nil, permanentError(consts.JobErrorAmountOverflow, err)
 	}
-
-	return int64(totalSystemRows), int64(len(systemTxsBatch)), resultSummary
+	return int64(len(systemTxsBatch)), next, &resultSummary, matches, nil
 }
 
-func parseSystemTransactions(sourceFile string, startTime, endTime time.Time) ([]entity.Transaction, error) {
+// parseSystemTransactions streams the system file with its column mapping profile from the
+// given position, handing every valid in-window transaction to fn until fn returns false.
+// Rows that cannot be parsed go to onReject. Rows without a currency get defaultCurrency.
+// It returns the position after the last row read.
+func parseSystemTransactions(
+	sourceFile string,
+	profile entity.CsvProfile,
+	startTime, endTime time.Time,
+	defaultCurrency string,
+	from csvPosition,
+	fn func(trx entity.Transaction) bool,
+	onReject rejectFunc,
+) (csvPosition, error) {
 	log.Infof("[SystemParser] Reading system file: %s", sourceFile)
 
-	file, err := os.Open(sourceFile)
+	file, err := openInputFile(sourceFile, consts.FileFormatCSV, profile, from)
 	if err != nil {
-		log.Errorf("[SystemParser] Failed to open file: %v", err)
-		return nil, fmt.Errorf("failed to open system file %s: %w", sourceFile, err)
+		log.Errorf("[SystemParser] Failed to open CSV: %v", err)
+		return from, fmt.Errorf("failed to read system file: %w", err)
 	}
-	defer file.Close()
-
-	reader := csv.NewReader(file)
-	records, err := reader.ReadAll()
-	if err != nil {
-		log.Errorf("[SystemParser] Failed to read CSV: %v", err)
-		return nil, fmt.Errorf("failed to read CSV from system file %s: %w", sourceFile, err)
-	}
-
-	var transactions []entity.Transaction
-	skipped := 0
+	defer file.close()
+	layout := file.layout
+
+	parsed, skipped := 0, 0
+	for {
+		record, line, err := file.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Errorf("[SystemParser] Failed to read CSV: %v", err)
+			return from, fmt.Errorf("failed to read system file %s: %w", sourceFile, err)
+		}
 
-	for i, record := range records {
-		if i == 0 || len(record) < 4 || strings.TrimSpace(record[0]) == "" {
+		trxID := layout.value(record, consts.CsvFieldTrxID)
+		if !layout.hasRequiredFields(record) {
+			skipped++
+			onReject.add(line, file.raw(), "insufficient fields")
+			continue
+		}
+		if trxID == "" {
 			skipped++
+			onReject.add(line, file.raw(), "missing trx id")
 			continue
 		}
 
-		amount, err1 := strconv.ParseFloat(strings.TrimSpace(record[1]), 64)
-		txTime, err2 := time.Parse(time.RFC3339, strings.TrimSpace(record[3]))
-		if err1 != nil || err2 != nil || txTime.Before(startTime) || txTime.After(endTime) {
+		currency := rowCurrency(layout, record, defaultCurrency)
+		amount, err := layout.parseAmount(layout.value(record, consts.CsvFieldAmount), currency)
+		if err != nil {
 			skipped++
+			onReject.add(line, file.raw(), err.Error())
 			continue
 		}
+		rawTime := layout.value(record, consts.CsvFieldTransactionTime)
+		txTime, err := layout.parseTime(rawTime, startTime.Location())
+		if err != nil {
+			skipped++
+			onReject.add(line, file.raw(), fmt.Sprintf("invalid transaction time '%s'", rawTime))
+			continue
+		}
+		if txTime.Before(startTime) || txTime.After(endTime) {
+			skipped++
+			continue
+		}
+		txTime = txTime.In(startTime.Location())
 
-		transactions = append(transactions, entity.Transaction{
-			TrxID:           strings.TrimSpace(record[0]),
+		parsed++
+		more := fn(entity.Transaction{
+			TrxID:           trxID,
 			Amount:          amount,
-			Type:            strings.ToUpper(strings.TrimSpace(record[2])),
+			Type:            strings.ToUpper(layout.value(record, consts.CsvFieldType)),
 			TransactionTime: txTime,
+			Currency:        currency,
 		})
+		if !more {
+			break
+		}
 	}
 
-	log.Infof("[SystemParser] Parsed %d transactions, skipped %d invalid rows", len(transactions), skipped)
-	return transactions, nil
+	log.Infof("[SystemParser] Parsed %d transactions, skipped %d invalid rows", parsed, skipped)
+	return file.position(), nil
 }
 
-func parseBankStatements(sourceFile string, startTime, endTime time.Time) ([]entity.BankStatement, error) {
+// parseBankStatements streams the bank file, CSV or XLSX as format says, with its column
+// mapping profile, handing every valid in-window statement to fn and every row that cannot
+// be parsed to onReject. bankDate picks the date rows are windowed by and carry as Date.
+// An error from fn stops the read and is returned.
+func parseBankStatements(
+	sourceFile string,
+	format string,
+	profile entity.CsvProfile,
+	startTime, endTime time.Time,
+	defaultCurrency string,
+	bankDate string,
+	fn func(b entity.BankStatement) error,
+	onBalance func(entity.StatementBalance),
+	onReject rejectFunc,
+) error {
+	switch format {
+	case consts.FileFormatCamt:
+		return parseCamtStatements(sourceFile, startTime, endTime, defaultCurrency, bankDate, fn, onBalance, onReject)
+	case consts.FileFormatMT940:
+		return parseMT940Statements(sourceFile, startTime, endTime, defaultCurrency, bankDate, fn, onBalance, onReject)
+	}
 	log.Infof("[BankParser] Reading bank statement file: %s", sourceFile)
 
-	file, err := os.Open(sourceFile)
+	file, err := openInputFile(sourceFile, format, profile, csvPosition{})
 	if err != nil {
 		log.Infof("[BankParser] Failed to open file: %v", err)
-		return nil, fmt.Errorf("failed to open bank statement file %s: %w", sourceFile, err)
-	}
-	defer file.Close()
-
-	reader := csv.NewReader(file)
-	records, err := reader.ReadAll()
-	if err != nil {
-		log.Infof("[BankParser] Failed to read CSV: %v", err)
-		return nil, fmt.Errorf("failed to read CSV from bank statement file %s: %w", sourceFile, err)
+		return fmt.Errorf("failed to read bank statement file: %w", err)
 	}
-
-	// Truncate start and end time to date only
-	startDate := time.Date(startTime.Year(), startTime.Month(), startTime.Day(), 0, 0, 0, 0, startTime.Location())
-	endDate := time.Date(endTime.Year(), endTime.Month(), endTime.Day(), 0, 0, 0, 0, endTime.Location())
-
-	var statements []entity.BankStatement
-	for i, record := range records {
-		if i == 0 {
-			continue // skip header
+	defer file.close()
+	layout := file.layout
+
+	// Bank dates are calendar days, compared with the window's days in the job's time zone
+	location := startTime.Location()
+	startDate, endDate := calendarDay(startTime), calendarDay(endTime)
+
+	parsed := 0
+	for {
+		record, line, err := file.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Infof("[BankParser] Failed to read CSV: %v", err)
+			return fmt.Errorf("failed to read bank statement file %s: %w", sourceFile, err)
 		}
-		if len(record) < 3 {
-			log.Infof("[BankParser] Skipping row %d: insufficient fields (%v)", i, record)
+
+		if !layout.hasRequiredFields(record) {
+			log.Infof("[BankParser] Skipping line %d: insufficient fields (%v)", line, record)
+			onReject.add(line, file.raw(), "insufficient fields")
 			continue
 		}
 
-		amount, err := strconv.ParseFloat(record[1], 64)
+		currency := rowCurrency(layout, record, defaultCurrency)
+		amount, direction, err := layout.bankAmount(record, currency)
 		if err != nil {
-			log.Infof("[BankParser] Skipping row %d: invalid amount '%s'", i, record[1])
+			log.Infof("[BankParser] Skipping line %d: %v", line, err)
+			onReject.add(line, file.raw(), err.Error())
 			continue
 		}
 
-		date, err := time.Parse("2006-01-02", record[2])
+		rawDate := layout.value(record, consts.CsvFieldDate)
+		date, err := layout.parseTime(rawDate, location)
 		if err != nil {
-			log.Infof("[BankParser] Skipping row %d: invalid date format '%s'", i, record[2])
+			log.Infof("[BankParser] Skipping line %d: invalid date format '%s'", line, rawDate)
+			onReject.add(line, file.raw(), fmt.Sprintf("invalid date format '%s'", rawDate))
 			continue
 		}
+		var valueDate *time.Time
+		if rawValueDate := layout.value(record, consts.CsvFieldValueDate); rawValueDate != "" {
+			value, err := layout.parseTime(rawValueDate, location)
+			if err != nil {
+				log.Infof("[BankParser] Skipping line %d: invalid value date format '%s'", line, rawValueDate)
+				onReject.add(line, file.raw(), fmt.Sprintf("invalid value date format '%s'", rawValueDate))
+				continue
+			}
+			value = calendarDay(value.In(location))
+			valueDate = &value
+		}
 
-		// Truncate parsed date to just the date
-		dateOnly := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, date.Location())
-
-		log.Infof("[BankParser] Row %d date check: date=%s | startDate=%s | endDate=%s",
-			i,
-			dateOnly.Format("2006-01-02"),
-			startDate.Format("2006-01-02"),
-			endDate.Format("2006-01-02"),
-		)
-
+		dateOnly := statementDate(calendarDay(date.In(location)), valueDate, bankDate)
 		if dateOnly.Before(startDate) || dateOnly.After(endDate) {
-			log.Infof("[BankParser] Skipping row %d: date out of range", i)
+			log.Infof("[BankParser] Skipping line %d: date out of range", line)
 			continue
 		}
 
-		statements = append(statements, entity.BankStatement{
-			UniqueIdentifier: record[0],
+		parsed++
+		err = fn(entity.BankStatement{
+			UniqueIdentifier: layout.value(record, consts.CsvFieldUniqueIdentifier),
 			Amount:           amount,
 			Date:             dateOnly,
+			Currency:         currency,
+			Description:      layout.value(record, consts.CsvFieldDescription),
+			RowNumber:        int64(line),
+			ValueDate:        valueDate,
+			Direction:        direction,
 		})
+		if err != nil {
+			return err
+		}
 	}
 
-	log.Infof("[BankParser] Parsed %d valid bank statements", len(statements))
-	return statements, nil
+	log.Infof("[BankParser] Parsed %d valid bank statements", parsed)
+	return nil
+}
+
+func rowCurrency(layout csvLayout, record []string, defaultCurrency string) string {
+	if currency := entity.NormalizeCurrency(layout.value(record, consts.CsvFieldCurrency)); currency != "" {
+		return currency
+	}
+	return entity.NormalizeCurrency(defaultCurrency)
+}
+
+func findHeaderIndex(header []string, name string) int {
+	for i, column := range header {
+		if strings.EqualFold(strings.TrimSpace(column), name) {
+			return i
+		}
+	}
+	return -1
 }
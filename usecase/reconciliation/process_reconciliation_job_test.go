@@ -0,0 +1,341 @@
+package reconciliation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/radhian/reconciliation-system/consts"
+	"github.com/radhian/reconciliation-system/entity"
+	"github.com/radhian/reconciliation-system/infra/db/dao"
+	"github.com/radhian/reconciliation-system/infra/db/model"
+)
+
+// memoryDao keeps one job in memory. It implements the part of dao.DaoMethod a job run
+// without a heartbeat uses; WithTransaction applies writes directly.
+type memoryDao struct {
+	dao.DaoMethod
+	logEntry  model.ReconciliationProcessLog
+	assets    []model.ReconciliationProcessLogAsset
+	bankRows  []model.ReconciliationBankRow
+	consumed  map[string]bool
+	matches   []model.ReconciliationMatch
+	unmatched []model.ReconciliationUnmatchedTransaction
+	rejected  []model.ReconciliationRejectedRow
+	nextID    int64
+}
+
+func (d *memoryDao) newID() int64 {
+	d.nextID++
+	return d.nextID
+}
+
+func (d *memoryDao) WithTransaction(fn func(txDao dao.DaoMethod) error) error {
+	return fn(d)
+}
+
+func (d *memoryDao) GetReconciliationProcessLogByID(logID uint) (model.ReconciliationProcessLog, error) {
+	return d.logEntry, nil
+}
+
+func (d *memoryDao) UpdateReconciliationProcessLog(logEntry model.ReconciliationProcessLog) error {
+	d.logEntry = logEntry
+	return nil
+}
+
+func (d *memoryDao) GetReconciliationLogAssetsByLogID(logID uint) ([]model.ReconciliationProcessLogAsset, error) {
+	return append([]model.ReconciliationProcessLogAsset(nil), d.assets...), nil
+}
+
+func (d *memoryDao) UpdateReconciliationProcessLogAssetStageTime(assetID, stageTime int64) error {
+	for i := range d.assets {
+		if d.assets[i].ID == assetID {
+			d.assets[i].StageTime = stageTime
+		}
+	}
+	return nil
+}
+
+func (d *memoryDao) CreateReconciliationBankRows(rows []model.ReconciliationBankRow) error {
+	for _, r := range rows {
+		r.ID = d.newID()
+		d.bankRows = append(d.bankRows, r)
+	}
+	return nil
+}
+
+func (d *memoryDao) GetUnconsumedReconciliationBankRows(logID uint, filter dao.BankRowFilter) ([]model.ReconciliationBankRow, error) {
+	selected := func(r model.ReconciliationBankRow) bool {
+		for _, key := range filter.MatchKeys {
+			if r.MatchKey == key {
+				return true
+			}
+		}
+		for _, key := range filter.GroupKeys {
+			if r.GroupKey == key {
+				return true
+			}
+		}
+		for _, reference := range filter.References {
+			if r.Reference == reference {
+				return true
+			}
+		}
+		for _, rg := range filter.Ranges {
+			if r.GroupKey == rg.GroupKey && r.AbsAmount >= rg.Min && r.AbsAmount <= rg.Max {
+				return true
+			}
+		}
+		return false
+	}
+
+	var rows []model.ReconciliationBankRow
+	for _, r := range d.bankRows {
+		if !d.consumed[bankRowKey(r.SourceFile, r.RowNumber)] && selected(r) {
+			rows = append(rows, r)
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].AssetID != rows[j].AssetID {
+			return rows[i].AssetID < rows[j].AssetID
+		}
+		return rows[i].RowNumber < rows[j].RowNumber
+	})
+	return rows, nil
+}
+
+func (d *memoryDao) GetUnconsumedReconciliationBankRowsByLogID(logID uint, afterID int64, limit int) ([]model.ReconciliationBankRow, error) {
+	var rows []model.ReconciliationBankRow
+	for _, r := range d.bankRows {
+		if r.ID > afterID && len(rows) < limit && !d.consumed[bankRowKey(r.SourceFile, r.RowNumber)] {
+			rows = append(rows, r)
+		}
+	}
+	return rows, nil
+}
+
+func (d *memoryDao) DeleteReconciliationBankRowsByLogID(logID uint) error {
+	d.bankRows = nil
+	return nil
+}
+
+func (d *memoryDao) DeleteReconciliationBankRowsByAssetID(assetID uint) error {
+	var kept []model.ReconciliationBankRow
+	for _, r := range d.bankRows {
+		if r.AssetID != int64(assetID) {
+			kept = append(kept, r)
+		}
+	}
+	d.bankRows = kept
+	return nil
+}
+
+func (d *memoryDao) CreateReconciliationBankConsumption(consumption *model.ReconciliationBankConsumption) error {
+	key := bankRowKey(consumption.SourceFile, consumption.RowNumber)
+	if d.consumed[key] {
+		return fmt.Errorf("bank row %s consumed twice", key)
+	}
+	d.consumed[key] = true
+	return nil
+}
+
+func (d *memoryDao) CreateReconciliationMatch(match *model.ReconciliationMatch) error {
+	d.matches = append(d.matches, *match)
+	return nil
+}
+
+func (d *memoryDao) CreateReconciliationUnmatchedTransactions(rows []model.ReconciliationUnmatchedTransaction) error {
+	for _, r := range rows {
+		r.ID = d.newID()
+		d.unmatched = append(d.unmatched, r)
+	}
+	return nil
+}
+
+func (d *memoryDao) GetReconciliationUnmatchedTransactionsByLogID(logID uint, afterID int64, limit int) ([]model.ReconciliationUnmatchedTransaction, error) {
+	var rows []model.ReconciliationUnmatchedTransaction
+	for _, r := range d.unmatched {
+		if r.ID > afterID && len(rows) < limit {
+			rows = append(rows, r)
+		}
+	}
+	return rows, nil
+}
+
+func (d *memoryDao) DeleteReconciliationUnmatchedTransactionsByLogID(logID uint) error {
+	d.unmatched = nil
+	return nil
+}
+
+func (d *memoryDao) CreateReconciliationRejectedRows(rows []model.ReconciliationRejectedRow) error {
+	for _, r := range rows {
+		r.ID = d.newID()
+		d.rejected = append(d.rejected, r)
+	}
+	return nil
+}
+
+const (
+	testSystemCSV = `trxID,amount,type,transactionTime,currency
+T01,100,CREDIT,2024-06-01T08:00:00Z,IDR
+T02,100,CREDIT,2024-06-01T09:00:00Z,IDR
+T03,250,DEBIT,2024-06-02T10:00:00Z,IDR
+T04,100,CREDIT,2024-06-02T11:00:00Z,IDR
+T05,999,CREDIT,2024-06-02T11:00:00Z,IDR
+T06,1000,CREDIT,2024-06-02T12:00:00Z,IDR
+T07,100,CREDIT,2024-06-02T12:00:00Z,USD
+T08,60,CREDIT,2024-06-03T08:00:00Z,IDR
+T09,40,CREDIT,2024-06-03T09:00:00Z,IDR
+T10,90,DEBIT,2024-06-03T10:00:00Z,IDR
+T11,777,CREDIT,2024-06-02T10:00:00Z,IDR
+T12,abc,CREDIT,2024-06-02T10:00:00Z,IDR
+T13,300,CREDIT,2024-06-09T10:00:00Z,IDR
+T14,1000,CREDIT,2024-06-04T12:00:00Z,IDR
+`
+
+	testBankCSV = `unique_identifier,amount,date,currency,description
+B01,100,2024-06-01,IDR,
+B02,100,2024-06-02,IDR,
+B03,-250,2024-06-02,IDR,
+B04,100,2024-06-03,IDR,
+B05,500,2024-06-02,IDR,payment INV T05
+B06,1004,2024-06-02,IDR,
+B07,1600500,2024-06-02,IDR,
+B08,100,2024-06-03,IDR,
+B09,-50,2024-06-03,IDR,
+B10,-40,2024-06-03,IDR,
+B11,333,2024-06-02,IDR,
+B12,1.5.0,2024-06-02,IDR,
+B13,996,2024-06-04,IDR,
+`
+
+	testFxCSV = `from_currency,to_currency,rate
+USD,IDR,16000
+`
+)
+
+// runTestJob runs the fixture job to the end in batches of batchSize and returns its
+// result and match records.
+func runTestJob(t *testing.T, batchSize int64) (entity.ReconciliationResult, []model.ReconciliationMatch, []model.ReconciliationRejectedRow) {
+	t.Helper()
+	dir := t.TempDir()
+	files := map[string]string{"system.csv": testSystemCSV, "bank.csv": testBankCSV, "fx.csv": testFxCSV}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	metadata := entity.ProcessMetadata{
+		StartTime:              1717200000, // 2024-06-01T00:00:00Z
+		EndTime:                1717631999, // 2024-06-05T23:59:59Z
+		MaxDateLagDays:         1,
+		MatchRules:             []string{consts.MatchRuleAmountDateWindow, consts.MatchRuleReferenceID, consts.MatchRuleAmountTolerance, consts.MatchRuleFxTolerance},
+		AmountTolerancePercent: entity.MustParseAmount("0.5"),
+		MaxGroupSize:           3,
+		ReferenceExtraction:    &entity.ReferenceExtraction{Field: consts.ReferenceFieldDescription, Pattern: `INV (T\d+)`},
+	}
+	processInfo, err := json.Marshal(metadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := &memoryDao{
+		logEntry: model.ReconciliationProcessLog{ID: 1, ProcessInfo: string(processInfo), Status: consts.StatusInit},
+		assets: []model.ReconciliationProcessLogAsset{
+			{ID: 1, ReconciliationProcessLogID: 1, DataType: consts.DataTypeSystemFile, FileName: "system.csv", FileUrl: filepath.Join(dir, "system.csv")},
+			{ID: 2, ReconciliationProcessLogID: 1, DataType: consts.DataTypeBankStatement, FileName: "bank.csv", FileUrl: filepath.Join(dir, "bank.csv")},
+			{ID: 3, ReconciliationProcessLogID: 1, DataType: consts.DataTypeFxRate, FileName: "fx.csv", FileUrl: filepath.Join(dir, "fx.csv")},
+		},
+		consumed: make(map[string]bool),
+	}
+	u := &reconciliationUsecase{dao: d, batchSize: batchSize}
+
+	for runs := 0; d.logEntry.Status != consts.StatusFinished; runs++ {
+		if runs > 100 {
+			t.Fatalf("job not finished after %d batches of %d", runs, batchSize)
+		}
+		if err := u.ProcessReconciliationJob(context.Background(), 1); err != nil {
+			t.Fatalf("batch %d of %d rows: %v", runs+1, batchSize, err)
+		}
+	}
+
+	var result entity.ReconciliationResult
+	if err := json.Unmarshal([]byte(d.logEntry.Result), &result); err != nil {
+		t.Fatalf("result %q: %v", d.logEntry.Result, err)
+	}
+	for i := range d.matches {
+		d.matches[i].CreateTime = 0
+	}
+	for i := range d.rejected {
+		d.rejected[i].ID, d.rejected[i].CreateTime = 0, 0
+	}
+	return result, d.matches, d.rejected
+}
+
+func TestProcessReconciliationJob(t *testing.T) {
+	result, matches, rejected := runTestJob(t, 1000)
+
+	var got []string
+	for _, m := range matches {
+		got = append(got, fmt.Sprintf("%s=%s %s %s", m.SystemTrxID, m.BankIdentifier, m.MatchRule, m.AmountDifference))
+	}
+	sort.Strings(got)
+	want := []string{
+		"T01=B01 amount_date_window 0",
+		"T02=B02 amount_date_window 0",
+		"T03=B03 amount_date_window 0",
+		// B02 went to the earlier T02; B04 is a day later.
+		"T04=B04 amount_date_window 0",
+		"T05=B05 reference_id -499",
+		// B06 and B13 are as close in amount; the closer date decides.
+		"T06=B06 amount_tolerance 4",
+		"T07=B07 fx_tolerance 500",
+		"T08=B08 aggregate 0",
+		"T09=B08 aggregate 0",
+		"T10=B09 aggregate 0",
+		"T10=B10 aggregate 0",
+		"T14=B13 amount_tolerance -4",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("matches =\n%v\nwant\n%v", got, want)
+	}
+
+	if result.TotalProcessed != 12 || result.Unmatched != 1 {
+		t.Errorf("processed %d, unmatched %d; want 12 and 1", result.TotalProcessed, result.Unmatched)
+	}
+	if len(result.SystemUnmatched) != 1 || result.SystemUnmatched[0].TrxID != "T11" {
+		t.Errorf("system unmatched = %+v, want T11", result.SystemUnmatched)
+	}
+	if bank := result.BankUnmatchedBySrc["bank.csv"]; len(bank) != 1 || bank[0].UniqueIdentifier != "B11" {
+		t.Errorf("bank unmatched = %+v, want B11", bank)
+	}
+	if len(rejected) != 2 {
+		t.Errorf("rejected rows = %+v, want T12 and B12", rejected)
+	}
+}
+
+// Every batch only sees the transactions before it, so a run in small batches must end
+// with the same result and matches as a run over the whole file at once.
+func TestProcessReconciliationJobBatches(t *testing.T) {
+	wantResult, wantMatches, wantRejected := runTestJob(t, 1000)
+	for _, batchSize := range []int64{1, 2, 3, 5} {
+		result, matches, rejected := runTestJob(t, batchSize)
+		if !reflect.DeepEqual(result, wantResult) {
+			got, _ := json.Marshal(result)
+			want, _ := json.Marshal(wantResult)
+			t.Errorf("batches of %d: result\n%s\nwant\n%s", batchSize, got, want)
+		}
+		if !reflect.DeepEqual(matches, wantMatches) {
+			t.Errorf("batches of %d: matches\n%+v\nwant\n%+v", batchSize, matches, wantMatches)
+		}
+		if !reflect.DeepEqual(rejected, wantRejected) {
+			t.Errorf("batches of %d: rejected rows\n%+v\nwant\n%+v", batchSize, rejected, wantRejected)
+		}
+	}
+}
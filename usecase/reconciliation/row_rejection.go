@@ -0,0 +1,116 @@
+package reconciliation
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+
+	"github.com/radhian/reconciliation-system/consts"
+	"github.com/radhian/reconciliation-system/entity"
+	"github.com/radhian/reconciliation-system/infra/db/dao"
+	"github.com/radhian/reconciliation-system/infra/db/model"
+)
+
+// rejectedRowStageChunk is how many rejected rows are held before they are written.
+const rejectedRowStageChunk = 1000
+
+// rejectFunc receives the line, the raw text and the reason of every row a parser rejects.
+type rejectFunc func(line int, raw, reason string)
+
+// add reports a rejected row; a nil rejectFunc discards it.
+func (f rejectFunc) add(line int, raw, reason string) {
+	if f != nil {
+		f(line, raw, reason)
+	}
+}
+
+// rejectedRowWriter saves the rejected rows of one job in chunks and counts them per file.
+// The first failed write is kept and returned by every later flush.
+type rejectedRowWriter struct {
+	txDao      dao.DaoMethod
+	logID      int64
+	createTime int64
+	chunk      []model.ReconciliationRejectedRow
+	bySource   map[string]int64
+	err        error
+}
+
+func newRejectedRowWriter(txDao dao.DaoMethod, logID int64, createTime int64) *rejectedRowWriter {
+	return &rejectedRowWriter{txDao: txDao, logID: logID, createTime: createTime, bySource: make(map[string]int64)}
+}
+
+// forAsset returns a rejectFunc recording rows against the asset's file.
+func (w *rejectedRowWriter) forAsset(asset model.ReconciliationProcessLogAsset) rejectFunc {
+	return func(line int, raw, reason string) {
+		w.add(asset, int64(line), raw, reason)
+	}
+}
+
+func (w *rejectedRowWriter) add(asset model.ReconciliationProcessLogAsset, line int64, raw, reason string) {
+	w.chunk = append(w.chunk, model.ReconciliationRejectedRow{
+		ReconciliationProcessLogID: w.logID,
+		DataType:                   asset.DataType,
+		SourceFile:                 asset.FileName,
+		LineNumber:                 line,
+		RawContent:                 raw,
+		Reason:                     reason,
+		CreateTime:                 w.createTime,
+	})
+	w.bySource[asset.FileName]++
+	if len(w.chunk) >= rejectedRowStageChunk {
+		w.flush()
+	}
+}
+
+func (w *rejectedRowWriter) flush() error {
+	if w.err != nil || len(w.chunk) == 0 {
+		return w.err
+	}
+	w.err = w.txDao.CreateReconciliationRejectedRows(w.chunk)
+	w.chunk = w.chunk[:0]
+	return w.err
+}
+
+// summarize adds the rejected row counts to the job's initial result summary. Files are
+// staged one at a time, so each adds its own counts to those of the files before it.
+func (w *rejectedRowWriter) summarize(summary *entity.ReconciliationResult) {
+	for source, count := range w.bySource {
+		if summary.RejectedRowsBySource == nil {
+			summary.RejectedRowsBySource = make(map[string]int64)
+		}
+		summary.RejectedRowsBySource[source] += count
+		summary.RejectedRows += count
+	}
+}
+
+// ExportRejectedRows writes every rejected row of a job to out as CSV, reading them from
+// the database one page at a time. Nothing is written if the first page cannot be read.
+func (u *reconciliationUsecase) ExportRejectedRows(logID int64, out io.Writer) error {
+	writer := csv.NewWriter(out)
+	var afterID int64
+	for first := true; ; first = false {
+		rows, err := u.dao.GetReconciliationRejectedRowsByLogID(uint(logID), afterID, consts.RejectedRowExportPageSize)
+		if err != nil {
+			return err
+		}
+		if first {
+			if err := writer.Write([]string{"source_file", "data_type", "line_number", "reason", "raw_content"}); err != nil {
+				return err
+			}
+		}
+		for _, r := range rows {
+			record := []string{r.SourceFile, strconv.FormatInt(r.DataType, 10), strconv.FormatInt(r.LineNumber, 10), r.Reason, r.RawContent}
+			if err := writer.Write(record); err != nil {
+				return err
+			}
+			afterID = r.ID
+		}
+		writer.Flush()
+		if err := writer.Error(); err != nil {
+			return err
+		}
+		if len(rows) < consts.RejectedRowExportPageSize {
+			return nil
+		}
+	}
+}
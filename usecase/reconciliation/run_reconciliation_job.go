@@ -0,0 +1,66 @@
+package reconciliation
+
+import (
+	"context"
+	"time"
+
+	"github.com/labstack/gommon/log"
+	"github.com/radhian/reconciliation-system/consts"
+)
+
+// RunReconciliationJob runs batches of a job the worker has claimed, one after another,
+// until the job finishes or fails, budget runs out or a job of higher priority is waiting
+// to be picked. Every batch is checkpointed as usual, so stopping early only hands the
+// rest of the job back to the queue. A budget of zero runs a single batch.
+func (u *reconciliationUsecase) RunReconciliationJob(ctx context.Context, logID int64, budget time.Duration) error {
+	deadline := time.Now().Add(budget)
+	for batch := 1; ; batch++ {
+		if err := u.ProcessReconciliationJob(ctx, logID); err != nil {
+			return err
+		}
+		if budget <= 0 {
+			return nil
+		}
+
+		logEntry, err := u.fetchProcessLog(logID)
+		if err != nil {
+			return err
+		}
+		if logEntry.Status != consts.StatusInit && logEntry.Status != consts.StatusRunning {
+			log.Infof("[ReconcileJob] LogID %d ended after %d batches", logID, batch)
+			return nil
+		}
+		if time.Now().After(deadline) {
+			log.Infof("[ReconcileJob] LogID %d used its time budget after %d batches", logID, batch)
+			return nil
+		}
+		waiting, err := u.higherPriorityJobWaiting(logEntry.Priority)
+		if err != nil {
+			return err
+		}
+		if waiting {
+			log.Infof("[ReconcileJob] LogID %d yields to a higher priority job after %d batches", logID, batch)
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+}
+
+// higherPriorityJobWaiting reports whether the next job a worker would pick has a higher
+// priority than priority.
+func (u *reconciliationUsecase) higherPriorityJobWaiting(priority int) (bool, error) {
+	processLogList, err := u.dao.GetSchedulableReconciliationProcessLogs([]int{consts.StatusInit, consts.StatusRunning})
+	if err != nil {
+		return false, err
+	}
+
+	lastOperator, err := u.dao.GetLastClaimedReconciliationOperator()
+	if err != nil {
+		return false, err
+	}
+
+	next := scheduleJobs(processLogList, lastOperator, u.maxRunningPerOperator)
+	return len(next) > 0 && next[0].Priority > priority, nil
+}
@@ -3,25 +3,76 @@ package reconciliation
 import (
 	"context"
 	"log"
+	"time"
 
+	"github.com/radhian/reconciliation-system/consts"
 	"github.com/radhian/reconciliation-system/infra/db/model"
 )
 
-func (u *reconciliationUsecase) TryAcquireLock(ctx context.Context) (bool, int64, error) {
+// TryAcquireLock claims the next unfinished job no other worker holds, in the order
+// scheduleJobs gives, and puts workerID on it until UnlockProcess.
+func (u *reconciliationUsecase) TryAcquireLock(ctx context.Context, workerID string) (bool, int64, error) {
 	var processLogList []model.ReconciliationProcessLog
 
-	processLogList, err := u.dao.GetReconciliationProcessLogByStatusList([]int{1, 2})
+	unfinished := []int{consts.StatusInit, consts.StatusRunning}
+	processLogList, err := u.dao.GetSchedulableReconciliationProcessLogs(unfinished)
 	if err != nil {
 		return false, 0, err
 	}
 
-	for _, processLog := range processLogList {
-		if u.locker.IsProcessing(processLog.ID) {
+	lastOperator, err := u.dao.GetLastClaimedReconciliationOperator()
+	if err != nil {
+		return false, 0, err
+	}
+
+	for _, processLog := range scheduleJobs(processLogList, lastOperator, u.maxRunningPerOperator) {
+		locked, err := u.locker.TryLock(ctx, processLog.ID)
+		if err != nil {
+			return false, 0, err
+		}
+		if !locked {
+			continue
+		}
+
+		// Another worker may have finished or failed the job between the listing and the
+		// claim, so the status is read again under the lock.
+		current, err := u.dao.GetReconciliationProcessLogByID(uint(processLog.ID))
+		if err != nil || (current.Status != consts.StatusInit && current.Status != consts.StatusRunning) {
+			u.UnlockProcess(ctx, processLog.ID)
+			if err != nil {
+				return false, 0, err
+			}
 			continue
 		}
 
-		u.locker.MarkAsProcessing(processLog.ID)
-		log.Printf("[LOCK_PROCESS] log_id:%d", processLog.ID)
+		// A job still carrying another worker's heartbeat is left for the reaper to take back.
+		started, err := u.startHeartbeat(processLog.ID, workerID)
+		if err != nil || !started {
+			u.UnlockProcess(ctx, processLog.ID)
+			if err != nil {
+				return false, 0, err
+			}
+			continue
+		}
+
+		// Workers elsewhere may have started jobs of the same operator since the listing.
+		if u.maxRunningPerOperator > 0 {
+			running, err := u.dao.CountReconciliationProcessLogsWithWorker(unfinished, processLog.CreateBy)
+			if err != nil || running > int64(u.maxRunningPerOperator) {
+				u.UnlockProcess(ctx, processLog.ID)
+				if err != nil {
+					return false, 0, err
+				}
+				continue
+			}
+		}
+
+		// The turn passes on even if this is not recorded; the next pick may then repeat it.
+		if err := u.dao.UpdateReconciliationProcessLogClaimTime(processLog.ID, time.Now().Unix()); err != nil {
+			log.Printf("[LOCK_PROCESS] log_id:%d error: %v", processLog.ID, err)
+		}
+
+		log.Printf("[LOCK_PROCESS] log_id:%d worker:%s", processLog.ID, workerID)
 		return true, processLog.ID, nil
 	}
 
@@ -29,6 +80,10 @@ func (u *reconciliationUsecase) TryAcquireLock(ctx context.Context) (bool, int64
 }
 
 func (u *reconciliationUsecase) UnlockProcess(ctx context.Context, logsID int64) {
-	u.locker.Unlock(logsID)
+	u.stopHeartbeat(logsID)
+	if err := u.locker.Unlock(ctx, logsID); err != nil {
+		log.Printf("[UNLOCK_PROCESS] log_id:%d error: %v", logsID, err)
+		return
+	}
 	log.Printf("[UNLOCK_PROCESS] log_id:%d", logsID)
 }
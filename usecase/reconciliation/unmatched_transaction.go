@@ -0,0 +1,59 @@
+package reconciliation
+
+import (
+	"time"
+
+	"github.com/radhian/reconciliation-system/entity"
+	"github.com/radhian/reconciliation-system/infra/db/dao"
+	"github.com/radhian/reconciliation-system/infra/db/model"
+)
+
+// unmatchedTransactionPageSize is how many saved unmatched transactions are read at a time.
+const unmatchedTransactionPageSize = 1000
+
+// saveUnmatchedTransactions keeps the transactions a batch left unmatched until the last
+// batch, so the job-wide result does not have to carry them from batch to batch.
+func saveUnmatchedTransactions(txDao dao.DaoMethod, logID int64, txs []entity.Transaction, createTime int64) error {
+	if len(txs) == 0 {
+		return nil
+	}
+	rows := make([]model.ReconciliationUnmatchedTransaction, 0, len(txs))
+	for _, trx := range txs {
+		rows = append(rows, model.ReconciliationUnmatchedTransaction{
+			ReconciliationProcessLogID: logID,
+			TrxID:                      trx.TrxID,
+			Amount:                     trx.Amount,
+			Type:                       trx.Type,
+			TransactionTime:            trx.TransactionTime.Unix(),
+			Currency:                   trx.Currency,
+			CreateTime:                 createTime,
+		})
+	}
+	return txDao.CreateReconciliationUnmatchedTransactions(rows)
+}
+
+// fetchUnmatchedTransactions returns every transaction earlier batches left unmatched, in
+// file order, with transaction times in the job's time zone.
+func (u *reconciliationUsecase) fetchUnmatchedTransactions(logID int64, location *time.Location) ([]entity.Transaction, error) {
+	var txs []entity.Transaction
+	afterID := int64(0)
+	for {
+		rows, err := u.dao.GetReconciliationUnmatchedTransactionsByLogID(uint(logID), afterID, unmatchedTransactionPageSize)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range rows {
+			txs = append(txs, entity.Transaction{
+				TrxID:           r.TrxID,
+				Amount:          r.Amount,
+				Type:            r.Type,
+				TransactionTime: time.Unix(r.TransactionTime, 0).In(location),
+				Currency:        r.Currency,
+			})
+			afterID = r.ID
+		}
+		if len(rows) < unmatchedTransactionPageSize {
+			return txs, nil
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package reconciliation
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/radhian/reconciliation-system/consts"
+	"github.com/radhian/reconciliation-system/entity"
+	"github.com/radhian/reconciliation-system/infra/db/model"
+)
+
+// ValidateFiles runs the system and bank parsers over the files of a would-be job without
+// recording anything, and reports per file what the job would read and reject.
+func (u *reconciliationUsecase) ValidateFiles(transactionFile entity.ReconciliationFile, referenceFiles []entity.ReconciliationFile, processInfo entity.ProcessMetadata, maxErrors int) entity.FileValidationResult {
+	result := entity.FileValidationResult{Valid: true}
+	result.Files = append(result.Files, u.validateFile(transactionFile, consts.DataTypeSystemFile, processInfo, maxErrors))
+	for _, ref := range referenceFiles {
+		result.Files = append(result.Files, u.validateFile(ref, consts.DataTypeBankStatement, processInfo, maxErrors))
+	}
+
+	for _, file := range result.Files {
+		if file.FileError != "" || file.ErrorCount > 0 {
+			result.Valid = false
+		}
+	}
+	return result
+}
+
+func (u *reconciliationUsecase) validateFile(file entity.ReconciliationFile, dataType int64, processInfo entity.ProcessMetadata, maxErrors int) entity.FileValidation {
+	report := entity.FileValidation{Path: file.Path, DataType: dataType, Profile: file.Profile, Errors: []entity.RowError{}}
+
+	config, err := u.loadCsvProfileConfig(file.Profile, dataType)
+	if err != nil {
+		report.FileError = err.Error()
+		return report
+	}
+	profile, err := parseProfileOf(model.ReconciliationProcessLogAsset{DataType: dataType, FileName: file.Path, ParseProfile: config})
+	if err != nil {
+		report.FileError = err.Error()
+		return report
+	}
+	sourcePath, err := u.resolveInputPath(file.Path)
+	if err != nil {
+		report.FileError = err.Error()
+		return report
+	}
+
+	// Nothing read from a file is echoed back until the file is known to be of its format:
+	// a CSV or XLSX file whose header has the profile's columns, or a camt or MT940 file
+	// with at least one statement entry.
+	format := fileFormatOf(file)
+	if usesCsvProfile(format) {
+		header, err := detectHeader(sourcePath, format, profile)
+		if err != nil {
+			report.FileError = err.Error()
+			return report
+		}
+		if _, err := newCsvLayout(profile, header); err != nil {
+			report.FileError = fmt.Sprintf("%s: %v", file.Path, err)
+			return report
+		}
+		report.Headers = header
+	}
+
+	onReject := func(line int, raw, reason string) {
+		report.ErrorCount++
+		if len(report.Errors) < maxErrors {
+			report.Errors = append(report.Errors, entity.RowError{Line: int64(line), Reason: reason, RawContent: raw})
+		}
+	}
+
+	var earliest, latest time.Time
+	observe := func(date time.Time, inWindow bool) {
+		report.ValidRows++
+		if inWindow {
+			report.InWindowRows++
+		}
+		if earliest.IsZero() || date.Before(earliest) {
+			earliest = date
+		}
+		if latest.IsZero() || date.After(latest) {
+			latest = date
+		}
+	}
+
+	// The parsers are given an unbounded window so the date range covers every valid row;
+	// the request window is applied here the same way the parsers apply it.
+	startTime, endTime, err := requestTimeRange(processInfo)
+	if err != nil {
+		report.FileError = err.Error()
+		return report
+	}
+	location := startTime.Location()
+	noStart, noEnd := time.Time{}.In(location), time.Date(9999, 12, 31, 23, 59, 59, 0, location)
+	if dataType == consts.DataTypeSystemFile {
+		_, err = parseSystemTransactions(sourcePath, profile, noStart, noEnd, processInfo.DefaultCurrency, csvPosition{},
+			func(trx entity.Transaction) bool {
+				observe(trx.TransactionTime, !trx.TransactionTime.Before(startTime) && !trx.TransactionTime.After(endTime))
+				return true
+			}, onReject)
+	} else {
+		startDate, endDate := calendarDay(startTime), calendarDay(endTime)
+		err = parseBankStatements(sourcePath, format, profile, noStart, noEnd, processInfo.DefaultCurrency, processInfo.BankDate,
+			func(b entity.BankStatement) error {
+				observe(b.Date, !b.Date.Before(startDate) && !b.Date.After(endDate))
+				return nil
+			}, func(balance entity.StatementBalance) {
+				balance.SourceFile = file.Path
+				report.StatementBalances = append(report.StatementBalances, balance)
+			}, onReject)
+	}
+	if err != nil {
+		report.FileError = err.Error()
+	}
+	if !usesCsvProfile(format) && report.ValidRows == 0 {
+		report.Errors = []entity.RowError{}
+		if err != nil {
+			report.FileError = fmt.Sprintf("%s is not a %s file", file.Path, format)
+		}
+	}
+
+	report.TotalRows = report.ValidRows + report.ErrorCount
+	if report.ValidRows > 0 {
+		report.EarliestDate = earliest.Format("2006-01-02")
+		report.LatestDate = latest.Format("2006-01-02")
+	}
+	return report
+}